@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultCommandTimeout is how long the devices targeted by a
+	// command have to accept it unless the command says otherwise
+	DefaultCommandTimeout = 5 * time.Second
+)
+
+var (
+	errCommandTimeout = errors.New("command: device didn't accept the command in time")
+	errNoTargets      = errors.New("command: no devices match the target")
+)
+
+// CommandExecute is an Execute command kept in the definer's own
+// files, such as in an automation rule or a scene
+type CommandExecute struct {
+	XMLName    xml.Name `xml:"execute"`
+	Core       string   `xml:"core,attr"`
+	Parameters []string `xml:"parameter"`
+}
+
+// deviceOutcome is the result of a single device's part in a command
+type deviceOutcome struct {
+	index int
+	conn  StackConn
+	err   error
+}
+
+// RunCommand checks the command in the packet against the schema of
+// every device it targets, then sends the packet to them all in
+// parallel and reports the outcome for each device. Replies from the
+// devices are sent to the packet's origin, over the writer it arrived
+// on unless the origin is a definer.
+func (handler *Handler) RunCommand(packet *packets.Packet, writer io.Writer) (*packets.CommandResponse, error) {
+	command := packet.GetCommand()
+	devices, validateErr := handler.validateCommand(command)
+	if validateErr != nil {
+		return nil, validateErr
+	}
+	data, protoErr := proto.Marshal(packet)
+	if protoErr != nil {
+		return nil, protoErr
+	}
+	handler.replies.Expect(packet.GetHeader().Id, packet.GetHeader().Origin, writer)
+	return handler.DispatchCommand(devices, data, command), nil
+}
+
+// validateCommand checks the command against the schema of its
+// device type and of every device it targets, returning the devices.
+// It fails if the command targets no devices at all.
+func (handler *Handler) validateCommand(command *packets.Command) ([]*Device, error) {
+	deviceType := commandType(command)
+	devices, targetErr := handler.deviceManager.GetTargets(commandTarget(command))
+	if targetErr != nil {
+		return nil, targetErr
+	}
+	if len(devices) == 0 {
+		return nil, errNoTargets
+	}
+	if validateErr := handler.schemas.Validate(deviceType, command); validateErr != nil {
+		return nil, validateErr
+	}
+	for _, device := range devices {
+		if validateErr := handler.schemas.Validate(device.Type, command); validateErr != nil {
+			return nil, validateErr
+		}
+	}
+	return devices, nil
+}
+
+// DispatchCommand sends the data holding the command to every device
+// in parallel, giving them all until the same deadline. Each device
+// waits its turn in the dispatch queue. Devices that can't be reached
+// have the data queued for them, unless the command is all or nothing,
+// in which case every device is connected to first and the data is
+// only sent if they all could be. A write can still fail after every
+// device was reached, and that device alone is reported as failed.
+// Nothing is sent or queued once the deadline has passed, so a device
+// reported as timed out doesn't receive the command later. The
+// outcome for each device is reported.
+func (handler *Handler) DispatchCommand(devices []*Device, data []byte, command *packets.Command) *packets.CommandResponse {
+	timeout := DefaultCommandTimeout
+	if command.Timeout > 0 {
+		timeout = time.Duration(command.Timeout) * time.Millisecond
+	}
+	cancel := make(chan struct{})
+	deadline := time.AfterFunc(timeout, func() {
+		close(cancel)
+	})
+	defer func() {
+		if deadline.Stop() {
+			close(cancel)
+		}
+	}()
+	if !command.AllOrNothing {
+		_, errs := parallel(len(devices), cancel, func(index int) (StackConn, error) {
+			return handler.dispatch.Do(devices[index].ID, command, cancel, func() (StackConn, error) {
+				return nil, handler.sendOrQueueData(devices[index], data, cancel)
+			})
+		})
+		return commandResponse(devices, errs)
+	}
+	return handler.dispatchAllOrNothing(devices, data, command, cancel)
+}
+
+// dispatchAllOrNothing connects to each device in its turn and, once
+// every device has been tried, sends the data to them all or to none.
+// Only the dialing happens within the device's turn, so it is what
+// the device's rate limit counts, and no turn is held while waiting
+// on the other devices. When the command is abandoned the devices
+// that were reached report those that couldn't be.
+func (handler *Handler) dispatchAllOrNothing(devices []*Device, data []byte, command *packets.Command, cancel <-chan struct{}) *packets.CommandResponse {
+	dialed := make(chan deviceOutcome, len(devices))
+	decided := make(chan struct{})
+	var abortErr error
+	go func() {
+		defer close(decided)
+		failed := make([]bool, len(devices))
+		for remaining := len(devices); remaining > 0; remaining-- {
+			select {
+			case outcome := <-dialed:
+				failed[outcome.index] = outcome.err != nil
+			case <-cancel:
+				abortErr = errCommandTimeout
+				return
+			}
+		}
+		unreachable := []string{}
+		for i, deviceFailed := range failed {
+			if deviceFailed {
+				unreachable = append(unreachable, devices[i].ID)
+			}
+		}
+		if len(unreachable) > 0 {
+			abortErr = errors.New("command: not sent; couldn't reach " + strings.Join(unreachable, ", "))
+		}
+	}()
+	_, errs := parallel(len(devices), cancel, func(index int) (StackConn, error) {
+		device := devices[index]
+		if handler.transfers.Releasing(device.ID) {
+			transferErr := errors.New("command: device is being transferred")
+			dialed <- deviceOutcome{index: index, err: transferErr}
+			return nil, transferErr
+		}
+		// Commands dropped from the queue never get to dial
+		conn, dialErr := handler.dispatch.Do(device.ID, command, cancel, device.Dial)
+		dialed <- deviceOutcome{index: index, err: dialErr}
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		<-decided
+		if abortErr != nil {
+			conn.Close()
+			return nil, abortErr
+		}
+		if cancelled(cancel) {
+			conn.Close()
+			return nil, errCommandTimeout
+		}
+		return nil, handler.sendAndAwaitReplies(device, conn, data)
+	})
+	return commandResponse(devices, errs)
+}
+
+// cancelled reports whether the channel has been closed
+func cancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// parallel runs the action for every index at once and waits for
+// them all or the deadline, whichever comes first. Actions that
+// haven't finished by the deadline fail with errCommandTimeout and
+// any connection they open afterwards is closed.
+func parallel(count int, deadline <-chan struct{}, action func(index int) (StackConn, error)) ([]StackConn, []error) {
+	conns := make([]StackConn, count)
+	errs := make([]error, count)
+	outcomes := make(chan deviceOutcome, count)
+	for i := 0; i < count; i++ {
+		errs[i] = errCommandTimeout
+		go func(index int) {
+			conn, err := action(index)
+			outcomes <- deviceOutcome{index: index, conn: conn, err: err}
+		}(i)
+	}
+	for remaining := count; remaining > 0; remaining-- {
+		select {
+		case outcome := <-outcomes:
+			conns[outcome.index] = outcome.conn
+			errs[outcome.index] = outcome.err
+		case <-deadline:
+			go closeLate(outcomes, remaining)
+			return conns, errs
+		}
+	}
+	return conns, errs
+}
+
+// closeLate closes the connections opened by actions that
+// finished after their deadline
+func closeLate(outcomes <-chan deviceOutcome, remaining int) {
+	for ; remaining > 0; remaining-- {
+		if outcome := <-outcomes; outcome.conn != nil {
+			outcome.conn.Close()
+		}
+	}
+}
+
+// commandResponse summarizes the outcome for every device
+func commandResponse(devices []*Device, errs []error) *packets.CommandResponse {
+	response := &packets.CommandResponse{Success: true}
+	for i, device := range devices {
+		result := &packets.CommandResponse_Result{Device: device.ID, Success: errs[i] == nil}
+		if errs[i] != nil {
+			response.Success = false
+			result.Queued = errs[i] == errQueued
+			result.Error = errs[i].Error()
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+// buildExecuteCommand builds the command that sends the Execute
+// to every device picked out by the target
+func buildExecuteCommand(target *DeviceTarget, execute *CommandExecute) *packets.Command {
+	device := &packets.Command_Device{
+		Group:   target.Group,
+		Room:    target.Room,
+		Devices: target.Devices,
+	}
+	if target.Type != nil {
+		device.Core = target.Type.Core
+		device.Modifier = target.Type.Modifier
+	}
+	return &packets.Command{
+		Device: device,
+		Body: &packets.Command_Execute{
+			Execute: &packets.Execute{
+				Core:       execute.Core,
+				Parameters: execute.Parameters,
+			},
+		},
+	}
+}
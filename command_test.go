@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// stalledStack is a Stack whose dials hang until released and then fail
+type stalledStack struct {
+	release chan struct{}
+	dialed  chan struct{}
+}
+
+func (stack *stalledStack) Dial(device *Device) (StackConn, error) {
+	stack.dialed <- struct{}{}
+	<-stack.release
+	return nil, errors.New("stack: device unreachable")
+}
+
+func TestCommandWithoutTargetsFails(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	packet := testPacket(handler, "X", packets.Packet_Header_REQUEST)
+	packet.Body = &packets.Packet_Command{Command: &packets.Command{
+		Device: &packets.Command_Device{Core: "light"},
+		Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: "on"}},
+	}}
+	writer := make(packetWriter, 1)
+	if handleErr := handler.HandleCommand(packet, writer); handleErr != errNoTargets {
+		t.Fatalf("expected %v, got %v", errNoTargets, handleErr)
+	}
+	if response := <-writer; response.GetErrorResponse() == nil {
+		t.Fatalf("expected an error response, got %v", response)
+	}
+}
+
+func TestCommandDropsLateWork(t *testing.T) {
+	stack := &stalledStack{release: make(chan struct{}), dialed: make(chan struct{}, 1)}
+	RegisterStack("stalled", stack)
+	defer UnregisterStack("stalled")
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	device := &Device{ID: "d1", Address: "a", Stack: "stalled"}
+	handler.deviceManager.AddDevice(device)
+	command := &packets.Command{Timeout: 50}
+
+	first := make(chan *packets.CommandResponse)
+	go func() {
+		first <- handler.DispatchCommand([]*Device{device}, []byte("on"), command)
+	}()
+	<-stack.dialed
+	// The second command waits behind the first, which is stuck dialing
+	second := handler.DispatchCommand([]*Device{device}, []byte("off"), command)
+	if second.Success || second.Results[0].Error != errCommandTimeout.Error() {
+		t.Fatalf("waiting command should time out: %v", second)
+	}
+	if response := <-first; response.Success || response.Results[0].Error != errCommandTimeout.Error() {
+		t.Fatalf("stalled command should time out: %v", response)
+	}
+	close(stack.release)
+	waitFor(t, "the stalled dial to finish", func() bool {
+		status := handler.dispatch.Status()
+		return len(status) == 1 && status[0].Sent == 1 && status[0].Expired == 1 && len(status[0].Waiting) == 0
+	})
+	time.Sleep(20 * time.Millisecond)
+	if status := handler.outbox.Status(); len(status) != 0 {
+		t.Fatalf("timed out command was queued: %v", status)
+	}
+}
+
+func TestAllOrNothingCommandNeedsEveryDevice(t *testing.T) {
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	devices := []*Device{{ID: "d1", Address: "a", Stack: stackMemory}, {ID: "d2", Address: "b", Stack: stackMemory}}
+	command := &packets.Command{AllOrNothing: true, Timeout: 500}
+
+	response := handler.DispatchCommand(devices, []byte("on"), command)
+	if response.Success || response.Results[0].Error != "command: not sent; couldn't reach d2" || response.Results[1].Success {
+		t.Fatalf("command should be abandoned: %v", response)
+	}
+	select {
+	case data := <-endpoint.toDevice:
+		t.Fatalf("reachable device was sent %q", data)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	otherEndpoint := stack.Attach("b")
+	defer stack.Detach("b")
+	if response := handler.DispatchCommand(devices, []byte("off"), command); !response.Success {
+		t.Fatalf("command should reach both devices: %v", response)
+	}
+	for _, receiver := range []*MemoryEndpoint{endpoint, otherEndpoint} {
+		if data, receiveErr := receiver.Receive(); receiveErr != nil || string(data) != "off" {
+			t.Fatalf("device received %q, %v", data, receiveErr)
+		}
+	}
+	if status := handler.dispatch.Status(); len(status) != 2 || status[0].Sent != 2 || status[1].Sent != 2 {
+		t.Fatalf("unexpected dispatch status: %v", status)
+	}
+}
+
+func TestOverlappingAllOrNothingCommands(t *testing.T) {
+	stack := attachMemoryStack(t)
+	for _, address := range []string{"a", "b"} {
+		stack.Attach(address)
+		defer stack.Detach(address)
+	}
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	d1, d2 := &Device{ID: "d1", Address: "a", Stack: stackMemory}, &Device{ID: "d2", Address: "b", Stack: stackMemory}
+
+	// Hold d2 so that the first command takes d1's turn first and
+	// the safety command jumps ahead of it for d2's
+	started, release := make(chan struct{}), make(chan struct{})
+	go handler.dispatch.Do("d2", &packets.Command{}, nil, func() (StackConn, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	responses := make(chan *packets.CommandResponse, 2)
+	go func() {
+		responses <- handler.DispatchCommand([]*Device{d1, d2}, []byte("on"), &packets.Command{AllOrNothing: true, Timeout: 1000})
+	}()
+	waitFor(t, "the first command to wait for d2", func() bool {
+		for _, status := range handler.dispatch.Status() {
+			if status.Device == "d2" && status.Waiting[packets.Command_NORMAL] == 1 {
+				return true
+			}
+		}
+		return false
+	})
+	go func() {
+		responses <- handler.DispatchCommand([]*Device{d2, d1}, []byte("off"), &packets.Command{AllOrNothing: true, Timeout: 1000, Priority: packets.Command_SAFETY})
+	}()
+	waitFor(t, "the safety command to wait for d2", func() bool {
+		for _, status := range handler.dispatch.Status() {
+			if status.Device == "d2" && status.Waiting[packets.Command_SAFETY] == 1 {
+				return true
+			}
+		}
+		return false
+	})
+	close(release)
+	for i := 0; i < 2; i++ {
+		if response := <-responses; !response.Success {
+			t.Fatalf("overlapping commands should both be sent: %v", response)
+		}
+	}
+}
@@ -12,6 +12,8 @@ type Config struct {
 	Router        *Router        `xml:"router"`
 	DeviceManager *DeviceManager `xml:"devices"`
 	RouterManager *RouterManager `xml:"routers"`
+	Scenes        *SceneSet      `xml:"scenes"`
+	Settings      *Settings      `xml:"settings"`
 }
 
 // InitConfig returns either an unmarshalled Config struct
@@ -26,7 +28,7 @@ func InitConfig(path string) (*Config, error) {
 
 // LoadConfig returns a new Config struct given a path
 func LoadConfig(path string) (*Config, error) {
-	config := &Config{}
+	config := &Config{DeviceManager: BuildDeviceManager(), RouterManager: BuildRouterManager(), Scenes: BuildSceneSet(), Settings: BuildSettings()}
 	configFile, configErr := ioutil.ReadFile(path)
 	if configErr != nil {
 		return nil, configErr
@@ -46,8 +48,10 @@ func BuildConfig() (*Config, error) {
 	}
 	config := &Config{
 		Router:        router,
-		DeviceManager: &DeviceManager{},
-		RouterManager: &RouterManager{},
+		DeviceManager: BuildDeviceManager(),
+		RouterManager: BuildRouterManager(),
+		Scenes:        BuildSceneSet(),
+		Settings:      BuildSettings(),
 	}
 	return config, nil
 }
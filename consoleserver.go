@@ -4,7 +4,12 @@ import (
 	"bufio"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
@@ -17,6 +22,8 @@ type ConsoleServer struct {
 	handler       *Handler
 	router        *Router
 	deviceManager *DeviceManager
+	routerManager *RouterManager
+	watch         *Subscription
 }
 
 // consoleOut represents a console-based output. This is used
@@ -39,16 +46,49 @@ var (
 		//"router": (*ConsoleServer).buildRouterRequest,
 		"device": (*ConsoleServer).handleDevice,
 		//"device-list": (*ConsoleServer).deviceCommand,
+		"outbox":   (*ConsoleServer).handleOutbox,
+		"rule":     (*ConsoleServer).handleRule,
+		"schedule": (*ConsoleServer).handleSchedule,
+		"scene":    (*ConsoleServer).handleScene,
+		"dispatch": (*ConsoleServer).handleDispatch,
 	}
 	routerCommands = map[string]commandHandler{
 		//"packet":
 		"config": (*ConsoleServer).routerConfig,
+		"routes": (*ConsoleServer).routerRoutes,
+		"list":   (*ConsoleServer).routerList,
+	}
+	ruleCommands = map[string]commandHandler{
+		"list":    (*ConsoleServer).ruleList,
+		"add":     (*ConsoleServer).ruleAdd,
+		"remove":  (*ConsoleServer).ruleRemove,
+		"enable":  (*ConsoleServer).ruleEnable,
+		"disable": (*ConsoleServer).ruleDisable,
+		"run":     (*ConsoleServer).ruleRun,
+	}
+	scheduleCommands = map[string]commandHandler{
+		"list":   (*ConsoleServer).scheduleList,
+		"add":    (*ConsoleServer).scheduleAdd,
+		"cancel": (*ConsoleServer).scheduleCancel,
+	}
+	sceneCommands = map[string]commandHandler{
+		"list": (*ConsoleServer).sceneList,
+		"run":  (*ConsoleServer).sceneRun,
 	}
 	routerPackets = map[string]commandHandler{
 
 	}
 	deviceCommands = map[string]commandHandler{
-		"packet": (*ConsoleServer).devicePacket,
+		"packet":  (*ConsoleServer).devicePacket,
+		"pending": (*ConsoleServer).devicePending,
+		"approve": (*ConsoleServer).deviceApprove,
+		"reject":  (*ConsoleServer).deviceReject,
+		"state":   (*ConsoleServer).deviceState,
+		"watch":   (*ConsoleServer).deviceWatch,
+		"unwatch": (*ConsoleServer).deviceUnwatch,
+		"groups":  (*ConsoleServer).deviceGroups,
+		"group":   (*ConsoleServer).deviceGroup,
+		"room":    (*ConsoleServer).deviceRoom,
 	}
 	devicePackets = map[string]commandHandler{
 		"command": (*ConsoleServer).devicePacketCommand,
@@ -71,7 +111,16 @@ func (console *ConsoleServer) Listen() {
 		}
 		b, _ := json.MarshalIndent(packet, "", "	")
 		Debug.Println(string(b))
-		handleErr := console.handler.Handle(packet, os.Stdout)
+		if packet.GetHeader().Type == packets.Packet_Header_REQUEST {
+			response, requestErr := console.handler.SendRequest(packet, DefaultRequestTimeout)
+			if requestErr != nil {
+				Error.Println("console: request failed: " + requestErr.Error())
+				continue
+			}
+			Info.Println("console: received response: " + response.String())
+			continue
+		}
+		handleErr := console.handler.SendProto(packet)
 		if handleErr != nil {
 			Error.Println("console: error handling command: " + handleErr.Error())
 		}
@@ -144,6 +193,291 @@ func (console *ConsoleServer) routerConfig(args []commandArgument) (*packets.Pac
 	return packet, nil
 }
 
+func (console *ConsoleServer) routerRoutes(args []commandArgument) (*packets.Packet, error) {
+	routes := console.routerManager.Table.Routes()
+	if len(routes) == 0 {
+		Info.Println("No routes known.")
+		return nil, nil
+	}
+	for _, route := range routes {
+		Info.Printf("%s via %s (metric %d, updated %s)", route.Destination, route.NextHop, route.Metric, route.Updated.Format(time.Stamp))
+	}
+	return nil, nil
+}
+
+func (console *ConsoleServer) routerList(args []commandArgument) (*packets.Packet, error) {
+	routers := console.routerManager.AllRouters()
+	if len(routers) == 0 {
+		Info.Println("No routers known.")
+		return nil, nil
+	}
+	for _, router := range routers {
+		hostname, port := router.GetAddress()
+		Info.Printf("%s at %s:%d is %s", router.Name, hostname, port, describeLiveness(&router.Liveness))
+	}
+	return nil, nil
+}
+
+func (console *ConsoleServer) handleOutbox(args []commandArgument) (*packets.Packet, error) {
+	statuses := console.handler.outbox.Status()
+	if len(statuses) == 0 {
+		Info.Println("Outbox is empty.")
+		return nil, nil
+	}
+	for _, status := range statuses {
+		Info.Printf("%s: %d queued, %d failed attempts, next attempt %s", status.Destination, status.Depth, status.Attempts, status.NextAttempt.Format(time.Stamp))
+	}
+	return nil, nil
+}
+
+// handleDispatch shows the dispatch queue of every device
+// that has been sent commands
+func (console *ConsoleServer) handleDispatch(args []commandArgument) (*packets.Packet, error) {
+	statuses := console.handler.dispatch.Status()
+	if len(statuses) == 0 {
+		Info.Println("No commands dispatched.")
+		return nil, nil
+	}
+	for _, status := range statuses {
+		Info.Printf("%s: %d safety, %d normal and %d bulk waiting; %d sent, %d coalesced, %d expired, %d dropped, longest wait %s",
+			status.Device, status.Waiting[packets.Command_SAFETY], status.Waiting[packets.Command_NORMAL], status.Waiting[packets.Command_BULK],
+			status.Sent, status.Coalesced, status.Expired, status.Dropped, status.MaxWait)
+	}
+	return nil, nil
+}
+
+func (console *ConsoleServer) handleRule(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || ruleCommands[args[0].argument] == nil {
+		Info.Println("Usage: rule list|add|remove|enable|disable|run")
+		return nil, nil
+	}
+	return ruleCommands[args[0].argument](console, args[1:])
+}
+
+func (console *ConsoleServer) ruleList(args []commandArgument) (*packets.Packet, error) {
+	rules := console.handler.rules.All()
+	if len(rules) == 0 {
+		Info.Println("No rules.")
+		return nil, nil
+	}
+	for _, rule := range rules {
+		status := "enabled"
+		if rule.Disabled {
+			status = "disabled"
+		}
+		Info.Printf("%s (%s): %d triggers, %d conditions, %d actions", rule.Name, status, len(rule.Triggers), len(rule.Conditions), len(rule.Actions))
+	}
+	return nil, nil
+}
+
+// ruleAdd adds the rule given as XML, quoted as a single argument, e.g.
+// rule add '<rule name="porch"><trigger kind="time" at="19:00"/>...</rule>'
+func (console *ConsoleServer) ruleAdd(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: rule add '<rule name=\"...\">...</rule>'")
+		return nil, nil
+	}
+	rule := &Rule{}
+	if unmarshErr := xml.Unmarshal([]byte(args[0].argument), rule); unmarshErr != nil {
+		Error.Println("console: couldn't parse rule: " + unmarshErr.Error())
+		return nil, nil
+	}
+	if addErr := console.handler.rules.Add(rule); addErr != nil {
+		Error.Println("console: couldn't add rule: " + addErr.Error())
+		return nil, nil
+	}
+	Info.Println("Rule " + rule.Name + " added.")
+	return nil, nil
+}
+
+func (console *ConsoleServer) ruleRemove(args []commandArgument) (*packets.Packet, error) {
+	return console.ruleUpdate(args, "remove", "removed", console.handler.rules.Remove)
+}
+
+func (console *ConsoleServer) ruleEnable(args []commandArgument) (*packets.Packet, error) {
+	return console.ruleUpdate(args, "enable", "enabled", func(name string) error {
+		return console.handler.rules.SetEnabled(name, true)
+	})
+}
+
+func (console *ConsoleServer) ruleDisable(args []commandArgument) (*packets.Packet, error) {
+	return console.ruleUpdate(args, "disable", "disabled", func(name string) error {
+		return console.handler.rules.SetEnabled(name, false)
+	})
+}
+
+// ruleRun carries out the rule's actions straight away, ignoring
+// its triggers and conditions
+func (console *ConsoleServer) ruleRun(args []commandArgument) (*packets.Packet, error) {
+	return console.ruleUpdate(args, "run", "run", func(name string) error {
+		for _, rule := range console.handler.rules.All() {
+			if rule.Name == name {
+				console.handler.RunRuleActions(rule)
+				return nil
+			}
+		}
+		return errors.New("rules: no rule " + name)
+	})
+}
+
+// ruleUpdate applies the update to the rule named by the first argument
+func (console *ConsoleServer) ruleUpdate(args []commandArgument, command string, done string, update func(string) error) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: rule " + command + " <name>")
+		return nil, nil
+	}
+	if updateErr := update(args[0].argument); updateErr != nil {
+		Error.Println("console: " + updateErr.Error())
+		return nil, nil
+	}
+	Info.Println("Rule " + args[0].argument + " " + done + ".")
+	return nil, nil
+}
+
+func (console *ConsoleServer) handleSchedule(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || scheduleCommands[args[0].argument] == nil {
+		Info.Println("Usage: schedule list|add|cancel")
+		return nil, nil
+	}
+	return scheduleCommands[args[0].argument](console, args[1:])
+}
+
+func (console *ConsoleServer) scheduleList(args []commandArgument) (*packets.Packet, error) {
+	schedules := console.handler.schedules.All()
+	if len(schedules) == 0 {
+		Info.Println("Nothing scheduled.")
+		return nil, nil
+	}
+	for _, schedule := range schedules {
+		command, commandErr := schedule.GetCommand()
+		if commandErr != nil {
+			Info.Printf("%s: %s, next at %s, unreadable command", schedule.ID, schedule.Describe(), schedule.Next.Format(time.Stamp))
+			continue
+		}
+		Info.Printf("%s: %s, next at %s: %s", schedule.ID, schedule.Describe(), schedule.Next.Format(time.Stamp), command.String())
+	}
+	return nil, nil
+}
+
+// scheduleAdd schedules a command given the same way as to "device packet
+// command", along with at=<2006-01-02T15:04>, cron='<expression>' or
+// sun=sunrise|sunset with an optional offset=<minutes>
+func (console *ConsoleServer) scheduleAdd(args []commandArgument) (*packets.Packet, error) {
+	packet, packetErr := console.devicePacketCommand(args)
+	if packetErr != nil || packet == nil {
+		return nil, packetErr
+	}
+	schedule, scheduleErr := BuildSchedule(packet.GetCommand())
+	if scheduleErr != nil {
+		return nil, scheduleErr
+	}
+	for _, arg := range args {
+		switch arg.argument {
+		case "at":
+			at, atErr := time.ParseInLocation("2006-01-02T15:04", arg.value, time.Local)
+			if atErr != nil {
+				return nil, errors.New("console: at must be given as 2006-01-02T15:04")
+			}
+			schedule.At = &at
+		case "cron":
+			schedule.Cron = arg.value
+		case "sun":
+			schedule.Sun = arg.value
+		case "offset":
+			offset, offsetErr := strconv.Atoi(arg.value)
+			if offsetErr != nil {
+				return nil, errors.New("console: offset must be a number of minutes")
+			}
+			schedule.Offset = offset
+		}
+	}
+	if addErr := console.handler.addSchedule(schedule, packet.GetCommand()); addErr != nil {
+		Error.Println("console: couldn't schedule command: " + addErr.Error())
+		return nil, nil
+	}
+	Info.Printf("Scheduled %s to run %s, next at %s.", schedule.ID, schedule.Describe(), schedule.Next.Format(time.Stamp))
+	return nil, nil
+}
+
+func (console *ConsoleServer) scheduleCancel(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: schedule cancel <id>")
+		return nil, nil
+	}
+	if cancelErr := console.handler.schedules.Cancel(args[0].argument); cancelErr != nil {
+		Error.Println("console: " + cancelErr.Error())
+		return nil, nil
+	}
+	Info.Println("Schedule " + args[0].argument + " cancelled.")
+	return nil, nil
+}
+
+func (console *ConsoleServer) handleScene(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || sceneCommands[args[0].argument] == nil {
+		Info.Println("Usage: scene list|run")
+		return nil, nil
+	}
+	return sceneCommands[args[0].argument](console, args[1:])
+}
+
+func (console *ConsoleServer) sceneList(args []commandArgument) (*packets.Packet, error) {
+	scenes := console.handler.scenes.All()
+	if len(scenes) == 0 {
+		Info.Println("No scenes.")
+		return nil, nil
+	}
+	for _, scene := range scenes {
+		mode := "in order"
+		if scene.Parallel {
+			mode = "in parallel"
+		}
+		Info.Printf("%s: %d steps %s", scene.Name, len(scene.Steps), mode)
+	}
+	return nil, nil
+}
+
+// sceneRun runs the scene on the current definer and shows the
+// outcome for each device
+func (console *ConsoleServer) sceneRun(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: scene run <name>")
+		return nil, nil
+	}
+	response, sceneErr := console.handler.RunScene(args[0].argument)
+	if sceneErr != nil {
+		Error.Println("console: " + sceneErr.Error())
+		return nil, nil
+	}
+	for _, result := range response.Results {
+		if result.Success {
+			Info.Println(result.Device + ": ok")
+			continue
+		}
+		Info.Println(result.Device + ": " + result.Error)
+	}
+	Info.Println("Scene " + response.Scene + " finished.")
+	return nil, nil
+}
+
+// describeLiveness formats the liveness state for display
+func describeLiveness(liveness *Liveness) string {
+	lastSeen := liveness.LastSeen()
+	if lastSeen.IsZero() {
+		return liveness.State().String() + ", never seen"
+	}
+	return liveness.State().String() + ", last seen " + lastSeen.Format(time.Stamp)
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (console *ConsoleServer) handleDevice(args []commandArgument) (*packets.Packet, error) {
 	subCommandIndex := 0
 	for ; subCommandIndex < len(args) && (args[subCommandIndex].flag || !args[subCommandIndex].nilVal); subCommandIndex++ {
@@ -152,6 +486,9 @@ func (console *ConsoleServer) handleDevice(args []commandArgument) (*packets.Pac
 			if args[subCommandIndex].flag {
 				b, _ := xml.MarshalIndent(console.deviceManager, "", "    ")
 				Info.Println(string(b))
+				for _, device := range console.deviceManager.AllDevices() {
+					Info.Println(device.ID + " is " + describeLiveness(&device.Liveness))
+				}
 				return nil, nil
 			}
 		}
@@ -164,6 +501,187 @@ func (console *ConsoleServer) handleDevice(args []commandArgument) (*packets.Pac
 	return deviceCommands[args[subCommandIndex].argument](console, args[subCommandIndex+1:])
 }
 
+func (console *ConsoleServer) devicePending(args []commandArgument) (*packets.Packet, error) {
+	pending := console.deviceManager.PendingDevices()
+	claiming := console.handler.transfers.Claiming()
+	if len(pending) == 0 && len(claiming) == 0 {
+		Info.Println("No devices awaiting approval.")
+		return nil, nil
+	}
+	for _, device := range claiming {
+		Info.Printf("%s being transferred from %s since %s", device.Device.ID, device.PreviousOwner, device.Requested.Format(time.Stamp))
+	}
+	for _, device := range pending {
+		pinNote := ""
+		if device.PIN != "" {
+			pinNote = ", PIN required"
+		}
+		Info.Printf("%s (%s %s) at %s:%s since %s%s", device.Device.ID, device.Device.Type.Core, device.Device.Type.Modifier, device.Device.Address, device.Device.Port, device.Requested.Format(time.Stamp), pinNote)
+	}
+	return nil, nil
+}
+
+func (console *ConsoleServer) deviceApprove(args []commandArgument) (*packets.Packet, error) {
+	id, pin := "", ""
+	for _, arg := range args {
+		switch {
+		case arg.argument == "pin" && !arg.nilVal:
+			pin = arg.value
+		case arg.nilVal && !arg.flag && id == "":
+			id = arg.argument
+		}
+	}
+	if id == "" {
+		Info.Println("Usage: device approve <id> [pin=<pin>]")
+		return nil, nil
+	}
+	if approveErr := console.handler.ApproveDevice(id, pin); approveErr != nil {
+		Error.Println("console: couldn't approve device: " + approveErr.Error())
+		return nil, nil
+	}
+	Info.Println("Device " + id + " approved.")
+	return nil, nil
+}
+
+func (console *ConsoleServer) deviceReject(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: device reject <id>")
+		return nil, nil
+	}
+	if console.deviceManager.Reject(args[0].argument) == nil {
+		Info.Println("No pending device " + args[0].argument + ".")
+		return nil, nil
+	}
+	Info.Println("Device " + args[0].argument + " rejected.")
+	return nil, nil
+}
+
+// deviceGroups lists the rooms and groups along with what they hold
+func (console *ConsoleServer) deviceGroups(args []commandArgument) (*packets.Packet, error) {
+	rooms, groups := console.deviceManager.Rooms(), console.deviceManager.Groups()
+	if len(rooms) == 0 && len(groups) == 0 {
+		Info.Println("No rooms or groups.")
+		return nil, nil
+	}
+	for _, room := range rooms {
+		Info.Printf("room %s: devices [%s], rooms [%s]", room.Name, strings.Join(room.Devices, ", "), strings.Join(room.Rooms, ", "))
+	}
+	for _, group := range groups {
+		Info.Printf("group %s: devices [%s], rooms [%s], groups [%s]", group.Name, strings.Join(group.Devices, ", "), strings.Join(group.Rooms, ", "), strings.Join(group.Groups, ", "))
+	}
+	return nil, nil
+}
+
+// deviceGroup adds devices, rooms and groups to a group or removes
+// them from it, e.g. device group add lights device=lamp room=hall.
+// Removing without naming any removes the group. The change is saved
+// to the config on shutdown.
+func (console *ConsoleServer) deviceGroup(args []commandArgument) (*packets.Packet, error) {
+	return console.deviceArrange(args, false)
+}
+
+// deviceRoom adds devices and rooms to a room or removes them from it
+// the same way as "device group"
+func (console *ConsoleServer) deviceRoom(args []commandArgument) (*packets.Packet, error) {
+	return console.deviceArrange(args, true)
+}
+
+func (console *ConsoleServer) deviceArrange(args []commandArgument, room bool) (*packets.Packet, error) {
+	kind, usage := "Group", "Usage: device group add|remove <name> [device=<id>] [room=<name>] [group=<name>]"
+	if room {
+		kind, usage = "Room", "Usage: device room add|remove <name> [device=<id>] [room=<name>]"
+	}
+	if len(args) < 2 || (args[0].argument != "add" && args[0].argument != "remove") || !args[1].nilVal || args[1].flag {
+		Info.Println(usage)
+		return nil, nil
+	}
+	name, members := args[1].argument, &DeviceGroup{}
+	for _, arg := range args[2:] {
+		switch {
+		case arg.nilVal || arg.flag:
+			continue
+		case arg.argument == "device":
+			members.Devices = append(members.Devices, arg.value)
+		case arg.argument == "room":
+			members.Rooms = append(members.Rooms, arg.value)
+		case arg.argument == "group":
+			members.Groups = append(members.Groups, arg.value)
+		}
+	}
+	if args[0].argument == "add" {
+		if addErr := console.deviceManager.AddToGroup(room, name, members); addErr != nil {
+			Error.Println("console: couldn't add to " + strings.ToLower(kind) + ": " + addErr.Error())
+			return nil, nil
+		}
+		Info.Println(kind + " " + name + " updated.")
+		return nil, nil
+	}
+	if removeErr := console.deviceManager.RemoveFromGroup(room, name, members); removeErr != nil {
+		Error.Println("console: couldn't remove from " + strings.ToLower(kind) + ": " + removeErr.Error())
+		return nil, nil
+	}
+	if len(members.Devices) == 0 && len(members.Rooms) == 0 && len(members.Groups) == 0 {
+		Info.Println(kind + " " + name + " removed.")
+		return nil, nil
+	}
+	Info.Println(kind + " " + name + " updated.")
+	return nil, nil
+}
+
+func (console *ConsoleServer) deviceState(args []commandArgument) (*packets.Packet, error) {
+	if len(args) == 0 || !args[0].nilVal || args[0].flag {
+		Info.Println("Usage: device state <id>")
+		return nil, nil
+	}
+	id := args[0].argument
+	if console.deviceManager.GetDeviceByID(id) == nil {
+		Info.Println("No device " + id + ".")
+		return nil, nil
+	}
+	state := console.deviceManager.GetState(id)
+	if len(state) == 0 {
+		Info.Println("Device " + id + " hasn't reported its state.")
+		return nil, nil
+	}
+	for _, key := range state.Keys() {
+		Info.Printf("%s = %s (reported %s)", key, state[key].Value, state[key].Updated.Format(time.Stamp))
+	}
+	return nil, nil
+}
+
+// deviceWatch prints the state changes of the given devices, or of
+// every device, as they happen until "device unwatch" is run
+func (console *ConsoleServer) deviceWatch(args []commandArgument) (*packets.Packet, error) {
+	ids := map[string]bool{}
+	for _, arg := range args {
+		if arg.nilVal && !arg.flag {
+			ids[arg.argument] = true
+		}
+	}
+	console.deviceUnwatch(nil)
+	console.watch = console.handler.events.Subscribe(TopicDeviceState)
+	go func(events <-chan Event) {
+		for event := range events {
+			if len(ids) > 0 && !ids[event.Subject] {
+				continue
+			}
+			for _, key := range sortedKeys(event.Data) {
+				Info.Printf("%s: %s = %s", event.Subject, key, event.Data[key])
+			}
+		}
+	}(console.watch.Events())
+	Info.Println("Watching device state. Run \"device unwatch\" to stop.")
+	return nil, nil
+}
+
+func (console *ConsoleServer) deviceUnwatch(args []commandArgument) (*packets.Packet, error) {
+	if console.watch != nil {
+		console.handler.events.Unsubscribe(console.watch)
+		console.watch = nil
+	}
+	return nil, nil
+}
+
 func (console *ConsoleServer) devicePacket(args []commandArgument) (*packets.Packet, error) {
 	packetIndex := 0
 	for packetIndex < len(args) && (args[packetIndex].flag || !args[packetIndex].nilVal) {
@@ -176,6 +694,10 @@ func (console *ConsoleServer) devicePacket(args []commandArgument) (*packets.Pac
 	return devicePackets[args[packetIndex].argument](console, args[packetIndex+1:])
 }
 
+// devicePacketCommand builds a command from core=<command> and
+// parameter=<value> arguments, sent to the devices picked out by any of
+// type=<core>[/<modifier>], group=<name>, room=<name> and devices=<id,id>,
+// with an optional priority=safety|normal|bulk and coalesce
 func (console *ConsoleServer) devicePacketCommand(args []commandArgument) (*packets.Packet, error) {
 	header, headerIndex, headerErr := console.buildPacketHeader(args, packets.Packet_Header_REQUEST)
 	if headerErr != nil {
@@ -183,24 +705,58 @@ func (console *ConsoleServer) devicePacketCommand(args []commandArgument) (*pack
 	}
 	device, deviceIndex := console.buildPacketCommandHeader(args[headerIndex:])
 	execute := &packets.Execute{}
+	command := &packets.Command{
+		Device: device,
+		Body: &packets.Command_Execute{
+			Execute: execute,
+		},
+	}
 	for i := headerIndex + deviceIndex; i < len(args) && (args[i].flag || !args[i].nilVal); i++ {
 		value := args[i].value
 		switch args[i].argument {
 		case "core":
 			execute.Core = value
 		case "parameter":
-			execute.Parameters = []string{value}
+			execute.Parameters = append(execute.Parameters, value)
+		case "timeout":
+			timeout, timeoutErr := strconv.ParseUint(value, 10, 32)
+			if timeoutErr != nil {
+				return nil, errors.New("console: timeout must be a number of milliseconds")
+			}
+			command.Timeout = uint32(timeout)
+		case "allornothing":
+			command.AllOrNothing = value == "" || value == "true"
+		case "type":
+			typeParts := strings.SplitN(value, "/", 2)
+			device.Core = typeParts[0]
+			if len(typeParts) > 1 {
+				device.Modifier = typeParts[1]
+			}
+		case "group":
+			device.Group = value
+		case "room":
+			device.Room = value
+		case "devices":
+			device.Devices = strings.Split(value, ",")
+		case "priority":
+			priority, ok := packets.Command_Priority_value[strings.ToUpper(value)]
+			if !ok {
+				return nil, errors.New("console: priority must be safety, normal or bulk")
+			}
+			command.Priority = packets.Command_Priority(priority)
+		case "coalesce":
+			command.Coalesce = value == "" || value == "true"
 		}
 	}
+	validateErr := console.handler.schemas.Validate(commandType(command), command)
+	if validateErr != nil {
+		Error.Println("console: invalid command: " + validateErr.Error())
+		return nil, nil
+	}
 	packet := &packets.Packet{
 		Header: header,
 		Body: &packets.Packet_Command {
-			Command: &packets.Command{
-				Device: device,
-				Body: &packets.Command_Execute{
-					Execute: execute,
-				},
-			},
+			Command: command,
 		},
 	}
 	return packet, nil
@@ -223,10 +779,11 @@ func (console *ConsoleServer) buildPacketCommandHeader(args []commandArgument) (
 
 func (console *ConsoleServer) buildPacketHeader(args []commandArgument, headerType packets.Packet_Header_Type) (*packets.Packet_Header, int, error) {
 	bodyIndex := 0
+	hostname, _ := console.router.GetAddress()
 	header := &packets.Packet_Header{
-		Origin: console.router.Hostname,
-		Destination: console.router.Name,
-		Id: "0",
+		Origin: hostname,
+		Destination: console.router.GetName(),
+		Id: NewPacketID(),
 		Type: headerType,
 	}
 	for ; bodyIndex < len(args) && (len(args[bodyIndex].argument) > 3 && args[bodyIndex].argument[:2] == "b.") && (args[bodyIndex].flag || !args[bodyIndex].nilVal); bodyIndex++ {
@@ -257,11 +814,12 @@ func (console *ConsoleServer) buildIntroductionServer(args map[string]string) (*
 	} else {
 		setup = false
 	}
+	hostname, _ := console.router.GetAddress()
 	return &packets.Packet{
 		Header: &packets.Packet_Header{
-			Origin:      console.router.Hostname,
-			Destination: console.router.Name,
-			Id:          "0",
+			Origin:      hostname,
+			Destination: console.router.GetName(),
+			Id:          NewPacketID(),
 			Type:        packets.Packet_Header_PASSIVE,
 		},
 		Body: &packets.Packet_Intro{
@@ -273,7 +831,7 @@ func (console *ConsoleServer) buildIntroductionServer(args map[string]string) (*
 }
 
 func (consoleOut *consoleOut) Write(p []byte) (int, error) {
-	proto, protoErr := consoleOut.parseProto(p[3:])
+	proto, protoErr := consoleOut.parseProto(p[frameHeaderSize:])
 	if protoErr != nil {
 		return 0, protoErr
 	}
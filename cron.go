@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cronSearchLimit is how far ahead the next run of a cron
+	// expression is looked for before giving up
+	cronSearchLimit = 5 * 366 * 24 * time.Hour
+)
+
+// CronSpec is a parsed cron expression of five fields: minute,
+// hour, day of month, month and day of week. Fields take *, single
+// values, ranges, lists and steps, e.g. "*/15 7-9 * * 1-5". As with
+// cron, when both the day of month and day of week are restricted a
+// day matching either is enough.
+type CronSpec struct {
+	minutes      []bool
+	hours        []bool
+	days         []bool
+	months       []bool
+	weekdays     []bool
+	everyDay     bool
+	everyWeekday bool
+}
+
+// ParseCron parses a five field cron expression
+func ParseCron(expression string) (*CronSpec, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("cron: expected 5 fields in \"" + expression + "\", got " + strconv.Itoa(len(fields)))
+	}
+	spec := &CronSpec{everyDay: fields[2] == "*", everyWeekday: fields[4] == "*"}
+	var parseErr error
+	if spec.minutes, parseErr = parseCronField(fields[0], 0, 59); parseErr != nil {
+		return nil, parseErr
+	}
+	if spec.hours, parseErr = parseCronField(fields[1], 0, 23); parseErr != nil {
+		return nil, parseErr
+	}
+	if spec.days, parseErr = parseCronField(fields[2], 1, 31); parseErr != nil {
+		return nil, parseErr
+	}
+	if spec.months, parseErr = parseCronField(fields[3], 1, 12); parseErr != nil {
+		return nil, parseErr
+	}
+	if spec.weekdays, parseErr = parseCronField(fields[4], 0, 7); parseErr != nil {
+		return nil, parseErr
+	}
+	// Both 0 and 7 are Sunday
+	spec.weekdays[0] = spec.weekdays[0] || spec.weekdays[7]
+	return spec, nil
+}
+
+// parseCronField returns which of the values from min to max the
+// field matches, indexed by value
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			parsed, stepErr := strconv.Atoi(part[slash+1:])
+			if stepErr != nil || parsed < 1 {
+				return nil, errors.New("cron: invalid step in \"" + field + "\"")
+			}
+			step = parsed
+			part = part[:slash]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var lowErr, highErr error
+			low, lowErr = strconv.Atoi(bounds[0])
+			high, highErr = strconv.Atoi(bounds[1])
+			if lowErr != nil || highErr != nil {
+				return nil, errors.New("cron: invalid range in \"" + field + "\"")
+			}
+		default:
+			value, valueErr := strconv.Atoi(part)
+			if valueErr != nil {
+				return nil, errors.New("cron: invalid value in \"" + field + "\"")
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, errors.New("cron: \"" + field + "\" is out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+		}
+		for value := low; value <= high; value += step {
+			matches[value] = true
+		}
+	}
+	return matches, nil
+}
+
+// Next returns the first time after the given one that the
+// expression matches. It fails if there is none within years,
+// such as for the 31st of February.
+func (spec *CronSpec) Next(after time.Time) (time.Time, bool) {
+	limit := after.Add(cronSearchLimit)
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	for next.Before(limit) {
+		year, month, day := next.Date()
+		switch {
+		case !spec.months[month]:
+			next = time.Date(year, month+1, 1, 0, 0, 0, 0, next.Location())
+		case !spec.dayMatches(next):
+			next = time.Date(year, month, day+1, 0, 0, 0, 0, next.Location())
+		case !spec.hours[next.Hour()]:
+			next = time.Date(year, month, day, next.Hour()+1, 0, 0, 0, next.Location())
+		case !spec.minutes[next.Minute()]:
+			next = next.Add(time.Minute)
+		default:
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dayMatches checks the day of month and day of week of the time
+func (spec *CronSpec) dayMatches(t time.Time) bool {
+	day := spec.days[t.Day()]
+	weekday := spec.weekdays[t.Weekday()]
+	switch {
+	case spec.everyDay && spec.everyWeekday:
+		return true
+	case spec.everyDay:
+		return weekday
+	case spec.everyWeekday:
+		return day
+	}
+	return day || weekday
+}
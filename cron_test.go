@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, parseErr := time.Parse("2006-01-02 15:04", value)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		return parsed
+	}
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		next       string
+	}{
+		{"every minute", "* * * * *", "2026-10-17 09:30", "2026-10-17 09:31"},
+		{"steps", "*/15 7-9 * * *", "2026-10-17 09:50", "2026-10-18 07:00"},
+		{"day of week alone", "0 12 * * 5", "2026-10-17 09:30", "2026-10-23 12:00"},
+		{"sunday as 7", "0 12 * * 7", "2026-10-17 09:30", "2026-10-18 12:00"},
+		{"day of month or day of week, weekday first", "0 12 20 * 1", "2026-10-17 09:30", "2026-10-19 12:00"},
+		{"day of month or day of week, day first", "0 12 20 * 1", "2026-10-19 12:00", "2026-10-20 12:00"},
+		{"month rollover", "30 8 1 * *", "2026-10-17 09:30", "2026-11-01 08:30"},
+		{"year rollover", "0 0 1 1 *", "2026-10-17 09:30", "2027-01-01 00:00"},
+		{"31st skips short months", "0 0 31 * *", "2026-10-31 00:00", "2026-12-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-10-17 09:30", "2028-02-29 00:00"},
+		{"31st of February never", "0 0 31 2 *", "2026-10-17 09:30", ""},
+	}
+	for _, test := range tests {
+		spec, parseErr := ParseCron(test.expression)
+		if parseErr != nil {
+			t.Fatalf("%s: %v", test.name, parseErr)
+		}
+		next, ok := spec.Next(at(test.after))
+		if test.next == "" {
+			if ok {
+				t.Errorf("%s: expected no run, got %v", test.name, next)
+			}
+			continue
+		}
+		if !ok || !next.Equal(at(test.next)) {
+			t.Errorf("%s: expected %s, got %v (%v)", test.name, test.next, next, ok)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expression := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, parseErr := ParseCron(expression); parseErr == nil {
+			t.Errorf("expected %q to be rejected", expression)
+		}
+	}
+}
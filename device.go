@@ -3,22 +3,45 @@ package main
 import (
 	"encoding/xml"
 	"errors"
-	"net"
-	"os"
-)
-
-const (
-	stackWifi      = "wifi"
-	stackBluetooth = "blue"
+	"sort"
+	"sync"
+	"time"
 )
 
 // DeviceManager manages the devices the current
-// definer knows about and can connect to
+// definer knows about and can connect to. Devices are keyed by
+// ID and indexed by type, modifier, stack and address, and the
+// state they last reported is kept alongside them, as are the
+// groups and rooms they are arranged in. It is safe for
+// concurrent use.
 type DeviceManager struct {
-	XMLName xml.Name `xml:"devices"`
-	Devices map[*DeviceType]*Device
+	XMLName    xml.Name `xml:"devices"`
+	lock       sync.RWMutex
+	devices    map[string]*Device
+	byCore     deviceIndex
+	byModifier deviceIndex
+	byStack    deviceIndex
+	byAddress  deviceIndex
+	pending    map[string]*PendingDevice
+	states     map[string]DeviceState
+	groups     map[string]*DeviceGroup
+	rooms      map[string]*DeviceGroup
 }
 
+// PendingDevice is a device that has announced itself but
+// hasn't been approved yet. It won't receive commands until
+// it is.
+type PendingDevice struct {
+	Device        *Device
+	PIN           string
+	PreviousOwner string
+	Requested     time.Time
+}
+
+// deviceIndex maps a secondary key to the IDs of the devices
+// sharing it
+type deviceIndex map[string]map[string]bool
+
 // Device represents an IoT device
 type Device struct {
 	XMLName      xml.Name    `xml:"device"`
@@ -29,6 +52,7 @@ type Device struct {
 	Stack        string      `xml:"stack"`
 	Address      string      `xml:"address"`
 	Port         string      `xml:"port"`
+	Liveness     Liveness    `xml:"-"`
 }
 
 // DeviceType represents the device details
@@ -38,86 +62,301 @@ type DeviceType struct {
 	Modifier string   `xml:"modifier"`
 }
 
+// BuildDeviceManager returns a DeviceManager without any devices
+func BuildDeviceManager() *DeviceManager {
+	manager := &DeviceManager{}
+	manager.reset()
+	return manager
+}
+
+// reset empties the manager. The caller must hold the write lock.
+func (manager *DeviceManager) reset() {
+	manager.devices = map[string]*Device{}
+	manager.byCore = deviceIndex{}
+	manager.byModifier = deviceIndex{}
+	manager.byStack = deviceIndex{}
+	manager.byAddress = deviceIndex{}
+	manager.pending = map[string]*PendingDevice{}
+	manager.states = map[string]DeviceState{}
+	manager.groups = map[string]*DeviceGroup{}
+	manager.rooms = map[string]*DeviceGroup{}
+}
+
+// AddDevice adds a new device. It fails if the device has no ID
+// or a device with the same ID already exists.
+func (manager *DeviceManager) AddDevice(device *Device) error {
+	if device.ID == "" {
+		return errors.New("device: device must have an ID")
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if _, ok := manager.devices[device.ID]; ok {
+		return errors.New("device: device " + device.ID + " already exists")
+	}
+	manager.insert(device)
+	return nil
+}
+
+// UpdateDevice replaces the device with the same ID, reindexing
+// it. The replacement starts with its own liveness.
+func (manager *DeviceManager) UpdateDevice(device *Device) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	existing, ok := manager.devices[device.ID]
+	if !ok {
+		return errors.New("device: no device " + device.ID)
+	}
+	manager.remove(existing)
+	manager.insert(device)
+	return nil
+}
+
+// RemoveDevice removes the device with the given ID along with
+// its state, returning it if it existed
+func (manager *DeviceManager) RemoveDevice(id string) *Device {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	device, ok := manager.devices[id]
+	if !ok {
+		return nil
+	}
+	manager.remove(device)
+	delete(manager.states, id)
+	return device
+}
+
+// AddPending files the device as awaiting approval, replacing
+// any earlier request from a device with the same ID. It fails if
+// MaxPendingDevices are already awaiting approval.
+func (manager *DeviceManager) AddPending(pending *PendingDevice) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if _, ok := manager.pending[pending.Device.ID]; !ok && MaxPendingDevices > 0 && len(manager.pending) >= MaxPendingDevices {
+		return errPendingFull
+	}
+	manager.pending[pending.Device.ID] = pending
+	return nil
+}
+
+// GetPending returns the pending device with the given ID
+func (manager *DeviceManager) GetPending(id string) *PendingDevice {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.pending[id]
+}
+
+// PendingDevices returns every device awaiting approval,
+// oldest request first
+func (manager *DeviceManager) PendingDevices() []*PendingDevice {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	pending := make([]*PendingDevice, 0, len(manager.pending))
+	for _, device := range manager.pending {
+		pending = append(pending, device)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Requested.Before(pending[j].Requested)
+	})
+	return pending
+}
+
+// Approve takes the device off the pending list so it can be
+// adopted. If the device supplied a PIN, the same PIN must be given.
+func (manager *DeviceManager) Approve(id string, pin string) (*PendingDevice, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	pending, ok := manager.pending[id]
+	if !ok {
+		return nil, errors.New("device: no pending device " + id)
+	}
+	if pending.PIN != "" && pending.PIN != pin {
+		return nil, errors.New("device: incorrect PIN for device " + id)
+	}
+	delete(manager.pending, id)
+	return pending, nil
+}
+
+// Reject discards the pending device, returning it if it existed
+func (manager *DeviceManager) Reject(id string) *PendingDevice {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	pending, ok := manager.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(manager.pending, id)
+	return pending
+}
+
+// insert adds the device and its index entries. The caller
+// must hold the write lock.
+func (manager *DeviceManager) insert(device *Device) {
+	manager.devices[device.ID] = device
+	core, modifier := device.typeKeys()
+	manager.byCore.add(core, device.ID)
+	manager.byModifier.add(modifier, device.ID)
+	manager.byStack.add(device.Stack, device.ID)
+	manager.byAddress.add(device.Address, device.ID)
+}
+
+// remove deletes the device and its index entries. The caller
+// must hold the write lock.
+func (manager *DeviceManager) remove(device *Device) {
+	delete(manager.devices, device.ID)
+	core, modifier := device.typeKeys()
+	manager.byCore.remove(core, device.ID)
+	manager.byModifier.remove(modifier, device.ID)
+	manager.byStack.remove(device.Stack, device.ID)
+	manager.byAddress.remove(device.Address, device.ID)
+}
+
 // GetDevices return all devices matching the target
 func (manager *DeviceManager) GetDevices(target *DeviceType) []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
 	devices := []*Device{}
-	for deviceType, device := range manager.Devices {
-		if deviceType.Core == target.Core && (target.Modifier == "" || deviceType.Modifier == target.Modifier) {
+	for _, device := range manager.lookup(manager.byCore, target.Core) {
+		if _, modifier := device.typeKeys(); target.Modifier == "" || modifier == target.Modifier {
 			devices = append(devices, device)
 		}
 	}
 	return devices
 }
 
+// GetDevicesByModifier returns all devices with the given modifier
+func (manager *DeviceManager) GetDevicesByModifier(modifier string) []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.lookup(manager.byModifier, modifier)
+}
+
+// GetDevicesByStack returns all devices reached over the given stack
+func (manager *DeviceManager) GetDevicesByStack(stack string) []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.lookup(manager.byStack, stack)
+}
+
+// GetDevicesByAddress returns all devices at the given address
+func (manager *DeviceManager) GetDevicesByAddress(address string) []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.lookup(manager.byAddress, address)
+}
+
 // GetDeviceByID returns the device with the given id
 func (manager *DeviceManager) GetDeviceByID(id string) *Device {
-	for _, device := range manager.Devices {
-		if device.ID == id {
-			return device
-		}
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.devices[id]
+}
+
+// AllDevices returns every device, sorted by ID
+func (manager *DeviceManager) AllDevices() []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	devices := make([]*Device, 0, len(manager.devices))
+	for _, device := range manager.devices {
+		devices = append(devices, device)
 	}
-	return nil
+	sortDevices(devices)
+	return devices
 }
 
-// SendData sends the given byte array to any devices
-// matching the provided type
-func (manager *DeviceManager) SendData(target *DeviceType, data []byte) error {
-	for _, device := range manager.GetDevices(target) {
-		sendErr := device.SendData(data)
-		if sendErr != nil {
-			return sendErr
-		}
+// lookup returns the devices filed under the key in the index,
+// sorted by ID. The caller must hold the lock.
+func (manager *DeviceManager) lookup(index deviceIndex, key string) []*Device {
+	devices := []*Device{}
+	for id := range index[key] {
+		devices = append(devices, manager.devices[id])
 	}
-	return nil
+	sortDevices(devices)
+	return devices
 }
 
-// SendData sends the provided data to the given Device
-func (device *Device) SendData(data []byte) error {
-	switch device.Stack {
-	case stackWifi:
-		return device.sendDataWifi(data)
+func sortDevices(devices []*Device) {
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+}
+
+func (index deviceIndex) add(key string, id string) {
+	ids, ok := index[key]
+	if !ok {
+		ids = map[string]bool{}
+		index[key] = ids
+	}
+	ids[id] = true
+}
+
+func (index deviceIndex) remove(key string, id string) {
+	delete(index[key], id)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// typeKeys returns the core and modifier of the device's type
+func (device *Device) typeKeys() (string, string) {
+	if device.Type == nil {
+		return "", ""
 	}
-	return errors.New("device: unidentified stack " + device.Stack)
+	return device.Type.Core, device.Type.Modifier
 }
 
-func (device *Device) sendDataWifi(data []byte) error {
-	conn, connErr := net.Dial("tcp", device.Address+":"+device.Port)
-	if connErr != nil {
-		return connErr
+// Dial opens a connection to the device over its stack
+func (device *Device) Dial() (StackConn, error) {
+	stack, stackErr := GetStack(device.Stack)
+	if stackErr != nil {
+		return nil, stackErr
+	}
+	return stack.Dial(device)
+}
+
+// SendData sends the provided data to the given Device as
+// a single packet over its stack
+func (device *Device) SendData(data []byte) error {
+	conn, dialErr := device.Dial()
+	if dialErr != nil {
+		return dialErr
 	}
 	defer conn.Close()
-	_, writeErr := conn.Write(data)
-	return writeErr
+	return conn.Send(data)
 }
 
 // UnmarshalXML is overridden for clean initialization
 //of the devices map on the device manager struct.
 func (manager *DeviceManager) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
 	tempManager := struct {
-		XMLName xml.Name  `xml:"devices"`
-		Devices []*Device `xml:"device"`
+		XMLName xml.Name       `xml:"devices"`
+		Devices []*Device      `xml:"device"`
+		Rooms   []*DeviceGroup `xml:"room"`
+		Groups  []*DeviceGroup `xml:"group"`
 	}{}
-	tempDevices := map[*DeviceType]*Device{}
 	if decodeErr := decoder.DecodeElement(&tempManager, &start); decodeErr != nil {
 		return decodeErr
 	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.XMLName = tempManager.XMLName
+	manager.reset()
 	for _, device := range tempManager.Devices {
-		tempDevices[device.Type] = device
+		if _, ok := manager.devices[device.ID]; ok || device.ID == "" {
+			return errors.New("device: missing or duplicate device ID \"" + device.ID + "\"")
+		}
+		manager.insert(device)
 	}
-	*manager = DeviceManager{tempManager.XMLName, tempDevices}
-	return nil
+	return manager.arrange(tempManager.Rooms, tempManager.Groups)
 }
 
 // MarshalXML is overridden to ensure that the Devices map
 // is saved properly
 func (manager *DeviceManager) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
-	tempDeviceList := []*Device{}
-	for _, device := range manager.Devices {
-		tempDeviceList = append(tempDeviceList, device)
-	}
 	tempManager := struct {
-		XMLName xml.Name  `xml:"devices"`
-		Devices []*Device `xml:"device"`
-	}{manager.XMLName, tempDeviceList}
+		XMLName xml.Name       `xml:"devices"`
+		Devices []*Device      `xml:"device"`
+		Rooms   []*DeviceGroup `xml:"room"`
+		Groups  []*DeviceGroup `xml:"group"`
+	}{manager.XMLName, manager.AllDevices(), manager.Rooms(), manager.Groups()}
 	return encoder.EncodeElement(tempManager, start)
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetDevicesSkipsTypelessDevices(t *testing.T) {
+	manager := BuildDeviceManager()
+	manager.AddDevice(&Device{ID: "typeless", Stack: "none"})
+	manager.AddDevice(&Device{ID: "lamp", Type: &DeviceType{Core: "light", Modifier: "dimmable"}, Stack: "none"})
+	if devices := manager.GetDevices(&DeviceType{Modifier: "dimmable"}); len(devices) != 0 {
+		t.Fatalf("a modifier without a core matched %d devices", len(devices))
+	}
+	if devices := manager.GetDevices(&DeviceType{Core: "", Modifier: ""}); len(devices) != 1 || devices[0].ID != "typeless" {
+		t.Fatalf("expected only the typeless device to have no core, got %v", devices)
+	}
+	if devices := manager.GetDevices(&DeviceType{Core: "light", Modifier: "dimmable"}); len(devices) != 1 || devices[0].ID != "lamp" {
+		t.Fatalf("expected the lamp, got %v", devices)
+	}
+}
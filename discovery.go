@@ -0,0 +1,418 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DiscoveryService is the DNS-SD service type every
+	// definer announces itself under
+	DiscoveryService = "_definer._tcp.local."
+	// DefaultDiscoveryInterval is how often the definer announces
+	// itself and browses for others unless configured otherwise
+	DefaultDiscoveryInterval = 60 * time.Second
+	// discoveryTXTName is the TXT key carrying the router's name
+	discoveryTXTName = "name="
+)
+
+var (
+	// DiscoveryInterval is how often the definer announces itself
+	// and browses for other definers. Announcements are valid for
+	// three intervals.
+	DiscoveryInterval = DefaultDiscoveryInterval
+)
+
+// Discovery announces the current definer as a DNS-SD service and
+// keeps the RouterManager in sync with the definers it finds.
+// Routers that were configured by hand are never removed. The
+// addresses of the definer's hostname are announced along with it
+// and given to anyone asking for them, so other definers can reach
+// it without their own mDNS resolver.
+type Discovery struct {
+	transport     MulticastTransport
+	router        *Router
+	routerManager *RouterManager
+	addresses     func() []net.IP
+	lock          sync.Mutex
+	discovered    map[string]time.Time
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// discoveredInstance collects the records describing a
+// single service instance from an mDNS response
+type discoveredInstance struct {
+	name     string
+	hostname string
+	address  string
+	port     int
+	ttl      uint32
+	hasSRV   bool
+}
+
+// BuildDiscovery returns a Discovery for the router that
+// communicates over the given transport
+func BuildDiscovery(transport MulticastTransport, router *Router, routerManager *RouterManager) *Discovery {
+	return &Discovery{
+		transport:     transport,
+		router:        router,
+		routerManager: routerManager,
+		addresses:     interfaceAddresses,
+		discovered:    map[string]time.Time{},
+		done:          make(chan struct{}),
+	}
+}
+
+// Run announces the definer, browses for others and answers their
+// queries until Close is called.
+func (discovery *Discovery) Run() {
+	go discovery.receive()
+	ticker := time.NewTicker(DiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		discovery.expire(time.Now())
+		if announceErr := discovery.announce(discovery.recordTTL()); announceErr != nil {
+			Warning.Println("discovery: couldn't announce: " + announceErr.Error())
+		}
+		if browseErr := discovery.browse(); browseErr != nil {
+			Warning.Println("discovery: couldn't browse: " + browseErr.Error())
+		}
+		select {
+		case <-ticker.C:
+		case <-discovery.done:
+			return
+		}
+	}
+}
+
+// Close tells the other definers the current one is going away
+// and stops the discovery.
+func (discovery *Discovery) Close() error {
+	var closeErr error
+	discovery.closeOnce.Do(func() {
+		if goodbyeErr := discovery.announce(0); goodbyeErr != nil {
+			Warning.Println("discovery: couldn't say goodbye: " + goodbyeErr.Error())
+		}
+		close(discovery.done)
+		closeErr = discovery.transport.Close()
+	})
+	return closeErr
+}
+
+func (discovery *Discovery) receive() {
+	for {
+		message, receiveErr := discovery.transport.Receive()
+		if receiveErr != nil {
+			select {
+			case <-discovery.done:
+			default:
+				Error.Println("discovery: couldn't receive: " + receiveErr.Error())
+			}
+			return
+		}
+		if handleErr := discovery.handleMessage(message); handleErr != nil {
+			Debug.Println("discovery: ignoring message: " + handleErr.Error())
+		}
+	}
+}
+
+// handleMessage answers queries for the definer service and for
+// the addresses of its hostname, and records the definers announced
+// in responses.
+func (discovery *Discovery) handleMessage(message []byte) error {
+	var parser dnsmessage.Parser
+	header, headerErr := parser.Start(message)
+	if headerErr != nil {
+		return headerErr
+	}
+	if !header.Response {
+		questions, questionsErr := parser.AllQuestions()
+		if questionsErr != nil {
+			return questionsErr
+		}
+		hostname, _ := discovery.router.GetAddress()
+		for _, question := range questions {
+			if strings.EqualFold(question.Name.String(), DiscoveryService) && (question.Type == dnsmessage.TypePTR || question.Type == dnsmessage.TypeALL) {
+				return discovery.announce(discovery.recordTTL())
+			}
+			if strings.EqualFold(strings.TrimSuffix(question.Name.String(), "."), strings.TrimSuffix(hostname, ".")) && (question.Type == dnsmessage.TypeA || question.Type == dnsmessage.TypeAAAA || question.Type == dnsmessage.TypeALL) {
+				return discovery.answerAddresses(discovery.recordTTL())
+			}
+		}
+		return nil
+	}
+	if skipErr := parser.SkipAllQuestions(); skipErr != nil {
+		return skipErr
+	}
+	answers, answersErr := parser.AllAnswers()
+	if answersErr != nil {
+		return answersErr
+	}
+	if skipErr := parser.SkipAllAuthorities(); skipErr != nil {
+		return skipErr
+	}
+	additionals, additionalsErr := parser.AllAdditionals()
+	if additionalsErr != nil {
+		return additionalsErr
+	}
+	records := append(answers, additionals...)
+	instances := map[string]*discoveredInstance{}
+	addresses := map[string]string{}
+	for _, record := range records {
+		hostname := strings.ToLower(record.Header.Name.String())
+		switch body := record.Body.(type) {
+		case *dnsmessage.AResource:
+			addresses[hostname] = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			if _, ok := addresses[hostname]; !ok {
+				addresses[hostname] = net.IP(body.AAAA[:]).String()
+			}
+		}
+		if ptr, ok := record.Body.(*dnsmessage.PTRResource); ok && strings.EqualFold(record.Header.Name.String(), DiscoveryService) {
+			instance := ptr.PTR.String()
+			instances[strings.ToLower(instance)] = &discoveredInstance{
+				name: strings.TrimSuffix(instance, "."+DiscoveryService),
+				ttl:  record.Header.TTL,
+			}
+		}
+	}
+	for _, record := range records {
+		instance, ok := instances[strings.ToLower(record.Header.Name.String())]
+		if !ok {
+			continue
+		}
+		switch body := record.Body.(type) {
+		case *dnsmessage.SRVResource:
+			instance.hostname = strings.TrimSuffix(body.Target.String(), ".")
+			instance.address = addresses[strings.ToLower(body.Target.String())]
+			instance.port = int(body.Port)
+			instance.hasSRV = true
+		case *dnsmessage.TXTResource:
+			for _, entry := range body.TXT {
+				if strings.HasPrefix(entry, discoveryTXTName) {
+					instance.name = strings.TrimPrefix(entry, discoveryTXTName)
+				}
+			}
+		}
+	}
+	for _, instance := range instances {
+		if instance.ttl == 0 {
+			discovery.lost(instance.name)
+		} else if instance.hasSRV {
+			discovery.found(instance)
+		}
+	}
+	return nil
+}
+
+// found adds or refreshes a definer announced on the network. It is
+// reached at the address announced for its hostname if there was one.
+func (discovery *Discovery) found(instance *discoveredInstance) {
+	if instance.name == "" || instance.name == discovery.router.GetName() {
+		return
+	}
+	address := instance.hostname
+	if instance.address != "" {
+		address = instance.address
+	}
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+	_, wasDiscovered := discovery.discovered[instance.name]
+	existing := discovery.routerManager.GetRouter(instance.name)
+	if existing != nil && !wasDiscovered {
+		return
+	}
+	discovery.discovered[instance.name] = time.Now().Add(time.Duration(instance.ttl) * time.Second)
+	if existing != nil {
+		existing.SetAddress(address, instance.port)
+		return
+	}
+	Info.Println("discovery: found router " + instance.name + " at " + instance.hostname + " (" + address + ")")
+	discovery.routerManager.AddRouter(&Router{
+		Name:       instance.name,
+		Hostname:   address,
+		Port:       instance.port,
+		Setup:      true,
+		Discovered: true,
+	})
+}
+
+// lost removes a definer that said goodbye
+func (discovery *Discovery) lost(name string) {
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+	discovery.forget(name)
+}
+
+// expire removes the definers whose announcements have run out
+func (discovery *Discovery) expire(now time.Time) {
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+	for name, expires := range discovery.discovered {
+		if now.After(expires) {
+			discovery.forget(name)
+		}
+	}
+}
+
+// forget removes a discovered router. The caller must hold the lock.
+func (discovery *Discovery) forget(name string) {
+	if _, ok := discovery.discovered[name]; !ok {
+		return
+	}
+	Info.Println("discovery: lost router " + name)
+	delete(discovery.discovered, name)
+	discovery.routerManager.RemoveRouter(name)
+}
+
+// announce sends the records describing the current definer. A
+// TTL of zero tells the other definers it is going away.
+func (discovery *Discovery) announce(ttl uint32) error {
+	name := discovery.router.GetName()
+	routerHostname, routerPort := discovery.router.GetAddress()
+	if name == "" {
+		return nil
+	}
+	service, serviceErr := dnsmessage.NewName(DiscoveryService)
+	if serviceErr != nil {
+		return serviceErr
+	}
+	instance, instanceErr := dnsmessage.NewName(strings.Replace(name, ".", "-", -1) + "." + DiscoveryService)
+	if instanceErr != nil {
+		return instanceErr
+	}
+	hostname, hostnameErr := dnsmessage.NewName(strings.TrimSuffix(routerHostname, ".") + ".")
+	if hostnameErr != nil {
+		return hostnameErr
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	builder.EnableCompression()
+	if startErr := builder.StartAnswers(); startErr != nil {
+		return startErr
+	}
+	ptrErr := builder.PTRResource(
+		dnsmessage.ResourceHeader{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		dnsmessage.PTRResource{PTR: instance},
+	)
+	if ptrErr != nil {
+		return ptrErr
+	}
+	srvErr := builder.SRVResource(
+		dnsmessage.ResourceHeader{Name: instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+		dnsmessage.SRVResource{Target: hostname, Port: uint16(routerPort)},
+	)
+	if srvErr != nil {
+		return srvErr
+	}
+	txtErr := builder.TXTResource(
+		dnsmessage.ResourceHeader{Name: instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: ttl},
+		dnsmessage.TXTResource{TXT: []string{discoveryTXTName + name}},
+	)
+	if txtErr != nil {
+		return txtErr
+	}
+	if startErr := builder.StartAdditionals(); startErr != nil {
+		return startErr
+	}
+	if addressErr := discovery.addressRecords(&builder, hostname, ttl); addressErr != nil {
+		return addressErr
+	}
+	message, finishErr := builder.Finish()
+	if finishErr != nil {
+		return finishErr
+	}
+	return discovery.transport.Send(message)
+}
+
+// answerAddresses sends the addresses of the current definer's
+// hostname in answer to a query for them
+func (discovery *Discovery) answerAddresses(ttl uint32) error {
+	routerHostname, _ := discovery.router.GetAddress()
+	hostname, hostnameErr := dnsmessage.NewName(strings.TrimSuffix(routerHostname, ".") + ".")
+	if hostnameErr != nil {
+		return hostnameErr
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	builder.EnableCompression()
+	if startErr := builder.StartAnswers(); startErr != nil {
+		return startErr
+	}
+	if addressErr := discovery.addressRecords(&builder, hostname, ttl); addressErr != nil {
+		return addressErr
+	}
+	message, finishErr := builder.Finish()
+	if finishErr != nil {
+		return finishErr
+	}
+	return discovery.transport.Send(message)
+}
+
+// addressRecords adds an A or AAAA record for each of the current
+// definer's addresses to the section the builder is in
+func (discovery *Discovery) addressRecords(builder *dnsmessage.Builder, hostname dnsmessage.Name, ttl uint32) error {
+	for _, ip := range discovery.addresses() {
+		if ip4 := ip.To4(); ip4 != nil {
+			record := dnsmessage.AResource{}
+			copy(record.A[:], ip4)
+			if recordErr := builder.AResource(dnsmessage.ResourceHeader{Name: hostname, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl}, record); recordErr != nil {
+				return recordErr
+			}
+			continue
+		}
+		record := dnsmessage.AAAAResource{}
+		copy(record.AAAA[:], ip.To16())
+		if recordErr := builder.AAAAResource(dnsmessage.ResourceHeader{Name: hostname, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: ttl}, record); recordErr != nil {
+			return recordErr
+		}
+	}
+	return nil
+}
+
+// browse asks every definer on the network to announce itself
+func (discovery *Discovery) browse() error {
+	service, serviceErr := dnsmessage.NewName(DiscoveryService)
+	if serviceErr != nil {
+		return serviceErr
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if startErr := builder.StartQuestions(); startErr != nil {
+		return startErr
+	}
+	questionErr := builder.Question(dnsmessage.Question{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	if questionErr != nil {
+		return questionErr
+	}
+	message, finishErr := builder.Finish()
+	if finishErr != nil {
+		return finishErr
+	}
+	return discovery.transport.Send(message)
+}
+
+func (discovery *Discovery) recordTTL() uint32 {
+	return uint32(3 * DiscoveryInterval / time.Second)
+}
+
+// interfaceAddresses returns the addresses the definer can be reached
+// at on the local network, leaving out loopback and link-local ones
+func interfaceAddresses() []net.IP {
+	interfaceAddrs, addrsErr := net.InterfaceAddrs()
+	if addrsErr != nil {
+		Warning.Println("discovery: couldn't list addresses: " + addrsErr.Error())
+		return nil
+	}
+	addresses := []net.IP{}
+	for _, addr := range interfaceAddrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addresses = append(addresses, ipNet.IP)
+	}
+	return addresses
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/xml"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// waitFor polls the condition until it holds or a second passes
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscoveryOverLoopback(t *testing.T) {
+	group := BuildLoopbackMulticast()
+	routersA, routersB := BuildRouterManager(), BuildRouterManager()
+	routersA.AddRouter(&Router{Name: "C", Hostname: "c.local", Port: 3})
+	discoveryA := BuildDiscovery(group.Join(), &Router{Name: "A", Hostname: "a.local", Port: 1}, routersA)
+	discoveryB := BuildDiscovery(group.Join(), &Router{Name: "B", Hostname: "b.local", Port: 2}, routersB)
+	discoveryB.addresses = func() []net.IP { return []net.IP{net.ParseIP("2001:db8::2"), net.ParseIP("192.0.2.20")} }
+	defer discoveryA.Close()
+	go discoveryA.Run()
+	go discoveryB.Run()
+
+	waitFor(t, "A to find B", func() bool { return routersA.GetRouter("B") != nil })
+	waitFor(t, "B to find A", func() bool { return routersB.GetRouter("A") != nil })
+	found := routersA.GetRouter("B")
+	if hostname, port := found.GetAddress(); hostname != "192.0.2.20" || port != 2 || !found.Discovered {
+		t.Fatalf("unexpected router for B: %s:%d, discovered %v", hostname, port, found.Discovered)
+	}
+
+	config, marshalErr := xml.Marshal(routersA)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	if strings.Contains(string(config), `name="B"`) || !strings.Contains(string(config), `name="C"`) {
+		t.Fatal("discovered routers should be left out of the config: " + string(config))
+	}
+
+	discoveryB.Close()
+	waitFor(t, "A to forget B", func() bool { return routersA.GetRouter("B") == nil })
+	discoveryA.expire(time.Now().Add(time.Hour))
+	if routersA.GetRouter("C") == nil {
+		t.Fatal("configured router C was removed")
+	}
+}
+
+func TestDiscoveryAnswersHostnameQueries(t *testing.T) {
+	group := BuildLoopbackMulticast()
+	discovery := BuildDiscovery(group.Join(), &Router{Name: "A", Hostname: "a.local", Port: 1}, BuildRouterManager())
+	discovery.addresses = func() []net.IP { return []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")} }
+	defer discovery.Close()
+	go discovery.receive()
+	asker := group.Join()
+	defer asker.Close()
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("A.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	query, _ := builder.Finish()
+	asker.Send(query)
+	for {
+		message, receiveErr := asker.Receive()
+		if receiveErr != nil {
+			t.Fatal(receiveErr)
+		}
+		var parser dnsmessage.Parser
+		if header, _ := parser.Start(message); !header.Response {
+			continue
+		}
+		parser.SkipAllQuestions()
+		answers, answersErr := parser.AllAnswers()
+		if answersErr != nil {
+			t.Fatal(answersErr)
+		}
+		addresses := []string{}
+		for _, answer := range answers {
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				addresses = append(addresses, net.IP(body.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				addresses = append(addresses, net.IP(body.AAAA[:]).String())
+			}
+		}
+		if strings.Join(addresses, ",") != "192.0.2.1,2001:db8::1" {
+			t.Fatalf("unexpected addresses for a.local: %v", addresses)
+		}
+		return
+	}
+}
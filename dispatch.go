@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultDispatchRate is the number of commands sent to each
+	// device per second unless configured otherwise
+	DefaultDispatchRate = 5
+	// DefaultDispatchBurst is the number of commands a device may
+	// be sent at once after being left alone for a while
+	DefaultDispatchBurst = 3
+	// DefaultDispatchDepth is the number of commands waiting for
+	// each device unless configured otherwise
+	DefaultDispatchDepth = 64
+)
+
+var (
+	// DispatchRate is the number of commands sent to each device
+	// per second. Safety commands aren't held back by it, and none
+	// are when it is zero or less.
+	DispatchRate = DefaultDispatchRate
+	// DispatchBurst is the number of commands a device may be sent
+	// back to back before the rate applies
+	DispatchBurst = DefaultDispatchBurst
+	// DispatchDepth is the maximum number of commands waiting for a
+	// single device. When full, the newest command of the lowest
+	// priority is dropped to make room for one that outranks it.
+	DispatchDepth = DefaultDispatchDepth
+
+	errDispatchFull  = errors.New("dispatch: too many commands waiting for the device")
+	errSuperseded    = errors.New("dispatch: not sent; superseded by a later command")
+	dispatchPriority = map[packets.Command_Priority]int{
+		packets.Command_BULK:   0,
+		packets.Command_NORMAL: 1,
+		packets.Command_SAFETY: 2,
+	}
+)
+
+// DispatchQueue holds the commands waiting to be sent to each
+// device, sending them one at a time in order of priority and
+// within the device's rate limit. It is safe for concurrent use.
+type DispatchQueue struct {
+	lock    sync.Mutex
+	clock   Clock
+	devices map[string]*deviceQueue
+}
+
+// DispatchStatus summarizes the queue for a single device
+type DispatchStatus struct {
+	Device    string
+	Waiting   map[packets.Command_Priority]int
+	Sent      int
+	Coalesced int
+	Expired   int
+	Dropped   int
+	MaxWait   time.Duration
+}
+
+type deviceQueue struct {
+	jobs    []*dispatchJob
+	running bool
+	forget  bool
+	wake    chan struct{}
+	tokens  float64
+	refill  time.Time
+	status  DispatchStatus
+}
+
+// dispatchJob is a command waiting for its turn. The action is
+// whatever sends the command to the device.
+type dispatchJob struct {
+	priority packets.Command_Priority
+	key      string
+	queued   time.Time
+	cancel   <-chan struct{}
+	action   func() (StackConn, error)
+	done     chan dispatchOutcome
+}
+
+type dispatchOutcome struct {
+	conn StackConn
+	err  error
+}
+
+// BuildDispatchQueue returns a DispatchQueue without any commands
+// waiting, timed by the clock
+func BuildDispatchQueue(clock Clock) *DispatchQueue {
+	return &DispatchQueue{clock: clock, devices: map[string]*deviceQueue{}}
+}
+
+// Do waits for the device's turn to be sent the command and carries
+// out the action, returning what it returns. Commands given a key
+// replace any command with the same key still waiting for the
+// device at the same or a lower priority, which then fails. Commands
+// still waiting when the cancel channel is closed are taken off the
+// queue and fail with errCommandTimeout. Once the action has started
+// it is waited for.
+func (dispatch *DispatchQueue) Do(device string, command *packets.Command, cancel <-chan struct{}, action func() (StackConn, error)) (StackConn, error) {
+	job := &dispatchJob{
+		priority: command.Priority,
+		queued:   dispatch.clock.Now(),
+		cancel:   cancel,
+		action:   action,
+		done:     make(chan dispatchOutcome, 1),
+	}
+	if command.Coalesce && command.GetExecute() != nil {
+		job.key = command.GetExecute().Core
+	}
+	dispatch.lock.Lock()
+	queue, ok := dispatch.devices[device]
+	if !ok {
+		queue = &deviceQueue{
+			wake:   make(chan struct{}, 1),
+			tokens: float64(DispatchBurst),
+			refill: job.queued,
+			status: DispatchStatus{Device: device},
+		}
+		dispatch.devices[device] = queue
+	}
+	if addErr := queue.add(job); addErr != nil {
+		dispatch.lock.Unlock()
+		return nil, addErr
+	}
+	if !queue.running {
+		queue.running = true
+		go dispatch.run(queue)
+	}
+	dispatch.lock.Unlock()
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case outcome := <-job.done:
+		return outcome.conn, outcome.err
+	case <-cancel:
+	}
+	if dispatch.unqueue(queue, job) {
+		return nil, errCommandTimeout
+	}
+	outcome := <-job.done
+	return outcome.conn, outcome.err
+}
+
+// unqueue takes the job off the queue, reporting whether it was still
+// waiting there
+func (dispatch *DispatchQueue) unqueue(queue *deviceQueue, job *dispatchJob) bool {
+	dispatch.lock.Lock()
+	defer dispatch.lock.Unlock()
+	for i, queued := range queue.jobs {
+		if queued == job {
+			queue.jobs = append(queue.jobs[:i], queue.jobs[i+1:]...)
+			queue.status.Expired++
+			return true
+		}
+	}
+	return false
+}
+
+// Forget drops the device's queue along with its status, such as
+// when the device is removed. A queue still sending commands is
+// dropped once it runs out of them.
+func (dispatch *DispatchQueue) Forget(device string) {
+	dispatch.lock.Lock()
+	defer dispatch.lock.Unlock()
+	queue, ok := dispatch.devices[device]
+	if !ok {
+		return
+	}
+	if queue.running {
+		queue.forget = true
+		return
+	}
+	delete(dispatch.devices, device)
+}
+
+// Status returns the queue of every device that has been sent
+// commands, sorted by device ID
+func (dispatch *DispatchQueue) Status() []DispatchStatus {
+	dispatch.lock.Lock()
+	defer dispatch.lock.Unlock()
+	statuses := make([]DispatchStatus, 0, len(dispatch.devices))
+	for _, queue := range dispatch.devices {
+		status := queue.status
+		status.Waiting = map[packets.Command_Priority]int{}
+		for _, job := range queue.jobs {
+			status.Waiting[job.priority]++
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Device < statuses[j].Device
+	})
+	return statuses
+}
+
+// run sends the device its waiting commands until there are none
+// left, waiting on the rate limit between them
+func (dispatch *DispatchQueue) run(queue *deviceQueue) {
+	for {
+		dispatch.lock.Lock()
+		if len(queue.jobs) == 0 {
+			queue.running = false
+			if queue.forget {
+				delete(dispatch.devices, queue.status.Device)
+			}
+			dispatch.lock.Unlock()
+			return
+		}
+		now := dispatch.clock.Now()
+		job := queue.jobs[0]
+		if cancelled(job.cancel) {
+			queue.jobs = queue.jobs[1:]
+			queue.status.Expired++
+			dispatch.lock.Unlock()
+			job.done <- dispatchOutcome{err: errCommandTimeout}
+			continue
+		}
+		if wait := queue.take(now, job.priority == packets.Command_SAFETY); wait > 0 {
+			dispatch.lock.Unlock()
+			select {
+			case <-dispatch.clock.After(wait):
+			case <-queue.wake:
+			}
+			continue
+		}
+		queue.jobs = queue.jobs[1:]
+		queue.status.Sent++
+		if waited := now.Sub(job.queued); waited > queue.status.MaxWait {
+			queue.status.MaxWait = waited
+		}
+		dispatch.lock.Unlock()
+		conn, err := job.action()
+		job.done <- dispatchOutcome{conn: conn, err: err}
+	}
+}
+
+// add queues the job behind those of the same or a higher priority,
+// replacing the one it supersedes. A job never supersedes one of a
+// higher priority, which is sent first instead. The caller must hold
+// the lock.
+func (queue *deviceQueue) add(job *dispatchJob) error {
+	for i, queued := range queue.jobs {
+		if job.key != "" && queued.key == job.key && dispatchPriority[job.priority] >= dispatchPriority[queued.priority] {
+			queue.jobs = append(queue.jobs[:i], queue.jobs[i+1:]...)
+			queue.status.Coalesced++
+			queued.done <- dispatchOutcome{err: errSuperseded}
+			break
+		}
+	}
+	if DispatchDepth > 0 && len(queue.jobs) >= DispatchDepth {
+		last := queue.jobs[len(queue.jobs)-1]
+		if dispatchPriority[job.priority] <= dispatchPriority[last.priority] {
+			queue.status.Dropped++
+			return errDispatchFull
+		}
+		queue.jobs = queue.jobs[:len(queue.jobs)-1]
+		queue.status.Dropped++
+		last.done <- dispatchOutcome{err: errDispatchFull}
+	}
+	index := sort.Search(len(queue.jobs), func(i int) bool {
+		return dispatchPriority[queue.jobs[i].priority] < dispatchPriority[job.priority]
+	})
+	queue.jobs = append(queue.jobs, nil)
+	copy(queue.jobs[index+1:], queue.jobs[index:])
+	queue.jobs[index] = job
+	return nil
+}
+
+// take uses up one of the device's tokens, returning how long to
+// wait if there are none left. Tokens come back at the dispatch
+// rate up to the burst. Urgent commands are sent regardless and
+// may leave the device owing tokens. The caller must hold the lock.
+func (queue *deviceQueue) take(now time.Time, urgent bool) time.Duration {
+	if DispatchRate <= 0 {
+		return 0
+	}
+	burst := float64(DispatchBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	queue.tokens += now.Sub(queue.refill).Seconds() * float64(DispatchRate)
+	if queue.tokens > burst {
+		queue.tokens = burst
+	}
+	queue.refill = now
+	if queue.tokens < 1 && !urgent {
+		return time.Duration((1 - queue.tokens) / float64(DispatchRate) * float64(time.Second))
+	}
+	queue.tokens--
+	return 0
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// dispatchTest queues commands for a single device and records the
+// order they are sent in
+type dispatchTest struct {
+	dispatch *DispatchQueue
+	sent     chan string
+}
+
+func newDispatchTest(clock Clock) *dispatchTest {
+	return &dispatchTest{dispatch: BuildDispatchQueue(clock), sent: make(chan string, 8)}
+}
+
+// dispatchSettings sets the dispatch tunables, returning a func that
+// puts them back
+func dispatchSettings(rate int, burst int, depth int) func() {
+	saved := []int{DispatchRate, DispatchBurst, DispatchDepth}
+	DispatchRate, DispatchBurst, DispatchDepth = rate, burst, depth
+	return func() {
+		DispatchRate, DispatchBurst, DispatchDepth = saved[0], saved[1], saved[2]
+	}
+}
+
+// queue sends the name in the command's turn, returning where the
+// result arrives
+func (test *dispatchTest) queue(name string, command *packets.Command) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := test.dispatch.Do("d1", command, nil, func() (StackConn, error) {
+			test.sent <- name
+			return nil, nil
+		})
+		result <- err
+	}()
+	return result
+}
+
+// hold keeps the device busy until the returned func is called
+func (test *dispatchTest) hold() func() {
+	started, release := make(chan struct{}), make(chan struct{})
+	go test.dispatch.Do("d1", &packets.Command{}, nil, func() (StackConn, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func (test *dispatchTest) waiting() int {
+	waiting := 0
+	for _, status := range test.dispatch.Status() {
+		for _, count := range status.Waiting {
+			waiting += count
+		}
+	}
+	return waiting
+}
+
+func (test *dispatchTest) expectSent(t *testing.T, names ...string) {
+	for _, name := range names {
+		if sent := <-test.sent; sent != name {
+			t.Fatalf("expected %s to be sent, got %s", name, sent)
+		}
+	}
+	select {
+	case sent := <-test.sent:
+		t.Fatalf("%s was sent too", sent)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDispatchSendsByPriority(t *testing.T) {
+	defer dispatchSettings(0, 1, 8)()
+	test := newDispatchTest(SystemClock{})
+	release := test.hold()
+	commands := []struct {
+		name     string
+		priority packets.Command_Priority
+	}{
+		{"bulk", packets.Command_BULK},
+		{"normal", packets.Command_NORMAL},
+		{"safety", packets.Command_SAFETY},
+		{"normal again", packets.Command_NORMAL},
+	}
+	for i, command := range commands {
+		test.queue(command.name, &packets.Command{Priority: command.priority})
+		waitFor(t, command.name+" to be queued", func() bool { return test.waiting() == i+1 })
+	}
+	test.dispatch.Forget("d1")
+	if status := test.dispatch.Status(); len(status) != 1 {
+		t.Fatalf("a busy device's queue was forgotten: %v", status)
+	}
+	release()
+	test.expectSent(t, "safety", "normal", "normal again", "bulk")
+	waitFor(t, "the idle queue to be forgotten", func() bool { return len(test.dispatch.Status()) == 0 })
+}
+
+func TestDispatchCoalescesCommands(t *testing.T) {
+	defer dispatchSettings(0, 1, 8)()
+	test := newDispatchTest(SystemClock{})
+	level := &packets.Command{Coalesce: true, Body: &packets.Command_Execute{Execute: &packets.Execute{Core: "level"}}}
+	release := test.hold()
+	first := test.queue("first", level)
+	waitFor(t, "the first command to be queued", func() bool { return test.waiting() == 1 })
+	second := test.queue("second", level)
+	if err := <-first; err != errSuperseded {
+		t.Fatalf("expected %v, got %v", errSuperseded, err)
+	}
+	test.queue("other", &packets.Command{Body: &packets.Command_Execute{Execute: &packets.Execute{Core: "level"}}})
+	waitFor(t, "the other command to be queued", func() bool { return test.waiting() == 2 })
+	release()
+	test.expectSent(t, "second", "other")
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if status := test.dispatch.Status(); status[0].Coalesced != 1 || status[0].Sent != 3 {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+}
+
+func TestDispatchCoalescingKeepsPriority(t *testing.T) {
+	defer dispatchSettings(0, 1, 8)()
+	test := newDispatchTest(SystemClock{})
+	level := func(priority packets.Command_Priority) *packets.Command {
+		return &packets.Command{Priority: priority, Coalesce: true, Body: &packets.Command_Execute{Execute: &packets.Execute{Core: "level"}}}
+	}
+	release := test.hold()
+	safety := test.queue("safety", level(packets.Command_SAFETY))
+	waitFor(t, "the safety command to be queued", func() bool { return test.waiting() == 1 })
+	bulk := test.queue("bulk", level(packets.Command_BULK))
+	waitFor(t, "the bulk command to be queued", func() bool { return test.waiting() == 2 })
+	test.queue("normal", level(packets.Command_NORMAL))
+	if err := <-bulk; err != errSuperseded {
+		t.Fatalf("expected the bulk command to be superseded, got %v", err)
+	}
+	release()
+	test.expectSent(t, "safety", "normal")
+	if err := <-safety; err != nil {
+		t.Fatalf("a lower priority command superseded the safety command: %v", err)
+	}
+}
+
+func TestDispatchEvictsWhenFull(t *testing.T) {
+	defer dispatchSettings(0, 1, 2)()
+	test := newDispatchTest(SystemClock{})
+	release := test.hold()
+	test.queue("first bulk", &packets.Command{Priority: packets.Command_BULK})
+	waitFor(t, "the first command to be queued", func() bool { return test.waiting() == 1 })
+	second := test.queue("second bulk", &packets.Command{Priority: packets.Command_BULK})
+	waitFor(t, "the second command to be queued", func() bool { return test.waiting() == 2 })
+	if err := <-test.queue("third bulk", &packets.Command{Priority: packets.Command_BULK}); err != errDispatchFull {
+		t.Fatalf("a command not outranking the queue should be refused, got %v", err)
+	}
+	test.queue("normal", &packets.Command{Priority: packets.Command_NORMAL})
+	if err := <-second; err != errDispatchFull {
+		t.Fatalf("the newest bulk command should make room, got %v", err)
+	}
+	release()
+	test.expectSent(t, "normal", "first bulk")
+	if status := test.dispatch.Status(); status[0].Dropped != 2 {
+		t.Fatalf("expected 2 dropped commands, got %+v", status[0])
+	}
+}
+
+func TestDispatchRefillsTokens(t *testing.T) {
+	defer dispatchSettings(2, 2, 8)()
+	clock := newFakeClock(time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC))
+	test := newDispatchTest(clock)
+	names := map[string]bool{"a": true, "b": true, "c": true}
+	for name := range names {
+		test.queue(name, &packets.Command{})
+	}
+	delete(names, <-test.sent)
+	delete(names, <-test.sent)
+	waitFor(t, "the rate limit", func() bool { return clock.Waiting() > 0 })
+
+	// Safety commands go straight out, leaving the device owing a token
+	test.queue("safety", &packets.Command{Priority: packets.Command_SAFETY})
+	test.expectSent(t, "safety")
+	clock.Advance(500 * time.Millisecond)
+	test.expectSent(t)
+	clock.Advance(500 * time.Millisecond)
+	for name := range names {
+		test.expectSent(t, name)
+	}
+	if status := test.dispatch.Status(); status[0].Sent != 4 || status[0].MaxWait != time.Second {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+}
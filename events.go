@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// TopicRouterLiveness is published when a router goes
+	// online or offline
+	TopicRouterLiveness = "router.liveness"
+	// TopicDeviceLiveness is published when a device goes
+	// online or offline
+	TopicDeviceLiveness = "device.liveness"
+	// TopicRouterConfig is published when the current definer's
+	// router is reconfigured
+	TopicRouterConfig = "router.config"
+	// TopicDeviceTransfer is published when a device moves to
+	// another definer
+	TopicDeviceTransfer = "device.transfer"
+	// TopicPacket is published when a packet addressed to the
+	// current definer has been handled, with the kind of packet
+	// as its subject. Route advertisements and pings aren't.
+	TopicPacket = "packet.received"
+	// eventBuffer is the number of events a subscriber may
+	// fall behind by before events are dropped
+	eventBuffer = 64
+)
+
+// Event describes something that happened on the definer that
+// other parts of it may want to react to
+type Event struct {
+	Topic   string
+	Subject string
+	Data    map[string]string
+	Time    time.Time
+}
+
+// EventBus fans events out to everyone subscribed to their
+// topic. It is safe for concurrent use.
+type EventBus struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]bool
+}
+
+// Subscription receives the events published on its topics
+type Subscription struct {
+	topics map[string]bool
+	events chan Event
+}
+
+// BuildEventBus returns an EventBus without any subscribers
+func BuildEventBus() *EventBus {
+	return &EventBus{subscriptions: map[*Subscription]bool{}}
+}
+
+// Subscribe registers interest in the given topics. Passing
+// no topics subscribes to every event.
+func (bus *EventBus) Subscribe(topics ...string) *Subscription {
+	subscription := &Subscription{
+		topics: map[string]bool{},
+		events: make(chan Event, eventBuffer),
+	}
+	for _, topic := range topics {
+		subscription.topics[topic] = true
+	}
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.subscriptions[subscription] = true
+	return subscription
+}
+
+// SetTopics changes the topics the subscription is interested in.
+// Passing no topics makes it interested in every event.
+func (bus *EventBus) SetTopics(subscription *Subscription, topics ...string) {
+	wanted := map[string]bool{}
+	for _, topic := range topics {
+		wanted[topic] = true
+	}
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	subscription.topics = wanted
+}
+
+// Unsubscribe stops delivering events to the subscription
+// and closes its channel
+func (bus *EventBus) Unsubscribe(subscription *Subscription) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	if bus.subscriptions[subscription] {
+		delete(bus.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Publish delivers the event to every interested subscriber.
+// Subscribers that have fallen behind miss the event rather
+// than holding up the publisher.
+func (bus *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	for subscription := range bus.subscriptions {
+		if !subscription.Wants(event.Topic) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			Warning.Println("events: subscriber fell behind, dropped " + event.Topic + " event for " + event.Subject)
+		}
+	}
+}
+
+// Events returns the channel events are delivered on
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Wants reports whether the subscription is interested in the topic
+func (subscription *Subscription) Wants(topic string) bool {
+	return len(subscription.topics) == 0 || subscription.topics[topic]
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	// frameHeaderSize is the length of the big endian
+	// length prefix in front of every frame
+	frameHeaderSize = 4
+	// DefaultMaxFrameSize is the largest frame accepted
+	// unless configured otherwise
+	DefaultMaxFrameSize = 16 * 1024 * 1024
+)
+
+var (
+	// MaxFrameSize is the largest frame, in bytes, that will be
+	// read or written. Anything larger is rejected before it is
+	// allocated or sent.
+	MaxFrameSize = DefaultMaxFrameSize
+)
+
+// ReadFrame reads a single length-prefixed frame from the reader.
+// Short reads are retried until the full frame has arrived.
+func ReadFrame(reader io.Reader) ([]byte, error) {
+	frameHeader := make([]byte, frameHeaderSize)
+	if _, headerErr := io.ReadFull(reader, frameHeader); headerErr != nil {
+		return nil, headerErr
+	}
+	frameLen := binary.BigEndian.Uint32(frameHeader)
+	if uint64(frameLen) > uint64(MaxFrameSize) {
+		return nil, errors.New("frame: incoming frame of " + strconv.FormatUint(uint64(frameLen), 10) + " bytes exceeds maximum of " + strconv.Itoa(MaxFrameSize))
+	}
+	frameData := make([]byte, frameLen)
+	if _, dataErr := io.ReadFull(reader, frameData); dataErr != nil {
+		if dataErr == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, dataErr
+	}
+	return frameData, nil
+}
+
+// EncodeFrame prepends the length of the data to it so that
+// it can be decoded by ReadFrame on the other end.
+func EncodeFrame(data []byte) ([]byte, error) {
+	if len(data) > MaxFrameSize {
+		return nil, errors.New("frame: outgoing frame of " + strconv.Itoa(len(data)) + " bytes exceeds maximum of " + strconv.Itoa(MaxFrameSize))
+	}
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+	return frame, nil
+}
+
+// WriteFrame encodes the data as a frame and writes it to
+// the writer in a single call.
+func WriteFrame(writer io.Writer, data []byte) error {
+	frame, encodeErr := EncodeFrame(data)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	_, writeErr := writer.Write(frame)
+	return writeErr
+}
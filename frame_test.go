@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// frameWithLength returns the data behind a length prefix that may
+// not match it
+func frameWithLength(length uint32, data []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, length)
+	return append(frame, data...)
+}
+
+func TestReadFrame(t *testing.T) {
+	maxFrameSize := MaxFrameSize
+	MaxFrameSize = 8
+	defer func() { MaxFrameSize = maxFrameSize }()
+	tests := []struct {
+		name     string
+		reader   io.Reader
+		expected []byte
+		err      bool
+	}{
+		{"whole frame", bytes.NewReader(frameWithLength(3, []byte("abc"))), []byte("abc"), false},
+		{"short reads", iotest.OneByteReader(bytes.NewReader(frameWithLength(5, []byte("hello")))), []byte("hello"), false},
+		{"half reads", iotest.HalfReader(bytes.NewReader(frameWithLength(5, []byte("hello")))), []byte("hello"), false},
+		{"zero length", bytes.NewReader(frameWithLength(0, nil)), []byte{}, false},
+		{"maximum size", bytes.NewReader(frameWithLength(8, []byte("12345678"))), []byte("12345678"), false},
+		{"over the limit", bytes.NewReader(frameWithLength(9, []byte("123456789"))), nil, true},
+	}
+	for _, test := range tests {
+		data, readErr := ReadFrame(test.reader)
+		if (readErr != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, readErr)
+			continue
+		}
+		if !test.err && !bytes.Equal(data, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, data)
+		}
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    []byte
+		expected error
+	}{
+		{"nothing", nil, io.EOF},
+		{"partial header", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"missing body", frameWithLength(4, nil), io.ErrUnexpectedEOF},
+		{"partial body", frameWithLength(4, []byte("ab")), io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		if _, readErr := ReadFrame(bytes.NewReader(test.frame)); readErr != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, readErr)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	maxFrameSize := MaxFrameSize
+	MaxFrameSize = 8
+	defer func() { MaxFrameSize = maxFrameSize }()
+	frame, encodeErr := EncodeFrame([]byte("12345678"))
+	if encodeErr != nil {
+		t.Fatal(encodeErr)
+	}
+	if data, readErr := ReadFrame(bytes.NewReader(frame)); readErr != nil || string(data) != "12345678" {
+		t.Fatalf("frame read back as %q, %v", data, readErr)
+	}
+	if _, encodeErr := EncodeFrame([]byte("123456789")); encodeErr == nil {
+		t.Fatal("expected a frame over the limit to be refused")
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// DeviceGroup is a named set of devices kept in the config. Rooms
+// hold devices and the rooms within them, such as "upstairs"
+// holding "bedroom". Groups hold devices, rooms and other groups,
+// such as "upstairs lights". Devices that aren't known any more
+// are skipped, so they rejoin when they come back.
+type DeviceGroup struct {
+	XMLName xml.Name
+	Name    string   `xml:"name,attr"`
+	Devices []string `xml:"device"`
+	Rooms   []string `xml:"room"`
+	Groups  []string `xml:"group"`
+}
+
+// DeviceTarget picks out the devices a command is sent to by type,
+// group, room or a list of device IDs. Each part that is given
+// narrows the devices down further, so a room and a type pick out
+// the devices of that type in the room. Leaving out the modifier,
+// or the core when a group, room or IDs are given, matches any.
+type DeviceTarget struct {
+	Group   string      `xml:"group,attr,omitempty"`
+	Room    string      `xml:"room,attr,omitempty"`
+	Devices []string    `xml:"device"`
+	Type    *DeviceType `xml:"type"`
+}
+
+// GetTargets returns the devices picked out by the target, sorted
+// by ID. It fails if the target names a group, room or device that
+// doesn't exist. A target with neither a core nor a group, room or
+// IDs picks out no devices.
+func (manager *DeviceManager) GetTargets(target *DeviceTarget) ([]*Device, error) {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	var picked map[string]bool
+	narrow := func(ids map[string]bool) {
+		if picked == nil {
+			picked = ids
+			return
+		}
+		for id := range picked {
+			if !ids[id] {
+				delete(picked, id)
+			}
+		}
+	}
+	if len(target.Devices) > 0 {
+		ids := map[string]bool{}
+		for _, id := range target.Devices {
+			if manager.devices[id] == nil {
+				return nil, errors.New("device: no device \"" + id + "\"")
+			}
+			ids[id] = true
+		}
+		narrow(ids)
+	}
+	if target.Group != "" {
+		group, ok := manager.groups[target.Group]
+		if !ok {
+			return nil, errors.New("device: no group \"" + target.Group + "\"")
+		}
+		narrow(manager.members(group, map[*DeviceGroup]bool{}))
+	}
+	if target.Room != "" {
+		room, ok := manager.rooms[target.Room]
+		if !ok {
+			return nil, errors.New("device: no room \"" + target.Room + "\"")
+		}
+		narrow(manager.members(room, map[*DeviceGroup]bool{}))
+	}
+	deviceType := target.Type
+	if deviceType == nil {
+		deviceType = &DeviceType{}
+	}
+	if picked == nil {
+		if deviceType.Core == "" {
+			return []*Device{}, nil
+		}
+		picked = map[string]bool{}
+		for id := range manager.byCore[deviceType.Core] {
+			picked[id] = true
+		}
+	}
+	devices := []*Device{}
+	for id := range picked {
+		device := manager.devices[id]
+		if device == nil {
+			continue
+		}
+		core, modifier := device.typeKeys()
+		if deviceType.Core != "" && core != deviceType.Core {
+			continue
+		}
+		if deviceType.Modifier != "" && modifier != deviceType.Modifier {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	sortDevices(devices)
+	return devices, nil
+}
+
+// Groups returns every group sorted by name
+func (manager *DeviceManager) Groups() []*DeviceGroup {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return sortGroups(manager.groups)
+}
+
+// Rooms returns every room sorted by name
+func (manager *DeviceManager) Rooms() []*DeviceGroup {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return sortGroups(manager.rooms)
+}
+
+// AddToGroup adds what members holds to the room, or group, with the
+// name, creating it if it doesn't exist. It fails if a device isn't
+// known or the change leaves a room or group holding itself or
+// something that doesn't exist.
+func (manager *DeviceManager) AddToGroup(room bool, name string, members *DeviceGroup) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	for _, id := range members.Devices {
+		if manager.devices[id] == nil {
+			return errors.New("device: no device \"" + id + "\"")
+		}
+	}
+	return manager.rearrange(func(rooms map[string]*DeviceGroup, groups map[string]*DeviceGroup) {
+		arranged := groups
+		if room {
+			arranged = rooms
+		}
+		group := arranged[name]
+		if group == nil {
+			group = &DeviceGroup{Name: name}
+			arranged[name] = group
+		}
+		group.Devices = union(group.Devices, members.Devices)
+		group.Rooms = union(group.Rooms, members.Rooms)
+		group.Groups = union(group.Groups, members.Groups)
+	})
+}
+
+// RemoveFromGroup removes what members holds from the room, or
+// group, with the name. When members holds nothing the room or group
+// itself is removed, along with it from anything holding it.
+func (manager *DeviceManager) RemoveFromGroup(room bool, name string, members *DeviceGroup) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if room && manager.rooms[name] == nil {
+		return errors.New("device: no room \"" + name + "\"")
+	}
+	if !room && manager.groups[name] == nil {
+		return errors.New("device: no group \"" + name + "\"")
+	}
+	return manager.rearrange(func(rooms map[string]*DeviceGroup, groups map[string]*DeviceGroup) {
+		arranged := groups
+		if room {
+			arranged = rooms
+		}
+		if len(members.Devices) == 0 && len(members.Rooms) == 0 && len(members.Groups) == 0 {
+			delete(arranged, name)
+			for _, group := range append(sortGroups(rooms), sortGroups(groups)...) {
+				if room {
+					group.Rooms = without(group.Rooms, []string{name})
+				} else {
+					group.Groups = without(group.Groups, []string{name})
+				}
+			}
+			return
+		}
+		group := arranged[name]
+		group.Devices = without(group.Devices, members.Devices)
+		group.Rooms = without(group.Rooms, members.Rooms)
+		group.Groups = without(group.Groups, members.Groups)
+	})
+}
+
+// rearrange applies the edit to copies of the rooms and groups and
+// arranges them, keeping the current ones if that fails. The rooms
+// and groups handed out earlier are never changed. The caller must
+// hold the write lock.
+func (manager *DeviceManager) rearrange(edit func(rooms map[string]*DeviceGroup, groups map[string]*DeviceGroup)) error {
+	rooms, groups := copyGroups(manager.rooms), copyGroups(manager.groups)
+	edit(rooms, groups)
+	previousRooms, previousGroups := manager.rooms, manager.groups
+	if arrangeErr := manager.arrange(sortGroups(rooms), sortGroups(groups)); arrangeErr != nil {
+		manager.rooms, manager.groups = previousRooms, previousGroups
+		return arrangeErr
+	}
+	return nil
+}
+
+// members returns the IDs of the known devices in the group or
+// room and in those it holds. The caller must hold the lock.
+func (manager *DeviceManager) members(group *DeviceGroup, seen map[*DeviceGroup]bool) map[string]bool {
+	ids := map[string]bool{}
+	if seen[group] {
+		return ids
+	}
+	seen[group] = true
+	for _, id := range group.Devices {
+		if manager.devices[id] != nil {
+			ids[id] = true
+		}
+	}
+	for _, name := range group.Rooms {
+		for id := range manager.members(manager.rooms[name], seen) {
+			ids[id] = true
+		}
+	}
+	for _, name := range group.Groups {
+		for id := range manager.members(manager.groups[name], seen) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// arrange replaces the rooms and groups, checking that every name is
+// unique, that everything they hold exists and that none of them
+// holds itself. The caller must hold the write lock.
+func (manager *DeviceManager) arrange(rooms []*DeviceGroup, groups []*DeviceGroup) error {
+	manager.rooms = map[string]*DeviceGroup{}
+	manager.groups = map[string]*DeviceGroup{}
+	for _, room := range rooms {
+		if _, ok := manager.rooms[room.Name]; ok || room.Name == "" {
+			return errors.New("device: missing or duplicate room name \"" + room.Name + "\"")
+		}
+		if len(room.Groups) > 0 {
+			return errors.New("device: room \"" + room.Name + "\" can't hold groups")
+		}
+		manager.rooms[room.Name] = room
+	}
+	for _, group := range groups {
+		if _, ok := manager.groups[group.Name]; ok || group.Name == "" {
+			return errors.New("device: missing or duplicate group name \"" + group.Name + "\"")
+		}
+		manager.groups[group.Name] = group
+	}
+	arranged := append(append([]*DeviceGroup{}, rooms...), groups...)
+	for _, group := range arranged {
+		for _, name := range group.Rooms {
+			if manager.rooms[name] == nil {
+				return errors.New("device: \"" + group.Name + "\" holds unknown room \"" + name + "\"")
+			}
+		}
+		for _, name := range group.Groups {
+			if manager.groups[name] == nil {
+				return errors.New("device: \"" + group.Name + "\" holds unknown group \"" + name + "\"")
+			}
+		}
+		if manager.holds(group, group, map[*DeviceGroup]bool{}) {
+			return errors.New("device: \"" + group.Name + "\" holds itself")
+		}
+	}
+	return nil
+}
+
+// holds reports whether the group or anything it holds holds the
+// other group. The caller must hold the lock.
+func (manager *DeviceManager) holds(group *DeviceGroup, other *DeviceGroup, seen map[*DeviceGroup]bool) bool {
+	if seen[group] {
+		return false
+	}
+	seen[group] = true
+	children := []*DeviceGroup{}
+	for _, name := range group.Rooms {
+		children = append(children, manager.rooms[name])
+	}
+	for _, name := range group.Groups {
+		children = append(children, manager.groups[name])
+	}
+	for _, child := range children {
+		if child == other || manager.holds(child, other, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortGroups(groups map[string]*DeviceGroup) []*DeviceGroup {
+	sorted := make([]*DeviceGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func copyGroups(groups map[string]*DeviceGroup) map[string]*DeviceGroup {
+	copied := make(map[string]*DeviceGroup, len(groups))
+	for name, group := range groups {
+		copied[name] = &DeviceGroup{
+			XMLName: group.XMLName,
+			Name:    group.Name,
+			Devices: append([]string{}, group.Devices...),
+			Rooms:   append([]string{}, group.Rooms...),
+			Groups:  append([]string{}, group.Groups...),
+		}
+	}
+	return copied
+}
+
+// union returns the names with the added ones that are missing
+func union(names []string, added []string) []string {
+	for _, name := range added {
+		if !containsName(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// without returns the names that aren't among the removed ones
+func without(names []string, removed []string) []string {
+	kept := []string{}
+	for _, name := range names {
+		if !containsName(removed, name) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+func containsName(names []string, name string) bool {
+	for _, other := range names {
+		if other == name {
+			return true
+		}
+	}
+	return false
+}
+
+// empty reports whether the target picks out no devices at all
+func (target *DeviceTarget) empty() bool {
+	return target.Group == "" && target.Room == "" && len(target.Devices) == 0 && (target.Type == nil || target.Type.Core == "")
+}
+
+// commandTarget returns the devices the command targets
+func commandTarget(command *packets.Command) *DeviceTarget {
+	device := command.GetDevice()
+	if device == nil {
+		return &DeviceTarget{Type: &DeviceType{}}
+	}
+	return &DeviceTarget{
+		Group:   device.Group,
+		Room:    device.Room,
+		Devices: device.Devices,
+		Type:    &DeviceType{Core: device.Core, Modifier: device.Modifier},
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetTargetsSkipsTypelessDevices(t *testing.T) {
+	manager := BuildDeviceManager()
+	manager.AddDevice(&Device{ID: "typeless", Stack: "none"})
+	manager.AddDevice(&Device{ID: "lamp", Type: &DeviceType{Core: "light", Modifier: "dimmable"}, Stack: "none"})
+	if addErr := manager.AddToGroup(false, "all", &DeviceGroup{Devices: []string{"typeless", "lamp"}}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	devices, targetErr := manager.GetTargets(&DeviceTarget{Group: "all", Type: &DeviceType{Core: "light", Modifier: "dimmable"}})
+	if targetErr != nil {
+		t.Fatal(targetErr)
+	}
+	if len(devices) != 1 || devices[0].ID != "lamp" {
+		t.Fatalf("expected only the lamp, got %v", devices)
+	}
+}
+
+func TestArrangeGroupsAndRooms(t *testing.T) {
+	manager := BuildDeviceManager()
+	manager.AddDevice(&Device{ID: "lamp", Type: &DeviceType{Core: "light"}, Stack: "none"})
+	manager.AddDevice(&Device{ID: "fan", Type: &DeviceType{Core: "fan"}, Stack: "none"})
+	if addErr := manager.AddToGroup(true, "bedroom", &DeviceGroup{Devices: []string{"lamp", "fan"}}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	if addErr := manager.AddToGroup(true, "upstairs", &DeviceGroup{Rooms: []string{"bedroom"}}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	if addErr := manager.AddToGroup(false, "cooling", &DeviceGroup{Rooms: []string{"upstairs"}}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	if devices, _ := manager.GetTargets(&DeviceTarget{Group: "cooling"}); len(devices) != 2 {
+		t.Fatalf("expected both devices upstairs, got %v", devices)
+	}
+
+	failures := []struct {
+		name   string
+		change func() error
+	}{
+		{"unknown device", func() error {
+			return manager.AddToGroup(false, "cooling", &DeviceGroup{Devices: []string{"heater"}})
+		}},
+		{"unknown room", func() error {
+			return manager.AddToGroup(false, "cooling", &DeviceGroup{Rooms: []string{"attic"}})
+		}},
+		{"room holding a group", func() error {
+			return manager.AddToGroup(true, "bedroom", &DeviceGroup{Groups: []string{"cooling"}})
+		}},
+		{"room holding itself", func() error {
+			return manager.AddToGroup(true, "bedroom", &DeviceGroup{Rooms: []string{"upstairs"}})
+		}},
+		{"unknown group", func() error {
+			return manager.RemoveFromGroup(false, "heating", &DeviceGroup{})
+		}},
+	}
+	for _, failure := range failures {
+		if changeErr := failure.change(); changeErr == nil {
+			t.Errorf("%s: expected the change to fail", failure.name)
+		}
+	}
+	if rooms, groups := manager.Rooms(), manager.Groups(); len(rooms) != 2 || len(groups) != 1 || len(rooms[0].Devices) != 2 {
+		t.Fatalf("failed changes altered the rooms %v or groups %v", rooms, groups)
+	}
+
+	if removeErr := manager.RemoveFromGroup(true, "bedroom", &DeviceGroup{Devices: []string{"lamp"}}); removeErr != nil {
+		t.Fatal(removeErr)
+	}
+	if devices, _ := manager.GetTargets(&DeviceTarget{Group: "cooling"}); len(devices) != 1 || devices[0].ID != "fan" {
+		t.Fatalf("expected only the fan, got %v", devices)
+	}
+	if removeErr := manager.RemoveFromGroup(true, "upstairs", &DeviceGroup{}); removeErr != nil {
+		t.Fatal(removeErr)
+	}
+	if groups := manager.Groups(); len(groups[0].Rooms) != 0 {
+		t.Fatalf("removed room is still held by %v", groups[0])
+	}
+	if _, targetErr := manager.GetTargets(&DeviceTarget{Room: "upstairs"}); targetErr == nil {
+		t.Fatal("expected the removed room to be gone")
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"encoding/binary"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"io"
 	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
@@ -13,59 +18,277 @@ import (
 
 // Handler handles the different protobuf messages
 type Handler struct {
-	router        *Router
-	deviceManager *DeviceManager
-	routerManager *RouterManager
+	router          *Router
+	deviceManager   *DeviceManager
+	routerManager   *RouterManager
+	sessionManager  *SessionManager
+	seenPackets     *PacketCache
+	pendingRequests *PendingRequests
+	events          *EventBus
+	outbox          *Outbox
+	transfers       *DeviceTransfers
+	schemas         *SchemaRegistry
+	replies         *ReplyRoutes
+	subscribers     *EventSubscribers
+	rules           *RuleSet
+	schedules       *Scheduler
+	scenes          *SceneSet
+	dispatch        *DispatchQueue
 }
 
+const (
+	// DefaultMaxHops is the hop limit used unless
+	// configured otherwise
+	DefaultMaxHops = 8
+)
+
 var (
-	seenPackets map[string]bool
+	// MaxHops is the number of routers a packet may pass
+	// through before it is dropped
+	MaxHops = DefaultMaxHops
+
+	errUndeliverable = errors.New("handler: packet couldn't be delivered to any router")
 )
 
+// BuildHandler returns a Handler for the given router and managers
+// along with a SessionManager for its outbound connections.
+func BuildHandler(router *Router, deviceManager *DeviceManager, routerManager *RouterManager) *Handler {
+	handler := &Handler{
+		router:          router,
+		deviceManager:   deviceManager,
+		routerManager:   routerManager,
+		seenPackets:     BuildPacketCache(PacketCacheTTL, PacketCacheSize),
+		pendingRequests: BuildPendingRequests(),
+		events:          BuildEventBus(),
+		transfers:       BuildDeviceTransfers(),
+		schemas:         BuildSchemaRegistry(),
+		replies:         BuildReplyRoutes(),
+		subscribers:     BuildEventSubscribers(),
+		rules:           BuildRuleSet(RulesPath),
+		scenes:          BuildSceneSet(),
+		dispatch:        BuildDispatchQueue(SystemClock{}),
+	}
+	handler.sessionManager = BuildSessionManager(handler)
+	handler.outbox = BuildOutbox(OutboxPath, handler.deliverQueued)
+	handler.schedules = BuildScheduler(SchedulePath, SystemClock{}, handler.runSchedule)
+	return handler
+}
+
 // Handle checks the type of packet received and routes it to
 // the appropriate hadler method.
 func (handler *Handler) Handle(proto *packets.Packet, writer io.Writer) error {
-	if seenPackets[proto.GetHeader().Id] {
+	if handler.seenPackets.Seen(proto) {
 		return errors.New("handler: already received packet #" + proto.GetHeader().Id)
 	}
-	if proto.GetHeader().Destination != "" && proto.GetHeader().Destination != handler.router.Name {
-		return handler.BroadcastProto(proto)
+	handler.lastHopSeen(proto)
+	if proto.GetHeader().Destination != "" && proto.GetHeader().Destination != handler.router.GetName() {
+		if delivered, deliverErr := handler.deliverToClient(proto); delivered {
+			return deliverErr
+		}
+		handler.expectClientReplies(proto, writer)
+		return handler.ForwardOrQueueProto(proto)
 	}
+	if proto.GetHeader().Type == packets.Packet_Header_RESPONSE && proto.GetDeviceResponse() == nil {
+		if handler.pendingRequests.Resolve(proto) {
+			return nil
+		}
+		switch proto.GetBody().(type) {
+		case *packets.Packet_SceneResponse:
+			return handler.HandleSceneResponse(proto, writer)
+		case *packets.Packet_ErrorResponse:
+			return errors.New("handler: request #" + proto.GetHeader().Id + " failed on " + proto.GetHeader().Origin + ": " + proto.GetErrorResponse().ErrorMessage)
+		}
+		return errors.New("handler: received response to unknown request #" + proto.GetHeader().Id)
+	}
+	var handleErr error
 	if handler.router.IsSetup() {
 		switch proto.GetBody().(type) {
 		case *packets.Packet_Intro:
-			return handler.HandleIntroductionPassive(proto, writer)
+			handleErr = handler.HandleIntroductionPassive(proto, writer)
 		case *packets.Packet_RouterConfigReq:
-			return handler.HandleRouterConfigurationRequest(proto, writer)
+			handleErr = handler.HandleRouterConfigurationRequest(proto, writer)
 		case *packets.Packet_DeviceTransfer:
-			return handler.HandleDeviceTransferPassive(proto, writer)
+			handleErr = handler.HandleDeviceTransferPassive(proto, writer)
+		case *packets.Packet_RouteAdvertisement:
+			handleErr = handler.HandleRouteAdvertisementPassive(proto, writer)
+		case *packets.Packet_PingReq:
+			handleErr = handler.HandlePingRequest(proto, writer)
+		case *packets.Packet_DeviceRegistrationReq:
+			handleErr = handler.HandleDeviceRegistrationRequest(proto, writer)
+		case *packets.Packet_DeviceTransferReq:
+			handleErr = handler.HandleDeviceTransferRequest(proto, writer)
 		case *packets.Packet_Command:
-			return handler.HandleCommand(proto, writer)
+			handleErr = handler.HandleCommand(proto, writer)
+		case *packets.Packet_DeviceResponse:
+			handleErr = handler.HandleDeviceResponse(proto, writer)
+		case *packets.Packet_DeviceState:
+			handleErr = handler.HandleDeviceStatePassive(proto, writer)
+		case *packets.Packet_DeviceStateReq:
+			handleErr = handler.HandleDeviceStateRequest(proto, writer)
+		case *packets.Packet_SubscribeReq:
+			handleErr = handler.HandleSubscribeRequest(proto, writer)
+		case *packets.Packet_UnsubscribeReq:
+			handleErr = handler.HandleUnsubscribeRequest(proto, writer)
+		case *packets.Packet_Event:
+			handleErr = handler.HandleEventPassive(proto, writer)
+		case *packets.Packet_ScheduleReq:
+			handleErr = handler.HandleScheduleRequest(proto, writer)
+		case *packets.Packet_SceneReq:
+			handleErr = handler.HandleSceneRequest(proto, writer)
 		default:
 			return errors.New("handler: unrecognized packet: " + proto.String())
 		}
 	} else {
 		switch proto.GetBody().(type) {
 		case *packets.Packet_RouterConfigReq:
-			return handler.HandleRouterConfigurationRequest(proto, writer)
+			handleErr = handler.HandleRouterConfigurationRequest(proto, writer)
 		default:
 			return errors.New("handler: must configure router before sending additional packets")
 		}
 	}
+	if handleErr == nil {
+		handler.publishPacket(proto)
+	}
+	return handleErr
+}
+
+// publishPacket publishes that a packet sent to the current definer
+// was handled. Packets from the current definer itself and the route
+// advertisements and pings exchanged between definers aren't published.
+func (handler *Handler) publishPacket(packet *packets.Packet) {
+	if packet.GetHeader().Origin == handler.router.GetName() {
+		return
+	}
+	switch packet.GetBody().(type) {
+	case *packets.Packet_RouteAdvertisement, *packets.Packet_PingReq:
+		return
+	}
+	handler.events.Publish(Event{
+		Topic:   TopicPacket,
+		Subject: packetKind(packet),
+		Data:    map[string]string{"origin": packet.GetHeader().Origin},
+	})
+}
+
+// ForwardOrQueueProto forwards the packet towards its destination.
+// If it can't be delivered to any router right now it is queued in
+// the outbox and retried later.
+func (handler *Handler) ForwardOrQueueProto(packet *packets.Packet) error {
+	if routeErr := handler.checkRoute(packet); routeErr != nil {
+		return routeErr
+	}
+	data, protoErr := proto.Marshal(packet)
+	if protoErr != nil {
+		return protoErr
+	}
+	forwardErr := handler.ForwardProto(packet)
+	if forwardErr == nil {
+		return nil
+	}
+	destination := packet.GetHeader().Destination
+	Warning.Println("handler: queueing packet #" + packet.GetHeader().Id + " for " + destination + ": " + forwardErr.Error())
+	handler.outbox.Enqueue(RouterDestination(destination), data)
+	return nil
 }
 
-// BroadcastProto resends the provided packet to
-// all other known routers
-func (handler *Handler) BroadcastProto(packet *packets.Packet) error   {
-	packet.GetHeader().Route = append(packet.GetHeader().Route, handler.router.Name)
+// ForwardProto sends the packet one hop closer to its destination.
+// If no route to the destination is known, or the next hop can't
+// be reached, the packet is broadcast instead. It fails if the
+// packet couldn't be handed to any router.
+func (handler *Handler) ForwardProto(packet *packets.Packet) error {
+	if routeErr := handler.checkRoute(packet); routeErr != nil {
+		return routeErr
+	}
+	header := packet.GetHeader()
+	nextHop, ok := handler.routerManager.Table.NextHop(header.Destination)
+	if !ok {
+		return handler.forwardBroadcast(packet)
+	}
+	router := handler.routerManager.GetRouter(nextHop)
+	if router == nil || router.Liveness.Offline() {
+		handler.routerManager.Table.RemoveNextHop(nextHop)
+		return handler.forwardBroadcast(packet)
+	}
+	route := header.Route
+	header.Route = append(header.Route, handler.router.GetName())
+	hostname, port := router.GetAddress()
+	writeErr := handler.WriteProtoToDest(hostname, port, packet)
+	if writeErr != nil {
+		Warning.Println("handler: couldn't forward packet #" + header.Id + " to " + nextHop + ": " + writeErr.Error())
+		handler.routerManager.Table.RemoveNextHop(nextHop)
+		header.Route = route
+		return handler.forwardBroadcast(packet)
+	}
+	return nil
+}
+
+// forwardBroadcast broadcasts a packet being forwarded, only
+// failing if no router at all received it
+func (handler *Handler) forwardBroadcast(packet *packets.Packet) error {
+	delivered, broadcastErr := handler.broadcast(packet)
+	if delivered > 0 {
+		return nil
+	}
+	if broadcastErr != nil {
+		return broadcastErr
+	}
+	return errUndeliverable
+}
+
+// BroadcastProto resends the provided packet to all other
+// known routers, skipping any that it has already visited.
+func (handler *Handler) BroadcastProto(packet *packets.Packet) error {
+	_, broadcastErr := handler.broadcast(packet)
+	return broadcastErr
+}
+
+// broadcast does the work of BroadcastProto, also returning
+// the number of routers the packet was delivered to
+func (handler *Handler) broadcast(packet *packets.Packet) (int, error) {
+	if routeErr := handler.checkRoute(packet); routeErr != nil {
+		return 0, routeErr
+	}
+	header := packet.GetHeader()
+	header.Route = append(header.Route, handler.router.GetName())
+	delivered := 0
 	var err error
-	for _, router := range handler.routerManager.Routers {
-		writeErr := handler.WriteProtoToDest(router.Hostname, router.Port, packet)
+	for _, router := range handler.routerManager.AllRouters() {
+		if router.Name == header.Origin || routeContains(header.Route, router.Name) || router.Liveness.Offline() {
+			continue
+		}
+		hostname, port := router.GetAddress()
+		writeErr := handler.WriteProtoToDest(hostname, port, packet)
 		if writeErr != nil {
 			err = writeErr
+			continue
 		}
+		delivered++
 	}
-	return err
+	return delivered, err
+}
+
+// checkRoute ensures the packet can be forwarded by the current
+// router without looping back or exceeding the hop limit.
+func (handler *Handler) checkRoute(packet *packets.Packet) error {
+	header := packet.GetHeader()
+	name := handler.router.GetName()
+	if routeContains(header.Route, name) {
+		return errors.New("handler: dropping packet #" + header.Id + "; already routed through " + name)
+	}
+	if MaxHops > 0 && len(header.Route) >= MaxHops {
+		return errors.New("handler: dropping packet #" + header.Id + "; exceeded hop limit of " + strconv.Itoa(MaxHops))
+	}
+	return nil
+}
+
+// routeContains checks whether the named router appears in the route
+func routeContains(route []string, name string) bool {
+	for _, hop := range route {
+		if hop == name {
+			return true
+		}
+	}
+	return false
 }
 
 // WriteProto marshals and writes the provided packet
@@ -80,49 +303,67 @@ func (handler *Handler) WriteProto(packet *packets.Packet, writer io.Writer) err
 }
 
 // WriteProtoToDest writes the provided proto to an alternate
-// destination than the requester.
+// destination than the requester. The session to the destination
+// is kept open and reused for later packets; if it has gone stale
+// it is redialed once before giving up.
 func (handler *Handler) WriteProtoToDest(dest string, port int, packet *packets.Packet) error {
 	packetData, prepErr := handler.preparePacket(packet)
 	if prepErr != nil {
 		return prepErr
 	}
-	conn, connErr := net.Dial("tcp", dest+":"+string(port))
-	if connErr != nil {
-		return connErr
+	address := net.JoinHostPort(dest, strconv.Itoa(port))
+	var writeErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		session, sessionErr := handler.sessionManager.GetSession(address)
+		if sessionErr != nil {
+			return sessionErr
+		}
+		if _, writeErr = session.Write(packetData); writeErr == nil {
+			return nil
+		}
+		handler.sessionManager.CloseSession(address)
 	}
-	defer conn.Close()
-	_, writeErr := conn.Write(packetData)
 	return writeErr
 }
 
 // preparePacket converts the packet into its raw form and
-// appends the length of the proto packet in little endian
-// to ensure proper decoding
+// frames it with its length to ensure proper decoding
 func (handler *Handler) preparePacket(packet *packets.Packet) ([]byte, error) {
 	protoData, protoErr := proto.Marshal(packet)
 	if protoErr != nil {
 		return protoData, protoErr
 	}
-	if len(protoData) >= 65535 {
-		return protoData, errors.New("Protobuf data too long")
-	}
-	protoLen := make([]byte, 2)
-	binary.BigEndian.PutUint16(protoLen, uint16(len(protoData)))
-	protoFinal := append(protoLen, protoData...)
-	return protoFinal, nil
+	return EncodeFrame(protoData)
 }
 
 // BuildResponseHeader builds a header in response to a
-// received packet.
+// received packet. The response keeps the request's ID so the
+// two can be matched up; the packet cache keys on origin and
+// type as well, so the response isn't dropped as a duplicate.
 func (handler *Handler) BuildResponseHeader(request *packets.Packet) *packets.Packet_Header {
 	return &packets.Packet_Header{
-		Origin:      handler.router.Name,
+		Origin:      handler.router.GetName(),
 		Destination: request.GetHeader().Origin,
-		Id:          request.GetHeader().Id,//TODO If the response header has the same ID as the request, it'll be the same and will be already seen at ever yhop along the way and will be ignored
+		Id:          request.GetHeader().Id,
 		Type:        packets.Packet_Header_RESPONSE,
 	}
 }
 
+// packetKind names the kind of body the packet carries, such
+// as "Command" or "DeviceState"
+func packetKind(packet *packets.Packet) string {
+	return strings.TrimPrefix(reflect.TypeOf(packet.GetBody()).String(), "*packets.Packet_")
+}
+
+// NewPacketID returns a random identifier for a new packet
+func NewPacketID() string {
+	id := make([]byte, 8)
+	if _, randErr := rand.Read(id); randErr != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
 // SendResponseError packages up the error into a GeneralErrorResponse
 // packet and sends it in response to a received packet.
 func (handler *Handler) SendResponseError(err error, packet *packets.Packet, writer io.Writer) error {
@@ -136,6 +377,19 @@ func (handler *Handler) SendResponseError(err error, packet *packets.Packet, wri
 	}, writer)
 }
 
+// SendResponseSuccess sends a GeneralSuccessResponse in
+// response to a received packet.
+func (handler *Handler) SendResponseSuccess(message string, packet *packets.Packet, writer io.Writer) error {
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_SuccessResponse{
+			SuccessResponse: &packets.GeneralSuccessResponse{
+				Message: message,
+			},
+		},
+	}, writer)
+}
+
 // HandleIntroductionPassive shouldn't be received by the definer ever.
 func (handler *Handler) HandleIntroductionPassive(packet *packets.Packet, writer io.Writer) error {
 	responseError := handler.SendResponseError(errors.New("definer should not receive IntroductionServer packet"), packet, writer)
@@ -149,19 +403,22 @@ func (handler *Handler) HandleIntroductionPassive(packet *packets.Packet, writer
 func (handler *Handler) HandleRouterConfigurationRequest(packet *packets.Packet, writer io.Writer) error {
 	body := packet.GetRouterConfigReq()
 	Info.Println("handler: received RouterConfigurationRequest: ", body.String())
-	Info.Println("handler: updating router SSID from " + handler.router.SSID + " to " + body.Ssid)
-	handler.router.SSID = body.Ssid
-	Info.Println("handler: updating router password from " + handler.router.Password + " to " + body.Password)
-	handler.router.Password = body.Password
-	Info.Println("handler: updating router name from " + handler.router.Name + " to " + body.Name)
-	handler.router.Name = body.Name
-	handler.router.UpdateSetup()
+	ssid, password := handler.router.GetNetwork()
+	Info.Println("handler: updating router SSID from " + ssid + " to " + body.Ssid)
+	Info.Println("handler: updating router password from " + password + " to " + body.Password)
+	Info.Println("handler: updating router name from " + handler.router.GetName() + " to " + body.Name)
+	handler.router.Configure(body.Ssid, body.Password, body.Name)
+	handler.events.Publish(Event{
+		Topic:   TopicRouterConfig,
+		Subject: body.Name,
+		Data:    map[string]string{"ssid": body.Ssid},
+	})
 	routerErr := handler.router.Initialize()
 	if routerErr != nil {
 		Error.Println(handler.SendResponseError(routerErr, packet, writer))
 		return routerErr
 	}
-	return nil
+	return handler.SendResponseSuccess("router configured", packet, writer)
 }
 
 // HandleDeviceTransferPassive deletes the device if the device manager has it
@@ -172,21 +429,45 @@ func (handler *Handler) HandleDeviceTransferPassive(packet *packets.Packet, writ
 	if body.Device == "" {
 		return errors.New("handler: invalid device transfer packet; must include valid device name")
 	}
-	if device := handler.deviceManager.GetDeviceByID(body.Device); device != nil {
-		delete(handler.deviceManager.Devices, device.Type)
+	owner := packet.GetHeader().Origin
+	if handler.deviceManager.GetDeviceByID(body.Device) != nil {
+		// Only the definer the device was released to may take it
+		if handler.transfers.replay(body.Device, owner) == nil {
+			return errors.New("handler: ignoring transfer of device " + body.Device + " to " + owner + "; it wasn't released to it")
+		}
+		handler.deviceManager.RemoveDevice(body.Device)
+		handler.dispatch.Forget(body.Device)
 	}
+	handler.publishTransfer(body.Device, owner)
 	return handler.BroadcastProto(packet)
 }
 
-// HandleCommand routes the incoming command to its respective handler
-//
-// TODO: Synchronize execution for multi-target commands
+// HandleRouteAdvertisementPassive merges the routes advertised by a
+// neighboring router into the routing table.
+func (handler *Handler) HandleRouteAdvertisementPassive(packet *packets.Packet, writer io.Writer) error {
+	neighbor := packet.GetHeader().Origin
+	if handler.routerManager.GetRouter(neighbor) == nil {
+		return errors.New("handler: ignoring route advertisement from unknown router " + neighbor)
+	}
+	if handler.routerManager.Table.Update(handler.router.GetName(), neighbor, packet.GetRouteAdvertisement().GetRoutes()) {
+		Debug.Println("handler: routing table updated from " + neighbor)
+	}
+	return nil
+}
+
+// HandleCommand routes the incoming command to its respective handler.
+// The outcome for each device is sent back in a CommandResponse. The
+// devices' own replies follow separately as they arrive.
 func (handler *Handler) HandleCommand(packet *packets.Packet, writer io.Writer) error {
-	protoDevice := packet.GetCommand().GetDevice()
-	deviceType := &DeviceType{Core: protoDevice.Core, Modifier: protoDevice.Modifier}
-	data, prepErr := handler.preparePacket(packet)
-	if prepErr != nil {
-		return prepErr
+	response, commandErr := handler.RunCommand(packet, writer)
+	if commandErr != nil {
+		Error.Println(handler.SendResponseError(commandErr, packet, writer))
+		return commandErr
 	}
-	return handler.deviceManager.SendData(deviceType, data)
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_CommandResponse{
+			CommandResponse: response,
+		},
+	}, writer)
 }
\ No newline at end of file
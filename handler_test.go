@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ottopress/definer/protos"
+)
+
+func init() {
+	InitLog(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+// startTestHandler returns a Handler for a router with the given name
+// serving sessions on a local port until the test ends
+func startTestHandler(t *testing.T, name string) *Handler {
+	router := &Router{Name: name, Hostname: "127.0.0.1", Setup: true}
+	handler := BuildHandler(router, BuildDeviceManager(), BuildRouterManager())
+	router.Port = serveSessions(t, handler)
+	return handler
+}
+
+// testPacket returns a packet for the handler's router from the origin
+func testPacket(handler *Handler, origin string, headerType packets.Packet_Header_Type) *packets.Packet {
+	return &packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin:      origin,
+			Destination: handler.router.GetName(),
+			Id:          NewPacketID(),
+			Type:        headerType,
+		},
+	}
+}
+
+// packetWriter collects the packets written to it
+type packetWriter chan *packets.Packet
+
+func (writer packetWriter) Write(data []byte) (int, error) {
+	protoData, frameErr := ReadFrame(bytes.NewReader(data))
+	if frameErr != nil {
+		return 0, frameErr
+	}
+	packet := &packets.Packet{}
+	if unmarshErr := proto.Unmarshal(protoData, packet); unmarshErr != nil {
+		return 0, unmarshErr
+	}
+	writer <- packet
+	return len(data), nil
+}
+
+// packetListener accepts connections on a local port until the test
+// ends, passing on every packet read from them
+func packetListener(t *testing.T) (int, <-chan *packets.Packet) {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan *packets.Packet, 16)
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				for {
+					data, readErr := ReadFrame(conn)
+					if readErr != nil {
+						return
+					}
+					packet := &packets.Packet{}
+					if proto.Unmarshal(data, packet) == nil {
+						received <- packet
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+// dialPhone connects to the handler's sessions the way a phone
+// would, passing on every packet read from the connection. The
+// connection is closed when the test ends.
+func dialPhone(t *testing.T, handler *Handler) (net.Conn, <-chan *packets.Packet) {
+	conn, dialErr := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(handler.router.Port)))
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+	received := make(chan *packets.Packet, 16)
+	go func() {
+		for {
+			data, readErr := ReadFrame(conn)
+			if readErr != nil {
+				return
+			}
+			packet := &packets.Packet{}
+			if proto.Unmarshal(data, packet) == nil {
+				received <- packet
+			}
+		}
+	}()
+	return conn, received
+}
+
+// writePacket writes the packet to the connection in a frame
+func writePacket(t *testing.T, conn net.Conn, packet *packets.Packet) {
+	data, protoErr := proto.Marshal(packet)
+	if protoErr != nil {
+		t.Fatal(protoErr)
+	}
+	frame, frameErr := EncodeFrame(data)
+	if frameErr != nil {
+		t.Fatal(frameErr)
+	}
+	if _, writeErr := conn.Write(frame); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+}
+
+func TestForwardingChecksRoute(t *testing.T) {
+	port, received := packetListener(t)
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.routerManager.AddRouter(&Router{Name: "B", Hostname: "127.0.0.1", Port: port})
+	defer handler.sessionManager.CloseAll()
+	longest := []string{}
+	for len(longest) < MaxHops-1 {
+		longest = append(longest, "R"+strconv.Itoa(len(longest)))
+	}
+	tests := []struct {
+		name      string
+		route     []string
+		forwarded bool
+	}{
+		{"new route", []string{"X"}, true},
+		{"longest route", longest, true},
+		{"hop limit", append(longest, "Y"), false},
+		{"loop", []string{"X", "A", "Y"}, false},
+	}
+	for i, test := range tests {
+		packet := &packets.Packet{
+			Header: &packets.Packet_Header{Origin: "X", Destination: "C", Id: strconv.Itoa(i), Type: packets.Packet_Header_PASSIVE, Route: append([]string{}, test.route...)},
+		}
+		handleErr := handler.Handle(packet, ioutil.Discard)
+		if !test.forwarded {
+			if handleErr == nil {
+				t.Errorf("%s: expected the packet to be dropped", test.name)
+			}
+			continue
+		}
+		if handleErr != nil {
+			t.Errorf("%s: %v", test.name, handleErr)
+			continue
+		}
+		forwarded := <-received
+		expected := append(append([]string{}, test.route...), "A")
+		if forwarded.GetHeader().Id != packet.GetHeader().Id || strings.Join(forwarded.GetHeader().Route, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: expected route %v, got %v", test.name, expected, forwarded.GetHeader().Route)
+		}
+	}
+	select {
+	case packet := <-received:
+		t.Fatalf("dropped packet was forwarded: %v", packet)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestHandleConcurrently(t *testing.T) {
+	handler := startTestHandler(t, "A")
+	config := &Config{
+		Router:        handler.router,
+		DeviceManager: handler.deviceManager,
+		RouterManager: handler.routerManager,
+		Settings:      BuildSettings(),
+	}
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		device := "d" + strconv.Itoa(i)
+		peer := "R" + strconv.Itoa(i%5)
+		wait.Add(5)
+		go func() {
+			defer wait.Done()
+			handler.deviceManager.AddDevice(&Device{ID: device, Type: &DeviceType{Core: "light"}, Stack: "none"})
+			packet := testPacket(handler, "X", packets.Packet_Header_PASSIVE)
+			packet.Body = &packets.Packet_DeviceTransfer{DeviceTransfer: &packets.DeviceTransferPassive{Device: "gone" + device}}
+			handler.Handle(packet, ioutil.Discard)
+		}()
+		go func() {
+			defer wait.Done()
+			handler.routerManager.AddRouter(&Router{Name: peer, Hostname: "127.0.0.1", Port: 1})
+			packet := testPacket(handler, peer, packets.Packet_Header_PASSIVE)
+			packet.Body = &packets.Packet_RouteAdvertisement{RouteAdvertisement: &packets.RouteAdvertisementPassive{}}
+			handler.Handle(packet, ioutil.Discard)
+		}()
+		go func() {
+			defer wait.Done()
+			if _, marshalErr := xml.Marshal(config); marshalErr != nil {
+				t.Error(marshalErr)
+			}
+			handler.deviceManager.AllDevices()
+			for _, router := range handler.routerManager.AllRouters() {
+				router.SetAddress("127.0.0.1", 2)
+			}
+		}()
+		go func() {
+			defer wait.Done()
+			packet := testPacket(handler, "X", packets.Packet_Header_REQUEST)
+			packet.Body = &packets.Packet_RouterConfigReq{RouterConfigReq: &packets.RouterConfigurationRequest{Name: "A", Ssid: "s" + strconv.Itoa(i)}}
+			handler.Handle(packet, ioutil.Discard)
+		}()
+		go func() {
+			defer wait.Done()
+			packet := testPacket(handler, "X", packets.Packet_Header_REQUEST)
+			packet.Body = &packets.Packet_Command{Command: &packets.Command{
+				Device: &packets.Command_Device{Core: "light"},
+				Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: "on"}},
+			}}
+			handler.Handle(packet, ioutil.Discard)
+			handler.routerManager.RemoveRouter(peer)
+		}()
+	}
+	wait.Wait()
+	if devices := handler.deviceManager.AllDevices(); len(devices) != 50 {
+		t.Fatalf("expected 50 devices, got %d", len(devices))
+	}
+}
+
+func TestPacketEventsOnlyForHandledPackets(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Stack: "none"})
+	subscription := handler.events.Subscribe(TopicPacket)
+	ping := testPacket(handler, "B", packets.Packet_Header_REQUEST)
+	ping.Body = &packets.Packet_PingReq{PingReq: &packets.PingRequest{}}
+	unknown := testPacket(handler, "B", packets.Packet_Header_PASSIVE)
+	state := testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	state.Body = &packets.Packet_DeviceStateReq{DeviceStateReq: &packets.DeviceStateRequest{Devices: []string{"d1"}}}
+	for _, packet := range []*packets.Packet{ping, unknown, state} {
+		handler.Handle(packet, ioutil.Discard)
+	}
+	select {
+	case event := <-subscription.Events():
+		if event.Subject != "DeviceStateReq" || event.Data["origin"] != "phone" {
+			t.Fatalf("unexpected event: %v", event)
+		}
+	default:
+		t.Fatal("handled packet wasn't published")
+	}
+	select {
+	case event := <-subscription.Events():
+		t.Fatalf("unexpected event: %v", event)
+	default:
+	}
+}
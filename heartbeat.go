@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// Heartbeat periodically pings every known router and device and
+// publishes an event whenever one of them goes online or offline.
+// It never returns.
+func (handler *Handler) Heartbeat() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range handler.routerManager.AllRouters() {
+			go handler.pingRouter(peer)
+		}
+		for _, device := range handler.deviceManager.AllDevices() {
+			go handler.pingDevice(device)
+		}
+		<-ticker.C
+	}
+}
+
+// pingRouter sends a ping directly to the router, bypassing the
+// routing table so that only the direct link is tested.
+func (handler *Handler) pingRouter(peer *Router) {
+	_, pingErr := handler.SendRequestToRouter(peer, handler.buildPing(peer.Name), HeartbeatInterval)
+	if pingErr != nil {
+		Debug.Println("heartbeat: router " + peer.Name + " missed heartbeat: " + pingErr.Error())
+		handler.routerMissed(peer)
+		return
+	}
+	handler.routerSeen(peer)
+}
+
+// pingDevice sends a ping to the device over its stack. The device
+// is only seen once it answers or sends anything else back.
+func (handler *Handler) pingDevice(device *Device) {
+	data, protoErr := proto.Marshal(handler.buildPing(device.ID))
+	if protoErr != nil {
+		Error.Println("heartbeat: couldn't build ping: " + protoErr.Error())
+		return
+	}
+	conn, dialErr := device.Dial()
+	if dialErr != nil {
+		handler.deviceMissed(device, dialErr.Error())
+		return
+	}
+	if sendErr := conn.Send(data); sendErr != nil {
+		conn.Close()
+		handler.deviceMissed(device, sendErr.Error())
+		return
+	}
+	if !handler.receiveFromDevice(device, conn, HeartbeatInterval) {
+		handler.deviceMissed(device, "no answer")
+	}
+}
+
+// deviceSeen brings the device online
+func (handler *Handler) deviceSeen(device *Device) {
+	if device.Liveness.Seen() {
+		handler.publishLiveness(TopicDeviceLiveness, device.ID, device.Liveness.State())
+	}
+}
+
+// deviceMissed counts a missed heartbeat against the device
+func (handler *Handler) deviceMissed(device *Device, reason string) {
+	Debug.Println("heartbeat: device " + device.ID + " missed heartbeat: " + reason)
+	if device.Liveness.Missed() {
+		handler.publishLiveness(TopicDeviceLiveness, device.ID, device.Liveness.State())
+	}
+}
+
+func (handler *Handler) buildPing(destination string) *packets.Packet {
+	return &packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin:      handler.router.GetName(),
+			Destination: destination,
+			Id:          NewPacketID(),
+			Type:        packets.Packet_Header_REQUEST,
+		},
+		Body: &packets.Packet_PingReq{
+			PingReq: &packets.PingRequest{
+				Timestamp: time.Now().UnixNano(),
+			},
+		},
+	}
+}
+
+// routerSeen brings the router online
+func (handler *Handler) routerSeen(peer *Router) {
+	if peer.Liveness.Seen() {
+		handler.publishLiveness(TopicRouterLiveness, peer.Name, StateOnline)
+	}
+}
+
+// routerMissed counts a missed heartbeat against the router and
+// stops routing through it once it goes offline
+func (handler *Handler) routerMissed(peer *Router) {
+	if peer.Liveness.Missed() {
+		handler.routerManager.Table.RemoveNextHop(peer.Name)
+		handler.publishLiveness(TopicRouterLiveness, peer.Name, StateOffline)
+	}
+}
+
+// lastHopSeen marks the router the packet arrived from as online.
+// Every forwarding router appends itself to the route, so the last
+// hop is the end of the route or the origin if it is empty. A packet
+// sent by one of the current definer's devices marks it online too.
+func (handler *Handler) lastHopSeen(packet *packets.Packet) {
+	lastHop := packet.GetHeader().Origin
+	if route := packet.GetHeader().Route; len(route) > 0 {
+		lastHop = route[len(route)-1]
+	}
+	if peer := handler.routerManager.GetRouter(lastHop); peer != nil {
+		handler.routerSeen(peer)
+	}
+	if device := handler.deviceManager.GetDeviceByID(packet.GetHeader().Origin); device != nil {
+		handler.deviceSeen(device)
+	}
+}
+
+func (handler *Handler) publishLiveness(topic string, subject string, state LivenessState) {
+	Info.Println("heartbeat: " + subject + " is now " + state.String())
+	handler.events.Publish(Event{
+		Topic:   topic,
+		Subject: subject,
+		Data:    map[string]string{"state": state.String()},
+	})
+}
+
+// HandlePingRequest answers a ping, echoing its timestamp
+func (handler *Handler) HandlePingRequest(packet *packets.Packet, writer io.Writer) error {
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_PingResponse{
+			PingResponse: &packets.PingResponse{
+				Timestamp: packet.GetPingReq().Timestamp,
+			},
+		},
+	}, writer)
+}
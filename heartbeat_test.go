@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ottopress/definer/protos"
+)
+
+// attachMemoryStack registers a MemoryStack for the test
+func attachMemoryStack(t *testing.T) *MemoryStack {
+	stack := BuildMemoryStack()
+	RegisterStack(stackMemory, stack)
+	t.Cleanup(func() { UnregisterStack(stackMemory) })
+	return stack
+}
+
+// answerPings replies to every ping the endpoint receives
+func answerPings(endpoint *MemoryEndpoint) {
+	for {
+		data, receiveErr := endpoint.Receive()
+		if receiveErr != nil {
+			return
+		}
+		packet := &packets.Packet{}
+		if proto.Unmarshal(data, packet) != nil || packet.GetPingReq() == nil {
+			continue
+		}
+		reply, _ := proto.Marshal(&packets.Packet{
+			Header: &packets.Packet_Header{Origin: packet.GetHeader().Destination, Id: packet.GetHeader().Id, Type: packets.Packet_Header_RESPONSE},
+			Body:   &packets.Packet_PingResponse{PingResponse: &packets.PingResponse{Timestamp: packet.GetPingReq().Timestamp}},
+		})
+		endpoint.Send(reply)
+	}
+}
+
+func TestPingDeviceNeedsAnAnswer(t *testing.T) {
+	interval, misses := HeartbeatInterval, HeartbeatMisses
+	HeartbeatInterval, HeartbeatMisses = 50*time.Millisecond, 1
+	defer func() { HeartbeatInterval, HeartbeatMisses = interval, misses }()
+	stack := attachMemoryStack(t)
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	alive := &Device{ID: "alive", Address: "a", Stack: stackMemory}
+	hung := &Device{ID: "hung", Address: "h", Stack: stackMemory}
+	gone := &Device{ID: "gone", Address: "g", Stack: stackMemory}
+	go answerPings(stack.Attach("a"))
+	defer stack.Detach("a")
+	stack.Attach("h")
+	defer stack.Detach("h")
+
+	handler.pingDevice(alive)
+	if state := alive.Liveness.State(); state != StateOnline {
+		t.Fatalf("device answering pings is %s", state)
+	}
+	handler.pingDevice(hung)
+	if state := hung.Liveness.State(); state != StateOffline {
+		t.Fatalf("device accepting pings without answering is %s", state)
+	}
+	handler.pingDevice(gone)
+	if state := gone.Liveness.State(); state != StateOffline {
+		t.Fatalf("unreachable device is %s", state)
+	}
+}
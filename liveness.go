@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// LivenessState is whether a router or device is reachable
+type LivenessState int
+
+const (
+	// StateUnknown means nothing has been heard from it yet
+	StateUnknown LivenessState = iota
+	// StateOnline means it answered recently
+	StateOnline
+	// StateOffline means it missed too many heartbeats in a row
+	StateOffline
+)
+
+const (
+	// DefaultHeartbeatInterval is how often peers and devices are
+	// pinged unless configured otherwise
+	DefaultHeartbeatInterval = 15 * time.Second
+	// DefaultHeartbeatMisses is how many heartbeats in a row may
+	// be missed before going offline unless configured otherwise
+	DefaultHeartbeatMisses = 3
+)
+
+var (
+	// HeartbeatInterval is how often peers and devices are pinged
+	HeartbeatInterval = DefaultHeartbeatInterval
+	// HeartbeatMisses is the number of heartbeats in a row that
+	// may be missed before a peer or device is marked offline
+	HeartbeatMisses = DefaultHeartbeatMisses
+)
+
+// Liveness tracks whether a router or device is reachable. It is
+// safe for concurrent use.
+type Liveness struct {
+	lock     sync.Mutex
+	state    LivenessState
+	lastSeen time.Time
+	missed   int
+}
+
+// String returns the name of the state
+func (state LivenessState) String() string {
+	switch state {
+	case StateOnline:
+		return "online"
+	case StateOffline:
+		return "offline"
+	}
+	return "unknown"
+}
+
+// Seen records that it was heard from, bringing it online.
+// It reports whether the state changed.
+func (liveness *Liveness) Seen() bool {
+	liveness.lock.Lock()
+	defer liveness.lock.Unlock()
+	liveness.lastSeen = time.Now()
+	liveness.missed = 0
+	changed := liveness.state != StateOnline
+	liveness.state = StateOnline
+	return changed
+}
+
+// Missed records a heartbeat that went unanswered, taking it
+// offline once HeartbeatMisses have been missed in a row. It
+// reports whether the state changed.
+func (liveness *Liveness) Missed() bool {
+	liveness.lock.Lock()
+	defer liveness.lock.Unlock()
+	liveness.missed++
+	if liveness.missed < HeartbeatMisses || liveness.state == StateOffline {
+		return false
+	}
+	liveness.state = StateOffline
+	return true
+}
+
+// State returns the current state
+func (liveness *Liveness) State() LivenessState {
+	liveness.lock.Lock()
+	defer liveness.lock.Unlock()
+	return liveness.state
+}
+
+// LastSeen returns when it was last heard from
+func (liveness *Liveness) LastSeen() time.Time {
+	liveness.lock.Lock()
+	defer liveness.lock.Unlock()
+	return liveness.lastSeen
+}
+
+// Offline reports whether it is known to be unreachable
+func (liveness *Liveness) Offline() bool {
+	return liveness.State() == StateOffline
+}
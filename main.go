@@ -47,6 +47,11 @@ var (
 	// routerManager contains the routers the
 	// current definer can access
 	routerManager *RouterManager
+	// discovery announces the current definer and
+	// finds others on the local network
+	discovery *Discovery
+	// outbox holds the packets waiting to be delivered
+	outbox *Outbox
 	// Environment represents the environment this software
 	// is running under
 	Environment = EnvEmulated
@@ -64,6 +69,7 @@ func main() {
 		os.Exit(1)
 	}
 	Info.Println("Config loaded!")
+	config.Settings.Apply()
 	router = config.Router
 	deviceManager = config.DeviceManager
 	routerManager = config.RouterManager
@@ -71,10 +77,29 @@ func main() {
 	InitCleanup(config)
 	Info.Println("Cleanup Handler initialized!")
 	Info.Println("Initialize Servers...")
-	handler := &Handler{router: router, deviceManager: deviceManager, routerManager: routerManager}
-	InitServers(router, handler, deviceManager)
+	handler := BuildHandler(router, deviceManager, routerManager)
+	handler.scenes = config.Scenes
+	outbox = handler.outbox
+	if outboxErr := handler.outbox.Load(); outboxErr != nil {
+		Warning.Println("Couldn't restore outbox: " + outboxErr.Error())
+	}
+	if schemaErr := handler.schemas.Load(SchemaPath); schemaErr != nil {
+		Warning.Println("Couldn't load capability schemas: " + schemaErr.Error())
+	}
+	if rulesErr := handler.rules.Load(); rulesErr != nil {
+		Warning.Println("Couldn't load automation rules: " + rulesErr.Error())
+	}
+	if schedulesErr := handler.schedules.Load(); schedulesErr != nil {
+		Warning.Println("Couldn't load schedules: " + schedulesErr.Error())
+	}
+	InitServers(router, handler, deviceManager, routerManager)
 	go ConsoleServ.Listen()
 	go WifiServ.Listen()
+	go handler.AdvertiseRoutes()
+	go handler.Heartbeat()
+	go handler.RunOutbox()
+	go handler.RunRules()
+	go handler.schedules.Run()
 	Info.Println("Servers initialized!")
 	Info.Println("Initializing Router...")
 	routerInitErr := router.Initialize()
@@ -82,6 +107,15 @@ func main() {
 		Error.Println(routerInitErr)
 	}
 	Info.Println("Router initialized!")
+	Info.Println("Initializing Discovery...")
+	transport, transportErr := BuildUDPMulticastTransport(MulticastAddress)
+	if transportErr != nil {
+		Warning.Println("Discovery unavailable: " + transportErr.Error())
+	} else {
+		discovery = BuildDiscovery(transport, router, routerManager)
+		go discovery.Run()
+		Info.Println("Discovery initialized!")
+	}
 	elapsed := time.Since(start).Seconds()
 	Info.Printf("Done! [took %.3f seconds]...", elapsed)
 	for {}
@@ -102,6 +136,14 @@ func InitCleanup(config *Config) {
 // cleanup ensures that all connections are closed,
 // files are written, etc. before the software restarts
 func cleanup(config *Config) {
+	if discovery != nil {
+		discovery.Close()
+	}
+	if outbox != nil {
+		if persistErr := outbox.Persist(); persistErr != nil {
+			Error.Println("Couldn't persist outbox: " + persistErr.Error())
+		}
+	}
 	writeErr := config.WriteConfig(configPath)
 	if writeErr != nil {
 		Error.Println(writeErr.Error())
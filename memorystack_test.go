@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	stackMemory = "memory"
+)
+
+var (
+	errStackClosed = errors.New("stack: connection closed")
+)
+
+// MemoryStack is an in-memory Stack for exercising the definer
+// without real devices. A fake device is attached at an address
+// and receives every packet sent to devices with that address.
+// Tests register it under stackMemory.
+type MemoryStack struct {
+	lock      sync.Mutex
+	endpoints map[string]*MemoryEndpoint
+}
+
+// MemoryEndpoint is the device side of a MemoryStack address
+type MemoryEndpoint struct {
+	toDevice  chan []byte
+	toDefiner chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// memoryConn is the definer side of a connection to a MemoryEndpoint
+type memoryConn struct {
+	endpoint  *MemoryEndpoint
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// BuildMemoryStack returns a MemoryStack with no devices attached
+func BuildMemoryStack() *MemoryStack {
+	return &MemoryStack{endpoints: map[string]*MemoryEndpoint{}}
+}
+
+// Attach adds a fake device at the address, replacing any device
+// already attached there, and returns its endpoint
+func (stack *MemoryStack) Attach(address string) *MemoryEndpoint {
+	endpoint := &MemoryEndpoint{
+		toDevice:  make(chan []byte, 64),
+		toDefiner: make(chan []byte, 64),
+		done:      make(chan struct{}),
+	}
+	stack.lock.Lock()
+	previous := stack.endpoints[address]
+	stack.endpoints[address] = endpoint
+	stack.lock.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return endpoint
+}
+
+// Detach removes the fake device at the address, as if it had
+// been switched off
+func (stack *MemoryStack) Detach(address string) {
+	stack.lock.Lock()
+	endpoint := stack.endpoints[address]
+	delete(stack.endpoints, address)
+	stack.lock.Unlock()
+	if endpoint != nil {
+		endpoint.Close()
+	}
+}
+
+// Dial connects to the fake device attached at the device's address
+func (stack *MemoryStack) Dial(device *Device) (StackConn, error) {
+	stack.lock.Lock()
+	defer stack.lock.Unlock()
+	endpoint, ok := stack.endpoints[device.Address]
+	if !ok {
+		return nil, errors.New("stack: no device attached at " + device.Address)
+	}
+	return &memoryConn{endpoint: endpoint, done: make(chan struct{})}, nil
+}
+
+// Receive blocks until the definer sends the device a packet
+func (endpoint *MemoryEndpoint) Receive() ([]byte, error) {
+	select {
+	case data := <-endpoint.toDevice:
+		return data, nil
+	case <-endpoint.done:
+		return nil, errStackClosed
+	}
+}
+
+// Send queues a packet from the device for the definer
+func (endpoint *MemoryEndpoint) Send(data []byte) error {
+	select {
+	case <-endpoint.done:
+		return errStackClosed
+	case endpoint.toDefiner <- append([]byte{}, data...):
+		return nil
+	}
+}
+
+// Close disconnects the device. It is safe to call more than once.
+func (endpoint *MemoryEndpoint) Close() error {
+	endpoint.closeOnce.Do(func() {
+		close(endpoint.done)
+	})
+	return nil
+}
+
+// Send hands the packet to the device. It fails rather than
+// blocks if the device isn't keeping up.
+func (conn *memoryConn) Send(data []byte) error {
+	select {
+	case <-conn.done:
+		return errStackClosed
+	case <-conn.endpoint.done:
+		return errStackClosed
+	default:
+	}
+	select {
+	case conn.endpoint.toDevice <- append([]byte{}, data...):
+		return nil
+	default:
+		return errors.New("stack: device isn't receiving")
+	}
+}
+
+// Receive blocks until the device sends a packet
+func (conn *memoryConn) Receive() ([]byte, error) {
+	select {
+	case data := <-conn.endpoint.toDefiner:
+		return data, nil
+	case <-conn.done:
+		return nil, errStackClosed
+	case <-conn.endpoint.done:
+		return nil, errStackClosed
+	}
+}
+
+// Close ends the connection without disconnecting the device
+func (conn *memoryConn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+	})
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	// MulticastAddress is the mDNS group and port used
+	// for discovering other definers
+	MulticastAddress = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+	errTransportClosed = errors.New("multicast: transport closed")
+)
+
+// MulticastTransport carries raw messages to and from every
+// member of a multicast group, including the sender itself.
+type MulticastTransport interface {
+	Send(data []byte) error
+	Receive() ([]byte, error)
+	Close() error
+}
+
+// UDPMulticastTransport is a MulticastTransport on top of a
+// real UDP multicast group
+type UDPMulticastTransport struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+// LoopbackMulticast is an in-memory stand-in for a multicast
+// group. Every message sent by a member is delivered to all
+// members that have joined.
+type LoopbackMulticast struct {
+	lock    sync.Mutex
+	members map[*loopbackMember]bool
+}
+
+type loopbackMember struct {
+	group     *LoopbackMulticast
+	inbox     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// BuildUDPMulticastTransport joins the given multicast group on
+// all interfaces
+func BuildUDPMulticastTransport(group *net.UDPAddr) (*UDPMulticastTransport, error) {
+	conn, connErr := net.ListenMulticastUDP("udp4", nil, group)
+	if connErr != nil {
+		return nil, connErr
+	}
+	return &UDPMulticastTransport{conn: conn, group: group}, nil
+}
+
+// Send writes the message to the multicast group
+func (transport *UDPMulticastTransport) Send(data []byte) error {
+	_, writeErr := transport.conn.WriteToUDP(data, transport.group)
+	return writeErr
+}
+
+// Receive blocks until a message arrives from the group
+func (transport *UDPMulticastTransport) Receive() ([]byte, error) {
+	buffer := make([]byte, 9000)
+	n, _, readErr := transport.conn.ReadFromUDP(buffer)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return buffer[:n], nil
+}
+
+// Close leaves the multicast group
+func (transport *UDPMulticastTransport) Close() error {
+	return transport.conn.Close()
+}
+
+// BuildLoopbackMulticast returns an empty in-memory multicast group
+func BuildLoopbackMulticast() *LoopbackMulticast {
+	return &LoopbackMulticast{members: map[*loopbackMember]bool{}}
+}
+
+// Join adds a new member to the group and returns its transport
+func (group *LoopbackMulticast) Join() MulticastTransport {
+	member := &loopbackMember{
+		group: group,
+		inbox: make(chan []byte, 64),
+		done:  make(chan struct{}),
+	}
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	group.members[member] = true
+	return member
+}
+
+// Send delivers a copy of the message to every member. Members
+// that aren't keeping up drop the message, as they would on a
+// congested network.
+func (member *loopbackMember) Send(data []byte) error {
+	select {
+	case <-member.done:
+		return errTransportClosed
+	default:
+	}
+	member.group.lock.Lock()
+	defer member.group.lock.Unlock()
+	for other := range member.group.members {
+		message := append([]byte{}, data...)
+		select {
+		case other.inbox <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+// Receive blocks until a message arrives or the member leaves
+func (member *loopbackMember) Receive() ([]byte, error) {
+	select {
+	case message := <-member.inbox:
+		return message, nil
+	case <-member.done:
+		return nil, errTransportClosed
+	}
+}
+
+// Close removes the member from the group
+func (member *loopbackMember) Close() error {
+	member.closeOnce.Do(func() {
+		member.group.lock.Lock()
+		delete(member.group.members, member)
+		member.group.lock.Unlock()
+		close(member.done)
+	})
+	return nil
+}
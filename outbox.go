@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultOutboxExpiry is how long a queued packet is kept
+	// unless configured otherwise
+	DefaultOutboxExpiry = 10 * time.Minute
+	// DefaultOutboxDepth is the number of packets queued per
+	// destination unless configured otherwise
+	DefaultOutboxDepth = 256
+	// outboxRetryBase is the delay before the first retry
+	outboxRetryBase = time.Second
+	// outboxRetryMax caps the delay between retries
+	outboxRetryMax = 5 * time.Minute
+	// outboxTick is how often due retries are checked for
+	outboxTick = time.Second
+
+	destinationRouter = "router"
+	destinationDevice = "device"
+)
+
+var (
+	// OutboxExpiry is how long a packet stays queued before
+	// it is given up on
+	OutboxExpiry = DefaultOutboxExpiry
+	// OutboxDepth is the maximum number of packets queued for a
+	// single destination. When full, the oldest packet is dropped.
+	OutboxDepth = DefaultOutboxDepth
+	// OutboxPath is the file queued packets are persisted to so
+	// that they survive a restart. Persistence is off when empty.
+	OutboxPath = ""
+
+	errQueued = errors.New("outbox: destination unreachable, packet queued")
+)
+
+// Outbox queues packets that couldn't be delivered and retries
+// them with exponential backoff until they are delivered or
+// expire. Packets are queued per destination, which is either
+// "router/<name>" or "device/<id>". Changes are persisted in
+// batches by Run rather than as they happen. It is safe for
+// concurrent use.
+type Outbox struct {
+	lock    sync.Mutex
+	queues  map[string]*outboxQueue
+	path    string
+	deliver func(destination string, data []byte) error
+	wake    chan struct{}
+	// dirty is set when the queues changed since they were
+	// last persisted
+	dirty    bool
+	saveLock sync.Mutex
+}
+
+// OutboxStatus summarizes the queue for a single destination
+type OutboxStatus struct {
+	Destination string
+	Depth       int
+	Attempts    int
+	NextAttempt time.Time
+}
+
+type outboxQueue struct {
+	entries     []*outboxEntry
+	attempts    int
+	nextAttempt time.Time
+}
+
+type outboxEntry struct {
+	XMLName xml.Name  `xml:"packet"`
+	Queued  time.Time `xml:"queued,attr"`
+	Expires time.Time `xml:"expires,attr"`
+	Data    string    `xml:",chardata"`
+}
+
+// outboxFile is the on-disk form of the Outbox
+type outboxFile struct {
+	XMLName xml.Name          `xml:"outbox"`
+	Queues  []outboxFileQueue `xml:"queue"`
+}
+
+type outboxFileQueue struct {
+	Destination string         `xml:"destination,attr"`
+	Entries     []*outboxEntry `xml:"packet"`
+}
+
+// BuildOutbox returns an empty Outbox that persists to the given
+// path and hands due packets to the deliver function
+func BuildOutbox(path string, deliver func(destination string, data []byte) error) *Outbox {
+	return &Outbox{
+		queues:  map[string]*outboxQueue{},
+		path:    path,
+		deliver: deliver,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// RouterDestination returns the outbox destination for a router
+func RouterDestination(name string) string {
+	return destinationRouter + "/" + name
+}
+
+// DeviceDestination returns the outbox destination for a device
+func DeviceDestination(id string) string {
+	return destinationDevice + "/" + id
+}
+
+// splitDestination breaks a destination into its kind and name
+func splitDestination(destination string) (string, string) {
+	parts := strings.SplitN(destination, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Enqueue queues the marshalled packet for the destination
+func (outbox *Outbox) Enqueue(destination string, data []byte) {
+	now := time.Now()
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+	queue, ok := outbox.queues[destination]
+	if !ok {
+		queue = &outboxQueue{nextAttempt: now.Add(outboxRetryBase)}
+		outbox.queues[destination] = queue
+	}
+	queue.entries = append(queue.entries, &outboxEntry{
+		Queued:  now,
+		Expires: now.Add(OutboxExpiry),
+		Data:    base64.StdEncoding.EncodeToString(data),
+	})
+	if OutboxDepth > 0 && len(queue.entries) > OutboxDepth {
+		Warning.Println("outbox: queue for " + destination + " is full, dropping oldest packet")
+		queue.entries = queue.entries[len(queue.entries)-OutboxDepth:]
+	}
+	outbox.dirty = true
+}
+
+// Wake retries every queue whose destination starts with the
+// prefix immediately, resetting its backoff
+func (outbox *Outbox) Wake(prefix string) {
+	outbox.lock.Lock()
+	for destination, queue := range outbox.queues {
+		if strings.HasPrefix(destination, prefix) {
+			queue.attempts = 0
+			queue.nextAttempt = time.Time{}
+		}
+	}
+	outbox.lock.Unlock()
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run retries queued packets as they come due. It never returns.
+func (outbox *Outbox) Run() {
+	ticker := time.NewTicker(outboxTick)
+	defer ticker.Stop()
+	for {
+		outbox.flush()
+		if persistErr := outbox.Persist(); persistErr != nil {
+			Error.Println("outbox: couldn't persist queue: " + persistErr.Error())
+		}
+		select {
+		case <-ticker.C:
+		case <-outbox.wake:
+		}
+	}
+}
+
+// flush attempts delivery for every queue that is due
+func (outbox *Outbox) flush() {
+	outbox.lock.Lock()
+	due := []string{}
+	now := time.Now()
+	for destination, queue := range outbox.queues {
+		if !now.Before(queue.nextAttempt) {
+			due = append(due, destination)
+		}
+	}
+	outbox.lock.Unlock()
+	for _, destination := range due {
+		outbox.flushQueue(destination)
+	}
+}
+
+// flushQueue delivers the queued packets for the destination in
+// order, stopping at the first failure. The lock isn't held while
+// delivering so new packets can be queued in the meantime.
+func (outbox *Outbox) flushQueue(destination string) {
+	for {
+		outbox.lock.Lock()
+		queue, ok := outbox.queues[destination]
+		if !ok {
+			outbox.lock.Unlock()
+			return
+		}
+		outbox.dropExpired(destination, queue, time.Now())
+		if len(queue.entries) == 0 {
+			delete(outbox.queues, destination)
+			outbox.dirty = true
+			outbox.lock.Unlock()
+			return
+		}
+		entry := queue.entries[0]
+		outbox.lock.Unlock()
+
+		data, decodeErr := base64.StdEncoding.DecodeString(entry.Data)
+		deliverErr := decodeErr
+		if deliverErr == nil {
+			deliverErr = outbox.deliver(destination, data)
+		}
+
+		outbox.lock.Lock()
+		if deliverErr != nil {
+			queue.attempts++
+			queue.nextAttempt = time.Now().Add(outboxBackoff(queue.attempts))
+			Debug.Println("outbox: delivery to " + destination + " failed, will retry: " + deliverErr.Error())
+			outbox.lock.Unlock()
+			return
+		}
+		queue.attempts = 0
+		for i, queued := range queue.entries {
+			if queued == entry {
+				queue.entries = append(queue.entries[:i], queue.entries[i+1:]...)
+				break
+			}
+		}
+		outbox.dirty = true
+		outbox.lock.Unlock()
+	}
+}
+
+// dropExpired removes the packets that have outlived their expiry.
+// The caller must hold the lock.
+func (outbox *Outbox) dropExpired(destination string, queue *outboxQueue, now time.Time) {
+	kept := queue.entries[:0]
+	for _, entry := range queue.entries {
+		if now.After(entry.Expires) {
+			Warning.Println("outbox: packet for " + destination + " expired undelivered")
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	queue.entries = kept
+}
+
+// outboxBackoff returns the delay before the given retry attempt
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxRetryBase
+	for i := 1; i < attempts && backoff < outboxRetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxRetryMax {
+		return outboxRetryMax
+	}
+	return backoff
+}
+
+// Take removes the queue for the destination, returning the
+// packets that hadn't expired yet in the order they were queued
+func (outbox *Outbox) Take(destination string) [][]byte {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+	queue, ok := outbox.queues[destination]
+	if !ok {
+		return nil
+	}
+	delete(outbox.queues, destination)
+	outbox.dropExpired(destination, queue, time.Now())
+	taken := [][]byte{}
+	for _, entry := range queue.entries {
+		data, decodeErr := base64.StdEncoding.DecodeString(entry.Data)
+		if decodeErr != nil {
+			continue
+		}
+		taken = append(taken, data)
+	}
+	outbox.dirty = true
+	return taken
+}
+
+// Status returns the state of every queue, sorted by destination
+func (outbox *Outbox) Status() []OutboxStatus {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+	statuses := []OutboxStatus{}
+	for destination, queue := range outbox.queues {
+		statuses = append(statuses, OutboxStatus{
+			Destination: destination,
+			Depth:       len(queue.entries),
+			Attempts:    queue.attempts,
+			NextAttempt: queue.nextAttempt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Destination < statuses[j].Destination
+	})
+	return statuses
+}
+
+// Load restores the packets persisted by a previous run
+func (outbox *Outbox) Load() error {
+	if outbox.path == "" {
+		return nil
+	}
+	fileData, readErr := ioutil.ReadFile(outbox.path)
+	if os.IsNotExist(readErr) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+	file := &outboxFile{}
+	if unmarshErr := xml.Unmarshal(fileData, file); unmarshErr != nil {
+		return unmarshErr
+	}
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+	for _, fileQueue := range file.Queues {
+		if len(fileQueue.Entries) == 0 {
+			continue
+		}
+		outbox.queues[fileQueue.Destination] = &outboxQueue{entries: fileQueue.Entries}
+	}
+	return nil
+}
+
+// Persist writes the queues to disk if they changed since they
+// were last written. The file is written without holding the lock,
+// so packets can be queued and delivered in the meantime.
+func (outbox *Outbox) Persist() error {
+	outbox.saveLock.Lock()
+	defer outbox.saveLock.Unlock()
+	outbox.lock.Lock()
+	if outbox.path == "" || !outbox.dirty {
+		outbox.lock.Unlock()
+		return nil
+	}
+	file := &outboxFile{}
+	for destination, queue := range outbox.queues {
+		entries := append([]*outboxEntry{}, queue.entries...)
+		file.Queues = append(file.Queues, outboxFileQueue{Destination: destination, Entries: entries})
+	}
+	outbox.dirty = false
+	outbox.lock.Unlock()
+	fileData, marshErr := xml.MarshalIndent(file, "", "    ")
+	if marshErr == nil {
+		marshErr = ioutil.WriteFile(outbox.path, fileData, 0644)
+	}
+	if marshErr != nil {
+		outbox.lock.Lock()
+		outbox.dirty = true
+		outbox.lock.Unlock()
+	}
+	return marshErr
+}
+
+// RunOutbox retries the packets in the outbox, flushing a
+// destination's queue as soon as it comes back online. It never
+// returns.
+func (handler *Handler) RunOutbox() {
+	subscription := handler.events.Subscribe(TopicRouterLiveness, TopicDeviceLiveness)
+	go func() {
+		for event := range subscription.Events() {
+			if event.Data["state"] != StateOnline.String() {
+				continue
+			}
+			switch event.Topic {
+			case TopicRouterLiveness:
+				// A router coming back may open a path to any
+				// destination, not just itself
+				handler.outbox.Wake(destinationRouter + "/")
+			case TopicDeviceLiveness:
+				handler.outbox.Wake(DeviceDestination(event.Subject))
+			}
+		}
+	}()
+	handler.outbox.Run()
+}
+
+// SendOrQueueData sends the data to the device, queueing it in the
+// outbox if the device can't be reached or is being transferred to
+// another definer. errQueued is returned when the data was queued.
+func (handler *Handler) SendOrQueueData(device *Device, data []byte) error {
+	return handler.sendOrQueueData(device, data, nil)
+}
+
+// sendOrQueueData is SendOrQueueData for a command that gives up once
+// the cancel channel is closed. Nothing is sent or queued after that
+// and errCommandTimeout is returned instead.
+func (handler *Handler) sendOrQueueData(device *Device, data []byte, cancel <-chan struct{}) error {
+	if cancelled(cancel) {
+		return errCommandTimeout
+	}
+	sendErr := errors.New("device is being transferred")
+	if !handler.transfers.Releasing(device.ID) {
+		sendErr = handler.sendToDevice(device, data)
+	}
+	if sendErr == nil {
+		return nil
+	}
+	if cancelled(cancel) {
+		return errCommandTimeout
+	}
+	Warning.Println("handler: queueing data for device " + device.ID + ": " + sendErr.Error())
+	handler.outbox.Enqueue(DeviceDestination(device.ID), data)
+	return errQueued
+}
+
+// deliverQueued makes a single delivery attempt for a packet
+// taken from the outbox
+func (handler *Handler) deliverQueued(destination string, data []byte) error {
+	kind, name := splitDestination(destination)
+	switch kind {
+	case destinationRouter:
+		packet := &packets.Packet{}
+		if unmarshErr := proto.Unmarshal(data, packet); unmarshErr != nil {
+			return unmarshErr
+		}
+		return handler.ForwardProto(packet)
+	case destinationDevice:
+		device := handler.deviceManager.GetDeviceByID(name)
+		if device == nil {
+			return errors.New("outbox: unknown device " + name)
+		}
+		return handler.sendToDevice(device, data)
+	}
+	return errors.New("outbox: unknown destination " + destination)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOutboxPersistsInBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.xml")
+	outbox := BuildOutbox(path, nil)
+	outbox.Enqueue(DeviceDestination("d1"), []byte("a"))
+	outbox.Enqueue(DeviceDestination("d1"), []byte("b"))
+	outbox.Enqueue(RouterDestination("B"), []byte("c"))
+	restored := BuildOutbox(path, nil)
+	if loadErr := restored.Load(); loadErr != nil || len(restored.Status()) != 0 {
+		t.Fatalf("queues were persisted before Persist: %v %v", restored.Status(), loadErr)
+	}
+	if persistErr := outbox.Persist(); persistErr != nil {
+		t.Fatal(persistErr)
+	}
+	delivered := [][]byte{}
+	restored = BuildOutbox(path, func(destination string, data []byte) error {
+		if destination == DeviceDestination("d1") {
+			delivered = append(delivered, data)
+		}
+		return nil
+	})
+	if loadErr := restored.Load(); loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	status := restored.Status()
+	if len(status) != 2 || status[0].Destination != "device/d1" || status[0].Depth != 2 || status[1].Depth != 1 {
+		t.Fatalf("unexpected restored queues: %v", status)
+	}
+	restored.flushQueue(DeviceDestination("d1"))
+	if len(delivered) != 2 || string(delivered[0]) != "a" {
+		t.Fatalf("unexpected restored packets: %q", delivered)
+	}
+}
+
+func TestOutboxKeepsPacketsWhenPersistFails(t *testing.T) {
+	outbox := BuildOutbox(filepath.Join(t.TempDir(), "missing", "outbox.xml"), nil)
+	outbox.Enqueue(DeviceDestination("d1"), []byte("a"))
+	if persistErr := outbox.Persist(); persistErr == nil {
+		t.Fatal("expected persisting into a missing directory to fail")
+	}
+	if status := outbox.Status(); len(status) != 1 || status[0].Depth != 1 {
+		t.Fatalf("packet should stay queued: %v", status)
+	}
+	if !outbox.dirty {
+		t.Fatal("failed write should be retried")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultPacketCacheTTL is how long a packet is remembered
+	// unless configured otherwise
+	DefaultPacketCacheTTL = 60 * time.Second
+	// DefaultPacketCacheSize is the maximum number of packets
+	// remembered unless configured otherwise
+	DefaultPacketCacheSize = 4096
+)
+
+var (
+	// PacketCacheTTL is how long a packet is remembered after
+	// it was first seen
+	PacketCacheTTL = DefaultPacketCacheTTL
+	// PacketCacheSize bounds the number of packets remembered at
+	// once. When full, the oldest packet is forgotten first.
+	PacketCacheSize = DefaultPacketCacheSize
+)
+
+// PacketCache remembers recently seen packets so that copies
+// arriving over a second route can be dropped. It is safe for
+// concurrent use.
+type PacketCache struct {
+	lock     sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[packetKey]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+// packetKey identifies a packet. The type is part of the key so
+// a response reusing its request's ID is not seen as a duplicate.
+type packetKey struct {
+	origin     string
+	id         string
+	packetType packets.Packet_Header_Type
+}
+
+type packetCacheEntry struct {
+	key     packetKey
+	expires time.Time
+}
+
+// BuildPacketCache returns an empty PacketCache that remembers
+// up to capacity packets for the given duration
+func BuildPacketCache(ttl time.Duration, capacity int) *PacketCache {
+	return &PacketCache{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  map[packetKey]*list.Element{},
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Seen records the packet and reports whether it had already been
+// recorded. Packets without an ID can't be told apart and are never
+// reported as seen.
+func (cache *PacketCache) Seen(packet *packets.Packet) bool {
+	header := packet.GetHeader()
+	if header == nil || header.Id == "" {
+		return false
+	}
+	key := packetKey{origin: header.Origin, id: header.Id, packetType: header.Type}
+	cache.lock.Lock()
+	now := cache.now()
+	defer cache.lock.Unlock()
+	cache.expire(now)
+	if _, ok := cache.entries[key]; ok {
+		return true
+	}
+	cache.entries[key] = cache.order.PushBack(&packetCacheEntry{key: key, expires: now.Add(cache.ttl)})
+	for cache.capacity > 0 && cache.order.Len() > cache.capacity {
+		cache.remove(cache.order.Front())
+	}
+	return false
+}
+
+// Len returns the number of packets currently remembered
+func (cache *PacketCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.expire(cache.now())
+	return cache.order.Len()
+}
+
+// expire drops every entry that has outlived the TTL. Entries are
+// kept in insertion order so only the front needs to be checked.
+func (cache *PacketCache) expire(now time.Time) {
+	for element := cache.order.Front(); element != nil; element = cache.order.Front() {
+		if now.Before(element.Value.(*packetCacheEntry).expires) {
+			return
+		}
+		cache.remove(element)
+	}
+}
+
+func (cache *PacketCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*packetCacheEntry)
+	delete(cache.entries, entry.key)
+}
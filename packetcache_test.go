@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// cachedPacket returns a packet with just the parts of the header
+// the cache keys on
+func cachedPacket(origin string, id string, packetType packets.Packet_Header_Type) *packets.Packet {
+	return &packets.Packet{Header: &packets.Packet_Header{Origin: origin, Id: id, Type: packetType}}
+}
+
+func TestPacketCacheKey(t *testing.T) {
+	cache := BuildPacketCache(time.Minute, 16)
+	if cache.Seen(cachedPacket("A", "1", packets.Packet_Header_REQUEST)) {
+		t.Fatal("a new packet was seen")
+	}
+	if !cache.Seen(cachedPacket("A", "1", packets.Packet_Header_REQUEST)) {
+		t.Fatal("a repeated packet wasn't seen")
+	}
+	others := []*packets.Packet{
+		cachedPacket("B", "1", packets.Packet_Header_REQUEST),
+		cachedPacket("A", "2", packets.Packet_Header_REQUEST),
+		cachedPacket("A", "1", packets.Packet_Header_RESPONSE),
+	}
+	for _, packet := range others {
+		if cache.Seen(packet) {
+			t.Errorf("packet %v was mistaken for another", packet.GetHeader())
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if cache.Seen(cachedPacket("A", "", packets.Packet_Header_PASSIVE)) {
+			t.Fatal("a packet without an ID was seen")
+		}
+	}
+}
+
+func TestPacketCacheExpires(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	cache := BuildPacketCache(time.Minute, 16)
+	cache.now = func() time.Time { return now }
+	cache.Seen(cachedPacket("A", "1", packets.Packet_Header_PASSIVE))
+	now = now.Add(30 * time.Second)
+	cache.Seen(cachedPacket("A", "2", packets.Packet_Header_PASSIVE))
+	now = now.Add(30 * time.Second)
+	if length := cache.Len(); length != 1 {
+		t.Fatalf("expected only the second packet to be remembered, got %d", length)
+	}
+	if cache.Seen(cachedPacket("A", "1", packets.Packet_Header_PASSIVE)) {
+		t.Fatal("an expired packet was seen")
+	}
+	if !cache.Seen(cachedPacket("A", "2", packets.Packet_Header_PASSIVE)) {
+		t.Fatal("a packet still within its TTL wasn't seen")
+	}
+}
+
+func TestPacketCacheEvictsOldest(t *testing.T) {
+	cache := BuildPacketCache(time.Minute, 2)
+	for _, id := range []string{"1", "2", "3"} {
+		cache.Seen(cachedPacket("A", id, packets.Packet_Header_PASSIVE))
+	}
+	if length := cache.Len(); length != 2 {
+		t.Fatalf("expected 2 packets to be remembered, got %d", length)
+	}
+	if cache.Seen(cachedPacket("A", "1", packets.Packet_Header_PASSIVE)) {
+		t.Fatal("the oldest packet should have been forgotten")
+	}
+	if !cache.Seen(cachedPacket("A", "3", packets.Packet_Header_PASSIVE)) {
+		t.Fatal("the newest packet should be remembered")
+	}
+}
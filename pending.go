@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultRequestTimeout is how long SendRequest waits for a
+	// response unless told otherwise
+	DefaultRequestTimeout = 10 * time.Second
+)
+
+// PendingRequests tracks the requests sent by the current definer
+// that are still waiting for a response, keyed by packet ID. It is
+// safe for concurrent use.
+type PendingRequests struct {
+	lock     sync.Mutex
+	requests map[string]chan *packets.Packet
+}
+
+// localWriter feeds the packets written to it straight back into
+// the handler. It stands in for the connection when the current
+// definer sends a request to itself.
+type localWriter struct {
+	handler *Handler
+}
+
+// BuildPendingRequests returns an empty PendingRequests table
+func BuildPendingRequests() *PendingRequests {
+	return &PendingRequests{requests: map[string]chan *packets.Packet{}}
+}
+
+// Add registers a request and returns the channel its
+// response will be delivered on
+func (pending *PendingRequests) Add(id string) <-chan *packets.Packet {
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+	responses := make(chan *packets.Packet, 1)
+	pending.requests[id] = responses
+	return responses
+}
+
+// Remove stops waiting for a response to the request
+func (pending *PendingRequests) Remove(id string) {
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+	delete(pending.requests, id)
+}
+
+// Resolve delivers the response to the request waiting on it.
+// It reports whether anything was waiting.
+func (pending *PendingRequests) Resolve(response *packets.Packet) bool {
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+	responses, ok := pending.requests[response.GetHeader().Id]
+	if !ok {
+		return false
+	}
+	delete(pending.requests, response.GetHeader().Id)
+	responses <- response
+	return true
+}
+
+// Len returns the number of requests still waiting on a response
+func (pending *PendingRequests) Len() int {
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+	return len(pending.requests)
+}
+
+// SendRequest sends the request towards its destination and blocks
+// until the matching response arrives or the timeout passes. A
+// GeneralErrorResponse is returned as an error along with the packet.
+func (handler *Handler) SendRequest(packet *packets.Packet, timeout time.Duration) (*packets.Packet, error) {
+	header := packet.GetHeader()
+	if header == nil {
+		header = &packets.Packet_Header{}
+		packet.Header = header
+	}
+	if header.Id == "" {
+		header.Id = NewPacketID()
+	}
+	header.Origin = handler.router.GetName()
+	header.Type = packets.Packet_Header_REQUEST
+	return handler.awaitResponse(packet, timeout, handler.SendProto)
+}
+
+// SendRequestToRouter is like SendRequest but writes the request
+// directly to the given router instead of routing it.
+func (handler *Handler) SendRequestToRouter(peer *Router, packet *packets.Packet, timeout time.Duration) (*packets.Packet, error) {
+	return handler.awaitResponse(packet, timeout, func(packet *packets.Packet) error {
+		hostname, port := peer.GetAddress()
+		return handler.WriteProtoToDest(hostname, port, packet)
+	})
+}
+
+// awaitResponse registers the request as pending, sends it and
+// waits for its response.
+func (handler *Handler) awaitResponse(packet *packets.Packet, timeout time.Duration, send func(*packets.Packet) error) (*packets.Packet, error) {
+	header := packet.GetHeader()
+	responses := handler.pendingRequests.Add(header.Id)
+	defer handler.pendingRequests.Remove(header.Id)
+	if sendErr := send(packet); sendErr != nil {
+		return nil, sendErr
+	}
+	select {
+	case response := <-responses:
+		if errorResponse := response.GetErrorResponse(); errorResponse != nil {
+			return response, errors.New(errorResponse.ErrorMessage)
+		}
+		return response, nil
+	case <-time.After(timeout):
+		return nil, errors.New("handler: timed out waiting for a response to packet #" + header.Id)
+	}
+}
+
+// SendProto sends a packet originating from the current definer.
+// Packets addressed to the current definer are handled locally and
+// those that can't be delivered yet are queued in the outbox.
+func (handler *Handler) SendProto(packet *packets.Packet) error {
+	destination := packet.GetHeader().Destination
+	if destination == "" || destination == handler.router.GetName() {
+		return handler.Handle(packet, &localWriter{handler: handler})
+	}
+	return handler.ForwardOrQueueProto(packet)
+}
+
+// Write decodes the framed packet and hands it back to the handler
+func (writer *localWriter) Write(data []byte) (int, error) {
+	protoData, frameErr := ReadFrame(bytes.NewReader(data))
+	if frameErr != nil {
+		return 0, frameErr
+	}
+	packet := &packets.Packet{}
+	if unmarshErr := proto.Unmarshal(protoData, packet); unmarshErr != nil {
+		return 0, unmarshErr
+	}
+	if handleErr := writer.handler.Handle(packet, writer); handleErr != nil {
+		return 0, handleErr
+	}
+	return len(data), nil
+}
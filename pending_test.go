@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// pingResponse returns the response from the origin to the request
+func pingResponse(request *packets.Packet, origin string) *packets.Packet {
+	return &packets.Packet{
+		Header: &packets.Packet_Header{Origin: origin, Destination: request.GetHeader().Origin, Id: request.GetHeader().Id, Type: packets.Packet_Header_RESPONSE},
+		Body:   &packets.Packet_PingResponse{PingResponse: &packets.PingResponse{}},
+	}
+}
+
+func TestRequestTimesOut(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	request := pingPacket("A", "B")
+	if _, requestErr := handler.SendRequest(request, 20*time.Millisecond); requestErr == nil {
+		t.Fatal("expected a request nobody answers to time out")
+	}
+	if pending := handler.pendingRequests.Len(); pending != 0 {
+		t.Fatalf("timed out request is still pending: %d", pending)
+	}
+	if handleErr := handler.Handle(pingResponse(request, "B"), ioutil.Discard); handleErr == nil {
+		t.Fatal("expected a late response to be refused")
+	}
+}
+
+func TestResponseResolvesOnce(t *testing.T) {
+	pending := BuildPendingRequests()
+	request := pingPacket("A", "B")
+	responses := pending.Add(request.GetHeader().Id)
+	if !pending.Resolve(pingResponse(request, "B")) {
+		t.Fatal("expected the response to resolve the request")
+	}
+	if pending.Resolve(pingResponse(request, "B")) {
+		t.Fatal("a duplicate response resolved the request again")
+	}
+	if response := <-responses; response.GetHeader().Origin != "B" {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if length := pending.Len(); length != 0 {
+		t.Fatalf("resolved request is still pending: %d", length)
+	}
+}
+
+func TestRequestToSelf(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	for _, destination := range []string{"", "A"} {
+		request := pingPacket("", destination)
+		request.GetPingReq().Timestamp = 42
+		response, requestErr := handler.SendRequest(request, time.Second)
+		if requestErr != nil {
+			t.Fatal(requestErr)
+		}
+		if response.GetPingResponse() == nil || response.GetPingResponse().Timestamp != 42 || response.GetHeader().Id != request.GetHeader().Id {
+			t.Fatalf("unexpected response: %v", response)
+		}
+	}
+	if pending := handler.pendingRequests.Len(); pending != 0 {
+		t.Fatalf("answered requests are still pending: %d", pending)
+	}
+}
@@ -14,9 +14,30 @@ It has these top-level messages:
 	Execute
 	Packet
 	GeneralErrorResponse
+	GeneralSuccessResponse
 	IntroductionPassive
 	RouterConfigurationRequest
 	DeviceTransferPassive
+	RouteAdvertisementPassive
+	PingRequest
+	PingResponse
+	DeviceRegistrationRequest
+	DeviceRegistrationResponse
+	DeviceTransferRequest
+	DeviceTransferResponse
+	CommandResponse
+	DeviceResponse
+	DeviceStatePassive
+	DeviceStateRequest
+	DeviceStateResponse
+	SubscribeRequest
+	UnsubscribeRequest
+	SubscribeResponse
+	EventPassive
+	ScheduleRequest
+	ScheduleResponse
+	SceneRequest
+	SceneResponse
 */
 package packets
 
@@ -35,8 +56,45 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+// Priority orders the commands waiting to be sent to a device.
+// Safety commands go ahead of the rest and aren't held back by
+// the device's rate limit, bulk ones wait for everything else.
+type Command_Priority int32
+
+const (
+	Command_NORMAL Command_Priority = 0
+	Command_SAFETY Command_Priority = 1
+	Command_BULK   Command_Priority = 2
+)
+
+var Command_Priority_name = map[int32]string{
+	0: "NORMAL",
+	1: "SAFETY",
+	2: "BULK",
+}
+var Command_Priority_value = map[string]int32{
+	"NORMAL": 0,
+	"SAFETY": 1,
+	"BULK":   2,
+}
+
+func (x Command_Priority) String() string {
+	return proto.EnumName(Command_Priority_name, int32(x))
+}
+func (Command_Priority) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0, 0} }
+
 type Command struct {
 	Device *Command_Device `protobuf:"bytes,1,opt,name=device" json:"device,omitempty"`
+	// timeout is how long, in milliseconds, every target device has
+	// to accept the command. Zero uses the definer's default.
+	Timeout uint32 `protobuf:"varint,2,opt,name=timeout" json:"timeout,omitempty"`
+	// allOrNothing only sends the command if every target device
+	// can be reached, instead of queueing it for the ones that can't.
+	AllOrNothing bool             `protobuf:"varint,3,opt,name=allOrNothing" json:"allOrNothing,omitempty"`
+	Priority     Command_Priority `protobuf:"varint,4,opt,name=priority,enum=packets.Command_Priority" json:"priority,omitempty"`
+	// coalesce replaces any command with the same Execute core still
+	// waiting to be sent to a device, such as an earlier brightness.
+	Coalesce bool `protobuf:"varint,5,opt,name=coalesce" json:"coalesce,omitempty"`
 	// Types that are valid to be assigned to Body:
 	//	*Command_Execute
 	Body isCommand_Body `protobuf_oneof:"body"`
@@ -133,9 +191,16 @@ func _Command_OneofSizer(msg proto.Message) (n int) {
 	return n
 }
 
+// Device picks out the devices the command is sent to by type,
+// group, room or a list of device IDs. Each part that is given
+// narrows the devices down further, so a room and a core pick
+// out the devices of that type in the room.
 type Command_Device struct {
-	Core     string `protobuf:"bytes,1,opt,name=core" json:"core,omitempty"`
-	Modifier string `protobuf:"bytes,2,opt,name=modifier" json:"modifier,omitempty"`
+	Core     string   `protobuf:"bytes,1,opt,name=core" json:"core,omitempty"`
+	Modifier string   `protobuf:"bytes,2,opt,name=modifier" json:"modifier,omitempty"`
+	Group    string   `protobuf:"bytes,3,opt,name=group" json:"group,omitempty"`
+	Room     string   `protobuf:"bytes,4,opt,name=room" json:"room,omitempty"`
+	Devices  []string `protobuf:"bytes,5,rep,name=devices" json:"devices,omitempty"`
 }
 
 func (m *Command_Device) Reset()                    { *m = Command_Device{} }
@@ -157,23 +222,33 @@ func init() {
 	proto.RegisterType((*Command)(nil), "packets.Command")
 	proto.RegisterType((*Command_Device)(nil), "packets.Command.Device")
 	proto.RegisterType((*Execute)(nil), "packets.Execute")
+	proto.RegisterEnum("packets.Command_Priority", Command_Priority_name, Command_Priority_value)
 }
 
 func init() { proto.RegisterFile("commands.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 194 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x4b, 0xce, 0xcf, 0xcd,
-	0x4d, 0xcc, 0x4b, 0x29, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2f, 0x48, 0x4c, 0xce,
-	0x4e, 0x2d, 0x29, 0x56, 0x5a, 0xc5, 0xc8, 0xc5, 0xee, 0x0c, 0x91, 0x13, 0xd2, 0xe7, 0x62, 0x4b,
-	0x49, 0x2d, 0xcb, 0x4c, 0x4e, 0x95, 0x60, 0x54, 0x60, 0xd4, 0xe0, 0x36, 0x12, 0xd7, 0x83, 0xaa,
-	0xd2, 0x83, 0xaa, 0xd0, 0x73, 0x01, 0x4b, 0x07, 0x41, 0x95, 0x09, 0xe9, 0x70, 0xb1, 0xa7, 0x56,
-	0xa4, 0x26, 0x97, 0x96, 0xa4, 0x4a, 0x70, 0x83, 0x75, 0x08, 0xc0, 0x75, 0xb8, 0x42, 0xc4, 0x3d,
-	0x18, 0x82, 0x60, 0x4a, 0xa4, 0x2c, 0xb8, 0xd8, 0x20, 0xfa, 0x85, 0x84, 0xb8, 0x58, 0x92, 0xf3,
-	0x8b, 0x20, 0xd6, 0x70, 0x06, 0x81, 0xd9, 0x42, 0x52, 0x5c, 0x1c, 0xb9, 0xf9, 0x29, 0x99, 0x69,
-	0x99, 0xa9, 0x45, 0x12, 0x4c, 0x60, 0x71, 0x38, 0xdf, 0x89, 0x8d, 0x8b, 0x25, 0x29, 0x3f, 0xa5,
-	0x52, 0xc9, 0x96, 0x8b, 0x1d, 0x6a, 0x2e, 0x56, 0x23, 0xe4, 0xb8, 0xb8, 0x0a, 0x12, 0x8b, 0x12,
-	0x73, 0x53, 0x4b, 0x52, 0x8b, 0x8a, 0x25, 0x98, 0x14, 0x98, 0x35, 0x38, 0x83, 0x90, 0x44, 0x92,
-	0xd8, 0xc0, 0x7e, 0x37, 0x06, 0x04, 0x00, 0x00, 0xff, 0xff, 0xb7, 0xe6, 0xad, 0xc6, 0x0d, 0x01,
-	0x00, 0x00,
+	// 341 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x6c, 0x91, 0x4d, 0x4b, 0xfb, 0x40,
+	0x10, 0xc6, 0x9b, 0x36, 0xcd, 0xcb, 0xf4, 0xff, 0x2f, 0x61, 0x11, 0x5c, 0x7b, 0x90, 0x90, 0x53,
+	0x0e, 0x25, 0x42, 0xc5, 0xa3, 0x87, 0x56, 0x2b, 0x82, 0xb5, 0x95, 0x55, 0x0f, 0x1e, 0xd3, 0xcd,
+	0x5a, 0x17, 0x9b, 0x6e, 0xd8, 0x6c, 0xc4, 0x82, 0x5f, 0xc9, 0xef, 0x28, 0xd9, 0xbc, 0xa0, 0xe8,
+	0x6d, 0x9e, 0x99, 0x87, 0x79, 0xf6, 0x37, 0x0b, 0x43, 0x2a, 0xd2, 0x34, 0xde, 0x25, 0x79, 0x94,
+	0x49, 0xa1, 0x04, 0xb2, 0xb3, 0x98, 0xbe, 0x32, 0x95, 0x07, 0x9f, 0x3d, 0xb0, 0x2f, 0xaa, 0x19,
+	0x3a, 0x01, 0x2b, 0x61, 0x6f, 0x9c, 0x32, 0x6c, 0xf8, 0x46, 0x38, 0x98, 0x1c, 0x46, 0xb5, 0x2b,
+	0xaa, 0x1d, 0xd1, 0xa5, 0x1e, 0x93, 0xda, 0x86, 0x30, 0xd8, 0x8a, 0xa7, 0x4c, 0x14, 0x0a, 0x77,
+	0x7d, 0x23, 0xfc, 0x4f, 0x1a, 0x89, 0x02, 0xf8, 0x17, 0x6f, 0xb7, 0x2b, 0xb9, 0x14, 0xea, 0x85,
+	0xef, 0x36, 0xb8, 0xe7, 0x1b, 0xa1, 0x43, 0x7e, 0xf4, 0xd0, 0x19, 0x38, 0x99, 0xe4, 0x42, 0x72,
+	0xb5, 0xc7, 0xa6, 0x6f, 0x84, 0xc3, 0xc9, 0xd1, 0xaf, 0xc0, 0xbb, 0xda, 0x40, 0x5a, 0x2b, 0x1a,
+	0x81, 0x43, 0x45, 0xbc, 0x65, 0x39, 0x65, 0xb8, 0xaf, 0xd7, 0xb6, 0x1a, 0x8d, 0xc1, 0x66, 0xef,
+	0x8c, 0x16, 0x8a, 0xe1, 0x81, 0x46, 0xf0, 0xda, 0x8d, 0xf3, 0xaa, 0x7f, 0xdd, 0x21, 0x8d, 0x65,
+	0xf4, 0x01, 0x56, 0x05, 0x84, 0x10, 0x98, 0x54, 0xc8, 0x8a, 0xdb, 0x25, 0xba, 0x2e, 0x73, 0x52,
+	0x91, 0xf0, 0x67, 0xce, 0xa4, 0xa6, 0x73, 0x49, 0xab, 0xd1, 0x01, 0xf4, 0x37, 0x52, 0x14, 0x99,
+	0xe6, 0x72, 0x49, 0x25, 0xca, 0x2d, 0x52, 0x88, 0x54, 0xc3, 0xb8, 0x44, 0xd7, 0xe5, 0x89, 0xaa,
+	0x63, 0xe5, 0xb8, 0xef, 0xf7, 0x42, 0x97, 0x34, 0x32, 0x18, 0x83, 0xd3, 0xd0, 0x21, 0x00, 0x6b,
+	0xb9, 0x22, 0xb7, 0xd3, 0x85, 0xd7, 0x29, 0xeb, 0xfb, 0xe9, 0xd5, 0xfc, 0xe1, 0xc9, 0x33, 0x90,
+	0x03, 0xe6, 0xec, 0x71, 0x71, 0xe3, 0x75, 0x67, 0x16, 0x98, 0x6b, 0x91, 0xec, 0x83, 0x73, 0xb0,
+	0x6b, 0x92, 0x3f, 0x1f, 0x7d, 0x0c, 0x90, 0xc5, 0x32, 0x4e, 0x99, 0x62, 0x32, 0xc7, 0x5d, 0x9d,
+	0xf8, 0xad, 0xb3, 0xb6, 0xf4, 0xf7, 0x9f, 0x7e, 0x05, 0x00, 0x00, 0xff, 0xff, 0xe9, 0x84, 0x9b,
+	0x54, 0x10, 0x02, 0x00, 0x00,
 }
@@ -42,8 +42,29 @@ type Packet struct {
 	// Types that are valid to be assigned to Body:
 	//	*Packet_Intro
 	//	*Packet_RouterConfigReq
+	//	*Packet_SuccessResponse
 	//	*Packet_ErrorResponse
 	//	*Packet_DeviceTransfer
+	//	*Packet_RouteAdvertisement
+	//	*Packet_PingReq
+	//	*Packet_PingResponse
+	//	*Packet_DeviceRegistrationReq
+	//	*Packet_DeviceRegistrationResponse
+	//	*Packet_DeviceTransferReq
+	//	*Packet_DeviceTransferResponse
+	//	*Packet_CommandResponse
+	//	*Packet_DeviceResponse
+	//	*Packet_DeviceState
+	//	*Packet_DeviceStateReq
+	//	*Packet_DeviceStateResponse
+	//	*Packet_SubscribeReq
+	//	*Packet_UnsubscribeReq
+	//	*Packet_SubscribeResponse
+	//	*Packet_Event
+	//	*Packet_ScheduleReq
+	//	*Packet_ScheduleResponse
+	//	*Packet_SceneReq
+	//	*Packet_SceneResponse
 	//	*Packet_Command
 	Body isPacket_Body `protobuf_oneof:"body"`
 }
@@ -63,21 +84,105 @@ type Packet_Intro struct {
 type Packet_RouterConfigReq struct {
 	RouterConfigReq *RouterConfigurationRequest `protobuf:"bytes,3,opt,name=routerConfigReq,oneof"`
 }
+type Packet_SuccessResponse struct {
+	SuccessResponse *GeneralSuccessResponse `protobuf:"bytes,4,opt,name=successResponse,oneof"`
+}
 type Packet_ErrorResponse struct {
 	ErrorResponse *GeneralErrorResponse `protobuf:"bytes,5,opt,name=errorResponse,oneof"`
 }
 type Packet_DeviceTransfer struct {
 	DeviceTransfer *DeviceTransferPassive `protobuf:"bytes,6,opt,name=deviceTransfer,oneof"`
 }
+type Packet_RouteAdvertisement struct {
+	RouteAdvertisement *RouteAdvertisementPassive `protobuf:"bytes,7,opt,name=routeAdvertisement,oneof"`
+}
+type Packet_PingReq struct {
+	PingReq *PingRequest `protobuf:"bytes,8,opt,name=pingReq,oneof"`
+}
+type Packet_PingResponse struct {
+	PingResponse *PingResponse `protobuf:"bytes,9,opt,name=pingResponse,oneof"`
+}
+type Packet_DeviceRegistrationReq struct {
+	DeviceRegistrationReq *DeviceRegistrationRequest `protobuf:"bytes,10,opt,name=deviceRegistrationReq,oneof"`
+}
+type Packet_DeviceRegistrationResponse struct {
+	DeviceRegistrationResponse *DeviceRegistrationResponse `protobuf:"bytes,11,opt,name=deviceRegistrationResponse,oneof"`
+}
+type Packet_DeviceTransferReq struct {
+	DeviceTransferReq *DeviceTransferRequest `protobuf:"bytes,12,opt,name=deviceTransferReq,oneof"`
+}
+type Packet_DeviceTransferResponse struct {
+	DeviceTransferResponse *DeviceTransferResponse `protobuf:"bytes,13,opt,name=deviceTransferResponse,oneof"`
+}
+type Packet_CommandResponse struct {
+	CommandResponse *CommandResponse `protobuf:"bytes,14,opt,name=commandResponse,oneof"`
+}
+type Packet_DeviceResponse struct {
+	DeviceResponse *DeviceResponse `protobuf:"bytes,15,opt,name=deviceResponse,oneof"`
+}
+type Packet_DeviceState struct {
+	DeviceState *DeviceStatePassive `protobuf:"bytes,16,opt,name=deviceState,oneof"`
+}
+type Packet_DeviceStateReq struct {
+	DeviceStateReq *DeviceStateRequest `protobuf:"bytes,17,opt,name=deviceStateReq,oneof"`
+}
+type Packet_DeviceStateResponse struct {
+	DeviceStateResponse *DeviceStateResponse `protobuf:"bytes,18,opt,name=deviceStateResponse,oneof"`
+}
+type Packet_SubscribeReq struct {
+	SubscribeReq *SubscribeRequest `protobuf:"bytes,19,opt,name=subscribeReq,oneof"`
+}
+type Packet_UnsubscribeReq struct {
+	UnsubscribeReq *UnsubscribeRequest `protobuf:"bytes,20,opt,name=unsubscribeReq,oneof"`
+}
+type Packet_SubscribeResponse struct {
+	SubscribeResponse *SubscribeResponse `protobuf:"bytes,21,opt,name=subscribeResponse,oneof"`
+}
+type Packet_Event struct {
+	Event *EventPassive `protobuf:"bytes,22,opt,name=event,oneof"`
+}
+type Packet_ScheduleReq struct {
+	ScheduleReq *ScheduleRequest `protobuf:"bytes,23,opt,name=scheduleReq,oneof"`
+}
+type Packet_ScheduleResponse struct {
+	ScheduleResponse *ScheduleResponse `protobuf:"bytes,24,opt,name=scheduleResponse,oneof"`
+}
+type Packet_SceneReq struct {
+	SceneReq *SceneRequest `protobuf:"bytes,25,opt,name=sceneReq,oneof"`
+}
+type Packet_SceneResponse struct {
+	SceneResponse *SceneResponse `protobuf:"bytes,26,opt,name=sceneResponse,oneof"`
+}
 type Packet_Command struct {
 	Command *Command `protobuf:"bytes,99,opt,name=command,oneof"`
 }
 
-func (*Packet_Intro) isPacket_Body()           {}
-func (*Packet_RouterConfigReq) isPacket_Body() {}
-func (*Packet_ErrorResponse) isPacket_Body()   {}
-func (*Packet_DeviceTransfer) isPacket_Body()  {}
-func (*Packet_Command) isPacket_Body()         {}
+func (*Packet_Intro) isPacket_Body()                      {}
+func (*Packet_RouterConfigReq) isPacket_Body()            {}
+func (*Packet_SuccessResponse) isPacket_Body()            {}
+func (*Packet_ErrorResponse) isPacket_Body()              {}
+func (*Packet_DeviceTransfer) isPacket_Body()             {}
+func (*Packet_RouteAdvertisement) isPacket_Body()         {}
+func (*Packet_PingReq) isPacket_Body()                    {}
+func (*Packet_PingResponse) isPacket_Body()               {}
+func (*Packet_DeviceRegistrationReq) isPacket_Body()      {}
+func (*Packet_DeviceRegistrationResponse) isPacket_Body() {}
+func (*Packet_DeviceTransferReq) isPacket_Body()          {}
+func (*Packet_DeviceTransferResponse) isPacket_Body()     {}
+func (*Packet_CommandResponse) isPacket_Body()            {}
+func (*Packet_DeviceResponse) isPacket_Body()             {}
+func (*Packet_DeviceState) isPacket_Body()                {}
+func (*Packet_DeviceStateReq) isPacket_Body()             {}
+func (*Packet_DeviceStateResponse) isPacket_Body()        {}
+func (*Packet_SubscribeReq) isPacket_Body()               {}
+func (*Packet_UnsubscribeReq) isPacket_Body()             {}
+func (*Packet_SubscribeResponse) isPacket_Body()          {}
+func (*Packet_Event) isPacket_Body()                      {}
+func (*Packet_ScheduleReq) isPacket_Body()                {}
+func (*Packet_ScheduleResponse) isPacket_Body()           {}
+func (*Packet_SceneReq) isPacket_Body()                   {}
+func (*Packet_SceneResponse) isPacket_Body()              {}
+func (*Packet_Command) isPacket_Body()                    {}
 
 func (m *Packet) GetBody() isPacket_Body {
 	if m != nil {
@@ -107,6 +212,13 @@ func (m *Packet) GetRouterConfigReq() *RouterConfigurationRequest {
 	return nil
 }
 
+func (m *Packet) GetSuccessResponse() *GeneralSuccessResponse {
+	if x, ok := m.GetBody().(*Packet_SuccessResponse); ok {
+		return x.SuccessResponse
+	}
+	return nil
+}
+
 func (m *Packet) GetErrorResponse() *GeneralErrorResponse {
 	if x, ok := m.GetBody().(*Packet_ErrorResponse); ok {
 		return x.ErrorResponse
@@ -121,6 +233,146 @@ func (m *Packet) GetDeviceTransfer() *DeviceTransferPassive {
 	return nil
 }
 
+func (m *Packet) GetRouteAdvertisement() *RouteAdvertisementPassive {
+	if x, ok := m.GetBody().(*Packet_RouteAdvertisement); ok {
+		return x.RouteAdvertisement
+	}
+	return nil
+}
+
+func (m *Packet) GetPingReq() *PingRequest {
+	if x, ok := m.GetBody().(*Packet_PingReq); ok {
+		return x.PingReq
+	}
+	return nil
+}
+
+func (m *Packet) GetPingResponse() *PingResponse {
+	if x, ok := m.GetBody().(*Packet_PingResponse); ok {
+		return x.PingResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceRegistrationReq() *DeviceRegistrationRequest {
+	if x, ok := m.GetBody().(*Packet_DeviceRegistrationReq); ok {
+		return x.DeviceRegistrationReq
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceRegistrationResponse() *DeviceRegistrationResponse {
+	if x, ok := m.GetBody().(*Packet_DeviceRegistrationResponse); ok {
+		return x.DeviceRegistrationResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceTransferReq() *DeviceTransferRequest {
+	if x, ok := m.GetBody().(*Packet_DeviceTransferReq); ok {
+		return x.DeviceTransferReq
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceTransferResponse() *DeviceTransferResponse {
+	if x, ok := m.GetBody().(*Packet_DeviceTransferResponse); ok {
+		return x.DeviceTransferResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetCommandResponse() *CommandResponse {
+	if x, ok := m.GetBody().(*Packet_CommandResponse); ok {
+		return x.CommandResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceResponse() *DeviceResponse {
+	if x, ok := m.GetBody().(*Packet_DeviceResponse); ok {
+		return x.DeviceResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceState() *DeviceStatePassive {
+	if x, ok := m.GetBody().(*Packet_DeviceState); ok {
+		return x.DeviceState
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceStateReq() *DeviceStateRequest {
+	if x, ok := m.GetBody().(*Packet_DeviceStateReq); ok {
+		return x.DeviceStateReq
+	}
+	return nil
+}
+
+func (m *Packet) GetDeviceStateResponse() *DeviceStateResponse {
+	if x, ok := m.GetBody().(*Packet_DeviceStateResponse); ok {
+		return x.DeviceStateResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetSubscribeReq() *SubscribeRequest {
+	if x, ok := m.GetBody().(*Packet_SubscribeReq); ok {
+		return x.SubscribeReq
+	}
+	return nil
+}
+
+func (m *Packet) GetUnsubscribeReq() *UnsubscribeRequest {
+	if x, ok := m.GetBody().(*Packet_UnsubscribeReq); ok {
+		return x.UnsubscribeReq
+	}
+	return nil
+}
+
+func (m *Packet) GetSubscribeResponse() *SubscribeResponse {
+	if x, ok := m.GetBody().(*Packet_SubscribeResponse); ok {
+		return x.SubscribeResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetEvent() *EventPassive {
+	if x, ok := m.GetBody().(*Packet_Event); ok {
+		return x.Event
+	}
+	return nil
+}
+
+func (m *Packet) GetScheduleReq() *ScheduleRequest {
+	if x, ok := m.GetBody().(*Packet_ScheduleReq); ok {
+		return x.ScheduleReq
+	}
+	return nil
+}
+
+func (m *Packet) GetScheduleResponse() *ScheduleResponse {
+	if x, ok := m.GetBody().(*Packet_ScheduleResponse); ok {
+		return x.ScheduleResponse
+	}
+	return nil
+}
+
+func (m *Packet) GetSceneReq() *SceneRequest {
+	if x, ok := m.GetBody().(*Packet_SceneReq); ok {
+		return x.SceneReq
+	}
+	return nil
+}
+
+func (m *Packet) GetSceneResponse() *SceneResponse {
+	if x, ok := m.GetBody().(*Packet_SceneResponse); ok {
+		return x.SceneResponse
+	}
+	return nil
+}
+
 func (m *Packet) GetCommand() *Command {
 	if x, ok := m.GetBody().(*Packet_Command); ok {
 		return x.Command
@@ -133,8 +385,29 @@ func (*Packet) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error,
 	return _Packet_OneofMarshaler, _Packet_OneofUnmarshaler, _Packet_OneofSizer, []interface{}{
 		(*Packet_Intro)(nil),
 		(*Packet_RouterConfigReq)(nil),
+		(*Packet_SuccessResponse)(nil),
 		(*Packet_ErrorResponse)(nil),
 		(*Packet_DeviceTransfer)(nil),
+		(*Packet_RouteAdvertisement)(nil),
+		(*Packet_PingReq)(nil),
+		(*Packet_PingResponse)(nil),
+		(*Packet_DeviceRegistrationReq)(nil),
+		(*Packet_DeviceRegistrationResponse)(nil),
+		(*Packet_DeviceTransferReq)(nil),
+		(*Packet_DeviceTransferResponse)(nil),
+		(*Packet_CommandResponse)(nil),
+		(*Packet_DeviceResponse)(nil),
+		(*Packet_DeviceState)(nil),
+		(*Packet_DeviceStateReq)(nil),
+		(*Packet_DeviceStateResponse)(nil),
+		(*Packet_SubscribeReq)(nil),
+		(*Packet_UnsubscribeReq)(nil),
+		(*Packet_SubscribeResponse)(nil),
+		(*Packet_Event)(nil),
+		(*Packet_ScheduleReq)(nil),
+		(*Packet_ScheduleResponse)(nil),
+		(*Packet_SceneReq)(nil),
+		(*Packet_SceneResponse)(nil),
 		(*Packet_Command)(nil),
 	}
 }
@@ -153,6 +426,11 @@ func _Packet_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.RouterConfigReq); err != nil {
 			return err
 		}
+	case *Packet_SuccessResponse:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SuccessResponse); err != nil {
+			return err
+		}
 	case *Packet_ErrorResponse:
 		b.EncodeVarint(5<<3 | proto.WireBytes)
 		if err := b.EncodeMessage(x.ErrorResponse); err != nil {
@@ -163,6 +441,106 @@ func _Packet_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.DeviceTransfer); err != nil {
 			return err
 		}
+	case *Packet_RouteAdvertisement:
+		b.EncodeVarint(7<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RouteAdvertisement); err != nil {
+			return err
+		}
+	case *Packet_PingReq:
+		b.EncodeVarint(8<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.PingReq); err != nil {
+			return err
+		}
+	case *Packet_PingResponse:
+		b.EncodeVarint(9<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.PingResponse); err != nil {
+			return err
+		}
+	case *Packet_DeviceRegistrationReq:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceRegistrationReq); err != nil {
+			return err
+		}
+	case *Packet_DeviceRegistrationResponse:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceRegistrationResponse); err != nil {
+			return err
+		}
+	case *Packet_DeviceTransferReq:
+		b.EncodeVarint(12<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceTransferReq); err != nil {
+			return err
+		}
+	case *Packet_DeviceTransferResponse:
+		b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceTransferResponse); err != nil {
+			return err
+		}
+	case *Packet_CommandResponse:
+		b.EncodeVarint(14<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CommandResponse); err != nil {
+			return err
+		}
+	case *Packet_DeviceResponse:
+		b.EncodeVarint(15<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceResponse); err != nil {
+			return err
+		}
+	case *Packet_DeviceState:
+		b.EncodeVarint(16<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceState); err != nil {
+			return err
+		}
+	case *Packet_DeviceStateReq:
+		b.EncodeVarint(17<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceStateReq); err != nil {
+			return err
+		}
+	case *Packet_DeviceStateResponse:
+		b.EncodeVarint(18<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.DeviceStateResponse); err != nil {
+			return err
+		}
+	case *Packet_SubscribeReq:
+		b.EncodeVarint(19<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SubscribeReq); err != nil {
+			return err
+		}
+	case *Packet_UnsubscribeReq:
+		b.EncodeVarint(20<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.UnsubscribeReq); err != nil {
+			return err
+		}
+	case *Packet_SubscribeResponse:
+		b.EncodeVarint(21<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SubscribeResponse); err != nil {
+			return err
+		}
+	case *Packet_Event:
+		b.EncodeVarint(22<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Event); err != nil {
+			return err
+		}
+	case *Packet_ScheduleReq:
+		b.EncodeVarint(23<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ScheduleReq); err != nil {
+			return err
+		}
+	case *Packet_ScheduleResponse:
+		b.EncodeVarint(24<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ScheduleResponse); err != nil {
+			return err
+		}
+	case *Packet_SceneReq:
+		b.EncodeVarint(25<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SceneReq); err != nil {
+			return err
+		}
+	case *Packet_SceneResponse:
+		b.EncodeVarint(26<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SceneResponse); err != nil {
+			return err
+		}
 	case *Packet_Command:
 		b.EncodeVarint(99<<3 | proto.WireBytes)
 		if err := b.EncodeMessage(x.Command); err != nil {
@@ -194,6 +572,14 @@ func _Packet_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer)
 		err := b.DecodeMessage(msg)
 		m.Body = &Packet_RouterConfigReq{msg}
 		return true, err
+	case 4: // body.successResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(GeneralSuccessResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_SuccessResponse{msg}
+		return true, err
 	case 5: // body.errorResponse
 		if wire != proto.WireBytes {
 			return true, proto.ErrInternalBadWireType
@@ -210,6 +596,166 @@ func _Packet_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer)
 		err := b.DecodeMessage(msg)
 		m.Body = &Packet_DeviceTransfer{msg}
 		return true, err
+	case 7: // body.routeAdvertisement
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RouteAdvertisementPassive)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_RouteAdvertisement{msg}
+		return true, err
+	case 8: // body.pingReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(PingRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_PingReq{msg}
+		return true, err
+	case 9: // body.pingResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(PingResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_PingResponse{msg}
+		return true, err
+	case 10: // body.deviceRegistrationReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceRegistrationRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceRegistrationReq{msg}
+		return true, err
+	case 11: // body.deviceRegistrationResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceRegistrationResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceRegistrationResponse{msg}
+		return true, err
+	case 12: // body.deviceTransferReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceTransferRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceTransferReq{msg}
+		return true, err
+	case 13: // body.deviceTransferResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceTransferResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceTransferResponse{msg}
+		return true, err
+	case 14: // body.commandResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CommandResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_CommandResponse{msg}
+		return true, err
+	case 15: // body.deviceResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceResponse{msg}
+		return true, err
+	case 16: // body.deviceState
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceStatePassive)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceState{msg}
+		return true, err
+	case 17: // body.deviceStateReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceStateRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceStateReq{msg}
+		return true, err
+	case 18: // body.deviceStateResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(DeviceStateResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_DeviceStateResponse{msg}
+		return true, err
+	case 19: // body.subscribeReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SubscribeRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_SubscribeReq{msg}
+		return true, err
+	case 20: // body.unsubscribeReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(UnsubscribeRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_UnsubscribeReq{msg}
+		return true, err
+	case 21: // body.subscribeResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SubscribeResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_SubscribeResponse{msg}
+		return true, err
+	case 22: // body.event
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(EventPassive)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_Event{msg}
+		return true, err
+	case 23: // body.scheduleReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ScheduleRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_ScheduleReq{msg}
+		return true, err
+	case 24: // body.scheduleResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ScheduleResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_ScheduleResponse{msg}
+		return true, err
+	case 25: // body.sceneReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SceneRequest)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_SceneReq{msg}
+		return true, err
+	case 26: // body.sceneResponse
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SceneResponse)
+		err := b.DecodeMessage(msg)
+		m.Body = &Packet_SceneResponse{msg}
+		return true, err
 	case 99: // body.command
 		if wire != proto.WireBytes {
 			return true, proto.ErrInternalBadWireType
@@ -237,6 +783,11 @@ func _Packet_OneofSizer(msg proto.Message) (n int) {
 		n += proto.SizeVarint(3<<3 | proto.WireBytes)
 		n += proto.SizeVarint(uint64(s))
 		n += s
+	case *Packet_SuccessResponse:
+		s := proto.Size(x.SuccessResponse)
+		n += proto.SizeVarint(4<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case *Packet_ErrorResponse:
 		s := proto.Size(x.ErrorResponse)
 		n += proto.SizeVarint(5<<3 | proto.WireBytes)
@@ -247,6 +798,106 @@ func _Packet_OneofSizer(msg proto.Message) (n int) {
 		n += proto.SizeVarint(6<<3 | proto.WireBytes)
 		n += proto.SizeVarint(uint64(s))
 		n += s
+	case *Packet_RouteAdvertisement:
+		s := proto.Size(x.RouteAdvertisement)
+		n += proto.SizeVarint(7<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_PingReq:
+		s := proto.Size(x.PingReq)
+		n += proto.SizeVarint(8<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_PingResponse:
+		s := proto.Size(x.PingResponse)
+		n += proto.SizeVarint(9<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceRegistrationReq:
+		s := proto.Size(x.DeviceRegistrationReq)
+		n += proto.SizeVarint(10<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceRegistrationResponse:
+		s := proto.Size(x.DeviceRegistrationResponse)
+		n += proto.SizeVarint(11<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceTransferReq:
+		s := proto.Size(x.DeviceTransferReq)
+		n += proto.SizeVarint(12<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceTransferResponse:
+		s := proto.Size(x.DeviceTransferResponse)
+		n += proto.SizeVarint(13<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_CommandResponse:
+		s := proto.Size(x.CommandResponse)
+		n += proto.SizeVarint(14<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceResponse:
+		s := proto.Size(x.DeviceResponse)
+		n += proto.SizeVarint(15<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceState:
+		s := proto.Size(x.DeviceState)
+		n += proto.SizeVarint(16<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceStateReq:
+		s := proto.Size(x.DeviceStateReq)
+		n += proto.SizeVarint(17<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_DeviceStateResponse:
+		s := proto.Size(x.DeviceStateResponse)
+		n += proto.SizeVarint(18<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_SubscribeReq:
+		s := proto.Size(x.SubscribeReq)
+		n += proto.SizeVarint(19<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_UnsubscribeReq:
+		s := proto.Size(x.UnsubscribeReq)
+		n += proto.SizeVarint(20<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_SubscribeResponse:
+		s := proto.Size(x.SubscribeResponse)
+		n += proto.SizeVarint(21<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_Event:
+		s := proto.Size(x.Event)
+		n += proto.SizeVarint(22<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_ScheduleReq:
+		s := proto.Size(x.ScheduleReq)
+		n += proto.SizeVarint(23<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_ScheduleResponse:
+		s := proto.Size(x.ScheduleResponse)
+		n += proto.SizeVarint(24<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_SceneReq:
+		s := proto.Size(x.SceneReq)
+		n += proto.SizeVarint(25<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Packet_SceneResponse:
+		s := proto.Size(x.SceneResponse)
+		n += proto.SizeVarint(26<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case *Packet_Command:
 		s := proto.Size(x.Command)
 		n += proto.SizeVarint(99<<3 | proto.WireBytes)
@@ -284,6 +935,17 @@ func (m *GeneralErrorResponse) String() string            { return proto.Compact
 func (*GeneralErrorResponse) ProtoMessage()               {}
 func (*GeneralErrorResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{1} }
 
+// GeneralSuccessResponse acknowledges that a request was carried out.
+// <br>
+type GeneralSuccessResponse struct {
+	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *GeneralSuccessResponse) Reset()                    { *m = GeneralSuccessResponse{} }
+func (m *GeneralSuccessResponse) String() string            { return proto.CompactTextString(m) }
+func (*GeneralSuccessResponse) ProtoMessage()               {}
+func (*GeneralSuccessResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{2} }
+
 // IntroductionPassive is sent immediately upon opening of a socket
 // from the server to the client. As this is not a request->response
 // packet, it is named Passive to indicate the one-sided nature.
@@ -295,7 +957,7 @@ type IntroductionPassive struct {
 func (m *IntroductionPassive) Reset()                    { *m = IntroductionPassive{} }
 func (m *IntroductionPassive) String() string            { return proto.CompactTextString(m) }
 func (*IntroductionPassive) ProtoMessage()               {}
-func (*IntroductionPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{2} }
+func (*IntroductionPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{3} }
 
 // RouterConfigurationRequest contains the information that needs to be
 // configured on the router before it can function as a router.
@@ -309,7 +971,7 @@ type RouterConfigurationRequest struct {
 func (m *RouterConfigurationRequest) Reset()                    { *m = RouterConfigurationRequest{} }
 func (m *RouterConfigurationRequest) String() string            { return proto.CompactTextString(m) }
 func (*RouterConfigurationRequest) ProtoMessage()               {}
-func (*RouterConfigurationRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{3} }
+func (*RouterConfigurationRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{4} }
 
 // DeviceTransferPassive notifies definers and phones that a device
 // has paired with a new definer.
@@ -321,51 +983,657 @@ type DeviceTransferPassive struct {
 func (m *DeviceTransferPassive) Reset()                    { *m = DeviceTransferPassive{} }
 func (m *DeviceTransferPassive) String() string            { return proto.CompactTextString(m) }
 func (*DeviceTransferPassive) ProtoMessage()               {}
-func (*DeviceTransferPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{4} }
+func (*DeviceTransferPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{5} }
+
+// RouteAdvertisementPassive is periodically sent by a definer to each
+// of its neighbors, listing every router it can reach and how many
+// hops away it is.
+// <br>
+type RouteAdvertisementPassive struct {
+	Routes []*RouteAdvertisementPassive_Route `protobuf:"bytes,1,rep,name=routes" json:"routes,omitempty"`
+}
+
+func (m *RouteAdvertisementPassive) Reset()                    { *m = RouteAdvertisementPassive{} }
+func (m *RouteAdvertisementPassive) String() string            { return proto.CompactTextString(m) }
+func (*RouteAdvertisementPassive) ProtoMessage()               {}
+func (*RouteAdvertisementPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{6} }
+
+func (m *RouteAdvertisementPassive) GetRoutes() []*RouteAdvertisementPassive_Route {
+	if m != nil {
+		return m.Routes
+	}
+	return nil
+}
+
+type RouteAdvertisementPassive_Route struct {
+	Destination string `protobuf:"bytes,1,opt,name=destination" json:"destination,omitempty"`
+	Metric      uint32 `protobuf:"varint,2,opt,name=metric" json:"metric,omitempty"`
+}
+
+func (m *RouteAdvertisementPassive_Route) Reset()         { *m = RouteAdvertisementPassive_Route{} }
+func (m *RouteAdvertisementPassive_Route) String() string { return proto.CompactTextString(m) }
+func (*RouteAdvertisementPassive_Route) ProtoMessage()    {}
+func (*RouteAdvertisementPassive_Route) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{6, 0}
+}
+
+// PingRequest is periodically sent to peers and devices to check that
+// they are still reachable.
+// <br>
+type PingRequest struct {
+	Timestamp int64 `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *PingRequest) Reset()                    { *m = PingRequest{} }
+func (m *PingRequest) String() string            { return proto.CompactTextString(m) }
+func (*PingRequest) ProtoMessage()               {}
+func (*PingRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{7} }
+
+// PingResponse answers a PingRequest, echoing its timestamp.
+// <br>
+type PingResponse struct {
+	Timestamp int64 `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *PingResponse) Reset()                    { *m = PingResponse{} }
+func (m *PingResponse) String() string            { return proto.CompactTextString(m) }
+func (*PingResponse) ProtoMessage()               {}
+func (*PingResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{8} }
+
+// DeviceRegistrationRequest is sent by a device to a definer when it
+// boots, describing the device so the definer can take ownership of it.
+// If the device was registered with another definer before, that
+// definer is named as the previous owner. A device that shows or logs
+// a PIN includes it so that approval can be confirmed with it.
+// <br>
+type DeviceRegistrationRequest struct {
+	Id            string                          `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Version       string                          `protobuf:"bytes,2,opt,name=version" json:"version,omitempty"`
+	Manufacturer  string                          `protobuf:"bytes,3,opt,name=manufacturer" json:"manufacturer,omitempty"`
+	Type          *DeviceRegistrationRequest_Type `protobuf:"bytes,4,opt,name=type" json:"type,omitempty"`
+	Stack         string                          `protobuf:"bytes,5,opt,name=stack" json:"stack,omitempty"`
+	Address       string                          `protobuf:"bytes,6,opt,name=address" json:"address,omitempty"`
+	Port          string                          `protobuf:"bytes,7,opt,name=port" json:"port,omitempty"`
+	PreviousOwner string                          `protobuf:"bytes,8,opt,name=previousOwner" json:"previousOwner,omitempty"`
+	Pin           string                          `protobuf:"bytes,9,opt,name=pin" json:"pin,omitempty"`
+}
+
+func (m *DeviceRegistrationRequest) Reset()                    { *m = DeviceRegistrationRequest{} }
+func (m *DeviceRegistrationRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeviceRegistrationRequest) ProtoMessage()               {}
+func (*DeviceRegistrationRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{9} }
+
+func (m *DeviceRegistrationRequest) GetType() *DeviceRegistrationRequest_Type {
+	if m != nil {
+		return m.Type
+	}
+	return nil
+}
+
+type DeviceRegistrationRequest_Type struct {
+	Core     string `protobuf:"bytes,1,opt,name=core" json:"core,omitempty"`
+	Modifier string `protobuf:"bytes,2,opt,name=modifier" json:"modifier,omitempty"`
+}
+
+func (m *DeviceRegistrationRequest_Type) Reset()         { *m = DeviceRegistrationRequest_Type{} }
+func (m *DeviceRegistrationRequest_Type) String() string { return proto.CompactTextString(m) }
+func (*DeviceRegistrationRequest_Type) ProtoMessage()    {}
+func (*DeviceRegistrationRequest_Type) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{9, 0}
+}
+
+// DeviceRegistrationResponse acknowledges a DeviceRegistrationRequest,
+// naming the definer that now owns the device. New devices are pending
+// until they are approved on the definer.
+// <br>
+type DeviceRegistrationResponse struct {
+	Id      string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Owner   string `protobuf:"bytes,2,opt,name=owner" json:"owner,omitempty"`
+	Pending bool   `protobuf:"varint,3,opt,name=pending" json:"pending,omitempty"`
+}
+
+func (m *DeviceRegistrationResponse) Reset()                    { *m = DeviceRegistrationResponse{} }
+func (m *DeviceRegistrationResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeviceRegistrationResponse) ProtoMessage()               {}
+func (*DeviceRegistrationResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{10} }
+
+// DeviceTransferRequest is sent by a definer taking over a device to
+// the definer that owns it. The first request asks the owner to
+// prepare the transfer; the owner holds any packets for the device
+// from then on. The second, with commit set, asks the owner to
+// release the device.
+// <br>
+type DeviceTransferRequest struct {
+	Device string `protobuf:"bytes,1,opt,name=device" json:"device,omitempty"`
+	Commit bool   `protobuf:"varint,2,opt,name=commit" json:"commit,omitempty"`
+}
+
+func (m *DeviceTransferRequest) Reset()                    { *m = DeviceTransferRequest{} }
+func (m *DeviceTransferRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeviceTransferRequest) ProtoMessage()               {}
+func (*DeviceTransferRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{11} }
+
+// DeviceTransferResponse answers a DeviceTransferRequest with the
+// owner's record of the device. Once the device is released it also
+// carries the packets queued for the device and its last known state.
+// <br>
+type DeviceTransferResponse struct {
+	Device   *DeviceTransferResponse_Record  `protobuf:"bytes,1,opt,name=device" json:"device,omitempty"`
+	Queued   [][]byte                        `protobuf:"bytes,2,rep,name=queued" json:"queued,omitempty"`
+	State    []*DeviceTransferResponse_State `protobuf:"bytes,3,rep,name=state" json:"state,omitempty"`
+	Released bool                            `protobuf:"varint,4,opt,name=released" json:"released,omitempty"`
+}
+
+func (m *DeviceTransferResponse) Reset()                    { *m = DeviceTransferResponse{} }
+func (m *DeviceTransferResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeviceTransferResponse) ProtoMessage()               {}
+func (*DeviceTransferResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{12} }
+
+func (m *DeviceTransferResponse) GetDevice() *DeviceTransferResponse_Record {
+	if m != nil {
+		return m.Device
+	}
+	return nil
+}
+
+func (m *DeviceTransferResponse) GetState() []*DeviceTransferResponse_State {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
+type DeviceTransferResponse_Record struct {
+	Id           string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Version      string `protobuf:"bytes,2,opt,name=version" json:"version,omitempty"`
+	Manufacturer string `protobuf:"bytes,3,opt,name=manufacturer" json:"manufacturer,omitempty"`
+	Core         string `protobuf:"bytes,4,opt,name=core" json:"core,omitempty"`
+	Modifier     string `protobuf:"bytes,5,opt,name=modifier" json:"modifier,omitempty"`
+	Stack        string `protobuf:"bytes,6,opt,name=stack" json:"stack,omitempty"`
+	Address      string `protobuf:"bytes,7,opt,name=address" json:"address,omitempty"`
+	Port         string `protobuf:"bytes,8,opt,name=port" json:"port,omitempty"`
+}
+
+func (m *DeviceTransferResponse_Record) Reset()         { *m = DeviceTransferResponse_Record{} }
+func (m *DeviceTransferResponse_Record) String() string { return proto.CompactTextString(m) }
+func (*DeviceTransferResponse_Record) ProtoMessage()    {}
+func (*DeviceTransferResponse_Record) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{12, 0}
+}
+
+type DeviceTransferResponse_State struct {
+	Key     string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value   string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	Updated int64  `protobuf:"varint,3,opt,name=updated" json:"updated,omitempty"`
+}
+
+func (m *DeviceTransferResponse_State) Reset()         { *m = DeviceTransferResponse_State{} }
+func (m *DeviceTransferResponse_State) String() string { return proto.CompactTextString(m) }
+func (*DeviceTransferResponse_State) ProtoMessage()    {}
+func (*DeviceTransferResponse_State) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{12, 1}
+}
+
+// CommandResponse reports the outcome of a Command for every device
+// it targeted. Success is only set if the command reached them all.
+// <br>
+type CommandResponse struct {
+	Success bool                      `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
+	Results []*CommandResponse_Result `protobuf:"bytes,2,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *CommandResponse) Reset()                    { *m = CommandResponse{} }
+func (m *CommandResponse) String() string            { return proto.CompactTextString(m) }
+func (*CommandResponse) ProtoMessage()               {}
+func (*CommandResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{13} }
+
+func (m *CommandResponse) GetResults() []*CommandResponse_Result {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type CommandResponse_Result struct {
+	Device  string `protobuf:"bytes,1,opt,name=device" json:"device,omitempty"`
+	Success bool   `protobuf:"varint,2,opt,name=success" json:"success,omitempty"`
+	Queued  bool   `protobuf:"varint,3,opt,name=queued" json:"queued,omitempty"`
+	Error   string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *CommandResponse_Result) Reset()                    { *m = CommandResponse_Result{} }
+func (m *CommandResponse_Result) String() string            { return proto.CompactTextString(m) }
+func (*CommandResponse_Result) ProtoMessage()               {}
+func (*CommandResponse_Result) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{13, 0} }
+
+// DeviceResponse is what a device reports after carrying out a
+// command: whether it worked, why not, and any result or state it
+// has to return. A device sends it on the connection the command
+// arrived on, or later on a connection of its own, naming the
+// command's packet ID as the request. The definer that owns the
+// device relays it to whoever sent the command.
+// <br>
+type DeviceResponse struct {
+	Request string                  `protobuf:"bytes,1,opt,name=request" json:"request,omitempty"`
+	Device  string                  `protobuf:"bytes,2,opt,name=device" json:"device,omitempty"`
+	Success bool                    `protobuf:"varint,3,opt,name=success" json:"success,omitempty"`
+	Error   string                  `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+	Result  []byte                  `protobuf:"bytes,5,opt,name=result" json:"result,omitempty"`
+	State   []*DeviceResponse_State `protobuf:"bytes,6,rep,name=state" json:"state,omitempty"`
+}
+
+func (m *DeviceResponse) Reset()                    { *m = DeviceResponse{} }
+func (m *DeviceResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeviceResponse) ProtoMessage()               {}
+func (*DeviceResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{14} }
+
+func (m *DeviceResponse) GetState() []*DeviceResponse_State {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
+type DeviceResponse_State struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *DeviceResponse_State) Reset()                    { *m = DeviceResponse_State{} }
+func (m *DeviceResponse_State) String() string            { return proto.CompactTextString(m) }
+func (*DeviceResponse_State) ProtoMessage()               {}
+func (*DeviceResponse_State) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{14, 0} }
+
+// DeviceStatePassive is pushed by a device whenever its state changes,
+// listing the attributes that changed along with when they were read.
+// Timestamps are in nanoseconds since the Unix epoch, and zero means
+// now.
+// <br>
+type DeviceStatePassive struct {
+	Device     string                          `protobuf:"bytes,1,opt,name=device" json:"device,omitempty"`
+	Attributes []*DeviceStatePassive_Attribute `protobuf:"bytes,2,rep,name=attributes" json:"attributes,omitempty"`
+	Timestamp  int64                           `protobuf:"varint,3,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *DeviceStatePassive) Reset()                    { *m = DeviceStatePassive{} }
+func (m *DeviceStatePassive) String() string            { return proto.CompactTextString(m) }
+func (*DeviceStatePassive) ProtoMessage()               {}
+func (*DeviceStatePassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{15} }
+
+func (m *DeviceStatePassive) GetAttributes() []*DeviceStatePassive_Attribute {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+type DeviceStatePassive_Attribute struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *DeviceStatePassive_Attribute) Reset()         { *m = DeviceStatePassive_Attribute{} }
+func (m *DeviceStatePassive_Attribute) String() string { return proto.CompactTextString(m) }
+func (*DeviceStatePassive_Attribute) ProtoMessage()    {}
+func (*DeviceStatePassive_Attribute) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{15, 0}
+}
+
+// DeviceStateRequest asks a definer for the last state reported by
+// its devices without contacting them. Asking for no devices in
+// particular asks for them all.
+// <br>
+type DeviceStateRequest struct {
+	Devices []string `protobuf:"bytes,1,rep,name=devices" json:"devices,omitempty"`
+}
+
+func (m *DeviceStateRequest) Reset()                    { *m = DeviceStateRequest{} }
+func (m *DeviceStateRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeviceStateRequest) ProtoMessage()               {}
+func (*DeviceStateRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{16} }
+
+// DeviceStateResponse answers a DeviceStateRequest with every
+// attribute last reported by each device and when it was reported.
+// <br>
+type DeviceStateResponse struct {
+	Devices []*DeviceStateResponse_Device `protobuf:"bytes,1,rep,name=devices" json:"devices,omitempty"`
+}
+
+func (m *DeviceStateResponse) Reset()                    { *m = DeviceStateResponse{} }
+func (m *DeviceStateResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeviceStateResponse) ProtoMessage()               {}
+func (*DeviceStateResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{17} }
+
+func (m *DeviceStateResponse) GetDevices() []*DeviceStateResponse_Device {
+	if m != nil {
+		return m.Devices
+	}
+	return nil
+}
+
+type DeviceStateResponse_Attribute struct {
+	Key     string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value   string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	Updated int64  `protobuf:"varint,3,opt,name=updated" json:"updated,omitempty"`
+}
+
+func (m *DeviceStateResponse_Attribute) Reset()         { *m = DeviceStateResponse_Attribute{} }
+func (m *DeviceStateResponse_Attribute) String() string { return proto.CompactTextString(m) }
+func (*DeviceStateResponse_Attribute) ProtoMessage()    {}
+func (*DeviceStateResponse_Attribute) Descriptor() ([]byte, []int) {
+	return fileDescriptor1, []int{17, 0}
+}
+
+type DeviceStateResponse_Device struct {
+	Id         string                           `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Attributes []*DeviceStateResponse_Attribute `protobuf:"bytes,2,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *DeviceStateResponse_Device) Reset()                    { *m = DeviceStateResponse_Device{} }
+func (m *DeviceStateResponse_Device) String() string            { return proto.CompactTextString(m) }
+func (*DeviceStateResponse_Device) ProtoMessage()               {}
+func (*DeviceStateResponse_Device) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{17, 1} }
+
+func (m *DeviceStateResponse_Device) GetAttributes() []*DeviceStateResponse_Attribute {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+// SubscribeRequest asks the definer to push the events published on
+// the given topics over the connection the request arrived on, until
+// the client unsubscribes or disconnects.
+// <br>
+type SubscribeRequest struct {
+	Topics []string `protobuf:"bytes,1,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()                    { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()               {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{18} }
+
+// UnsubscribeRequest stops the events on the given topics from being
+// pushed to the client. Naming no topics stops them all.
+// <br>
+type UnsubscribeRequest struct {
+	Topics []string `protobuf:"bytes,1,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *UnsubscribeRequest) Reset()                    { *m = UnsubscribeRequest{} }
+func (m *UnsubscribeRequest) String() string            { return proto.CompactTextString(m) }
+func (*UnsubscribeRequest) ProtoMessage()               {}
+func (*UnsubscribeRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{19} }
+
+// SubscribeResponse answers a SubscribeRequest or UnsubscribeRequest
+// with every topic the client is now subscribed to.
+// <br>
+type SubscribeResponse struct {
+	Topics []string `protobuf:"bytes,1,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *SubscribeResponse) Reset()                    { *m = SubscribeResponse{} }
+func (m *SubscribeResponse) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeResponse) ProtoMessage()               {}
+func (*SubscribeResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{20} }
+
+// EventPassive is pushed to the clients subscribed to its topic when
+// something happens on the definer. Its time is in nanoseconds since
+// the Unix epoch.
+// <br>
+type EventPassive struct {
+	Topic     string                `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Subject   string                `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Data      []*EventPassive_Entry `protobuf:"bytes,3,rep,name=data" json:"data,omitempty"`
+	Timestamp int64                 `protobuf:"varint,4,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *EventPassive) Reset()                    { *m = EventPassive{} }
+func (m *EventPassive) String() string            { return proto.CompactTextString(m) }
+func (*EventPassive) ProtoMessage()               {}
+func (*EventPassive) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{21} }
+
+func (m *EventPassive) GetData() []*EventPassive_Entry {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type EventPassive_Entry struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *EventPassive_Entry) Reset()                    { *m = EventPassive_Entry{} }
+func (m *EventPassive_Entry) String() string            { return proto.CompactTextString(m) }
+func (*EventPassive_Entry) ProtoMessage()               {}
+func (*EventPassive_Entry) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{21, 0} }
+
+// ScheduleRequest asks the definer to carry out a command later:
+// once at a time, on a recurring cron expression (minute hour
+// day-of-month month day-of-week), or every day at sunrise or sunset
+// moved by an offset in minutes. Exactly one of at, cron and sun must
+// be given. Times are in nanoseconds since the Unix epoch.
+// <br>
+type ScheduleRequest struct {
+	At      int64    `protobuf:"varint,1,opt,name=at" json:"at,omitempty"`
+	Cron    string   `protobuf:"bytes,2,opt,name=cron" json:"cron,omitempty"`
+	Sun     string   `protobuf:"bytes,3,opt,name=sun" json:"sun,omitempty"`
+	Offset  int32    `protobuf:"varint,4,opt,name=offset" json:"offset,omitempty"`
+	Command *Command `protobuf:"bytes,5,opt,name=command" json:"command,omitempty"`
+}
+
+func (m *ScheduleRequest) Reset()                    { *m = ScheduleRequest{} }
+func (m *ScheduleRequest) String() string            { return proto.CompactTextString(m) }
+func (*ScheduleRequest) ProtoMessage()               {}
+func (*ScheduleRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{22} }
+
+func (m *ScheduleRequest) GetCommand() *Command {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+// ScheduleResponse names the schedule created by a ScheduleRequest
+// and when it will first run.
+// <br>
+type ScheduleResponse struct {
+	Id   string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Next int64  `protobuf:"varint,2,opt,name=next" json:"next,omitempty"`
+}
+
+func (m *ScheduleResponse) Reset()                    { *m = ScheduleResponse{} }
+func (m *ScheduleResponse) String() string            { return proto.CompactTextString(m) }
+func (*ScheduleResponse) ProtoMessage()               {}
+func (*ScheduleResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{23} }
+
+// SceneRequest asks the definer to run one of the scenes in its
+// configuration.
+// <br>
+type SceneRequest struct {
+	Scene string `protobuf:"bytes,1,opt,name=scene" json:"scene,omitempty"`
+}
+
+func (m *SceneRequest) Reset()                    { *m = SceneRequest{} }
+func (m *SceneRequest) String() string            { return proto.CompactTextString(m) }
+func (*SceneRequest) ProtoMessage()               {}
+func (*SceneRequest) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{24} }
+
+// SceneResponse reports the outcome of a scene with one result for
+// every device it targeted. A device's result is only successful if
+// every step of the scene sent to it was, and carries the first error.
+// <br>
+type SceneResponse struct {
+	Scene   string                    `protobuf:"bytes,1,opt,name=scene" json:"scene,omitempty"`
+	Success bool                      `protobuf:"varint,2,opt,name=success" json:"success,omitempty"`
+	Results []*CommandResponse_Result `protobuf:"bytes,3,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *SceneResponse) Reset()                    { *m = SceneResponse{} }
+func (m *SceneResponse) String() string            { return proto.CompactTextString(m) }
+func (*SceneResponse) ProtoMessage()               {}
+func (*SceneResponse) Descriptor() ([]byte, []int) { return fileDescriptor1, []int{25} }
+
+func (m *SceneResponse) GetResults() []*CommandResponse_Result {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
 
 func init() {
 	proto.RegisterType((*Packet)(nil), "packets.Packet")
 	proto.RegisterType((*Packet_Header)(nil), "packets.Packet.Header")
 	proto.RegisterType((*GeneralErrorResponse)(nil), "packets.GeneralErrorResponse")
+	proto.RegisterType((*GeneralSuccessResponse)(nil), "packets.GeneralSuccessResponse")
 	proto.RegisterType((*IntroductionPassive)(nil), "packets.IntroductionPassive")
 	proto.RegisterType((*RouterConfigurationRequest)(nil), "packets.RouterConfigurationRequest")
 	proto.RegisterType((*DeviceTransferPassive)(nil), "packets.DeviceTransferPassive")
+	proto.RegisterType((*RouteAdvertisementPassive)(nil), "packets.RouteAdvertisementPassive")
+	proto.RegisterType((*RouteAdvertisementPassive_Route)(nil), "packets.RouteAdvertisementPassive.Route")
+	proto.RegisterType((*PingRequest)(nil), "packets.PingRequest")
+	proto.RegisterType((*PingResponse)(nil), "packets.PingResponse")
+	proto.RegisterType((*DeviceRegistrationRequest)(nil), "packets.DeviceRegistrationRequest")
+	proto.RegisterType((*DeviceRegistrationRequest_Type)(nil), "packets.DeviceRegistrationRequest.Type")
+	proto.RegisterType((*DeviceRegistrationResponse)(nil), "packets.DeviceRegistrationResponse")
+	proto.RegisterType((*DeviceTransferRequest)(nil), "packets.DeviceTransferRequest")
+	proto.RegisterType((*DeviceTransferResponse)(nil), "packets.DeviceTransferResponse")
+	proto.RegisterType((*DeviceTransferResponse_Record)(nil), "packets.DeviceTransferResponse.Record")
+	proto.RegisterType((*DeviceTransferResponse_State)(nil), "packets.DeviceTransferResponse.State")
+	proto.RegisterType((*CommandResponse)(nil), "packets.CommandResponse")
+	proto.RegisterType((*CommandResponse_Result)(nil), "packets.CommandResponse.Result")
+	proto.RegisterType((*DeviceResponse)(nil), "packets.DeviceResponse")
+	proto.RegisterType((*DeviceResponse_State)(nil), "packets.DeviceResponse.State")
+	proto.RegisterType((*DeviceStatePassive)(nil), "packets.DeviceStatePassive")
+	proto.RegisterType((*DeviceStatePassive_Attribute)(nil), "packets.DeviceStatePassive.Attribute")
+	proto.RegisterType((*DeviceStateRequest)(nil), "packets.DeviceStateRequest")
+	proto.RegisterType((*DeviceStateResponse)(nil), "packets.DeviceStateResponse")
+	proto.RegisterType((*DeviceStateResponse_Attribute)(nil), "packets.DeviceStateResponse.Attribute")
+	proto.RegisterType((*DeviceStateResponse_Device)(nil), "packets.DeviceStateResponse.Device")
+	proto.RegisterType((*SubscribeRequest)(nil), "packets.SubscribeRequest")
+	proto.RegisterType((*UnsubscribeRequest)(nil), "packets.UnsubscribeRequest")
+	proto.RegisterType((*SubscribeResponse)(nil), "packets.SubscribeResponse")
+	proto.RegisterType((*EventPassive)(nil), "packets.EventPassive")
+	proto.RegisterType((*EventPassive_Entry)(nil), "packets.EventPassive.Entry")
+	proto.RegisterType((*ScheduleRequest)(nil), "packets.ScheduleRequest")
+	proto.RegisterType((*ScheduleResponse)(nil), "packets.ScheduleResponse")
+	proto.RegisterType((*SceneRequest)(nil), "packets.SceneRequest")
+	proto.RegisterType((*SceneResponse)(nil), "packets.SceneResponse")
 	proto.RegisterEnum("packets.Packet_Header_Type", Packet_Header_Type_name, Packet_Header_Type_value)
 }
 
 func init() { proto.RegisterFile("communication.proto", fileDescriptor1) }
 
 var fileDescriptor1 = []byte{
-	// 485 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
-	0x10, 0x4d, 0x52, 0xc7, 0x49, 0x26, 0x25, 0x44, 0xdb, 0x52, 0x59, 0xe1, 0x43, 0x91, 0xb9, 0x44,
-	0x02, 0xb9, 0x52, 0xe1, 0xc4, 0x0d, 0x8a, 0x85, 0x7b, 0x80, 0x86, 0x4d, 0xe0, 0xcc, 0xd6, 0x3b,
-	0x0d, 0x2b, 0xc8, 0xae, 0xbb, 0x6b, 0x17, 0xe5, 0x27, 0x72, 0xe5, 0x17, 0x21, 0x8f, 0x5d, 0x93,
-	0x54, 0xe1, 0xb6, 0x33, 0xef, 0xbd, 0x59, 0xcf, 0x7b, 0x6b, 0x38, 0x4a, 0xcd, 0x7a, 0x5d, 0x68,
-	0x95, 0x8a, 0x5c, 0x19, 0x1d, 0x65, 0xd6, 0xe4, 0x86, 0xf5, 0x32, 0x91, 0xfe, 0xc0, 0xdc, 0x4d,
-	0x46, 0x25, 0x2a, 0xb4, 0x74, 0x15, 0x10, 0xfe, 0xf1, 0xc0, 0x9f, 0x13, 0xc6, 0x22, 0xf0, 0xbf,
-	0xa3, 0x90, 0x68, 0x83, 0xf6, 0xb4, 0x3d, 0x1b, 0x9e, 0x9d, 0x44, 0xb5, 0x28, 0xaa, 0x08, 0x51,
-	0x42, 0x28, 0xaf, 0x59, 0xec, 0x35, 0x74, 0x95, 0xce, 0xad, 0x09, 0x3a, 0x44, 0x7f, 0xd2, 0xd0,
-	0x2f, 0xca, 0xae, 0x2c, 0xd2, 0xf2, 0xfe, 0xb9, 0x70, 0x4e, 0xdd, 0x62, 0xd2, 0xe2, 0x15, 0x99,
-	0x5d, 0xc2, 0x43, 0x6b, 0x8a, 0x1c, 0xed, 0xb9, 0xd1, 0xd7, 0x6a, 0xc5, 0xf1, 0x26, 0x38, 0x20,
-	0xfd, 0xf3, 0x46, 0xcf, 0xb7, 0xf0, 0xc2, 0xd2, 0x1a, 0x1c, 0x6f, 0x0a, 0x74, 0x79, 0xd2, 0xe2,
-	0xf7, 0xd5, 0x2c, 0x86, 0x07, 0x68, 0xad, 0xb1, 0x1c, 0x5d, 0x66, 0xb4, 0xc3, 0xa0, 0x4b, 0xe3,
-	0x9e, 0x36, 0xe3, 0x3e, 0xa0, 0x46, 0x2b, 0x7e, 0xc6, 0xdb, 0xa4, 0xa4, 0xc5, 0x77, 0x55, 0x2c,
-	0x81, 0x91, 0xc4, 0x5b, 0x95, 0xe2, 0xd2, 0x0a, 0xed, 0xae, 0xd1, 0x06, 0x3e, 0xcd, 0x79, 0xd6,
-	0xcc, 0x79, 0xbf, 0x03, 0xff, 0x5b, 0xec, 0x9e, 0x8e, 0xbd, 0x84, 0x5e, 0x6d, 0x72, 0x90, 0xd2,
-	0x88, 0x71, 0x33, 0xe2, 0xbc, 0xea, 0x27, 0x2d, 0x7e, 0x47, 0x99, 0xfc, 0x6e, 0x83, 0x5f, 0x19,
-	0xcb, 0x4e, 0xc0, 0x37, 0x56, 0xad, 0x94, 0xa6, 0x00, 0x06, 0xbc, 0xae, 0xd8, 0x14, 0x86, 0x12,
-	0x5d, 0xae, 0x34, 0x59, 0x41, 0x76, 0x0f, 0xf8, 0x76, 0x8b, 0x8d, 0xa0, 0xa3, 0x24, 0xf9, 0x38,
-	0xe0, 0x1d, 0x25, 0xd9, 0x29, 0x78, 0xf9, 0x26, 0xc3, 0xc0, 0x9b, 0xb6, 0x67, 0xa3, 0xb3, 0xc7,
-	0xfb, 0x83, 0x8c, 0x96, 0x9b, 0x0c, 0x39, 0x11, 0xd9, 0x31, 0x74, 0xc9, 0xd7, 0xa0, 0x3b, 0x3d,
-	0x98, 0x0d, 0x78, 0x55, 0x84, 0x11, 0x78, 0x25, 0x87, 0x0d, 0xa1, 0xc7, 0xe3, 0xcf, 0x5f, 0xe2,
-	0xc5, 0x72, 0xdc, 0x62, 0x87, 0xd0, 0xe7, 0xf1, 0x62, 0x7e, 0xf9, 0x69, 0x11, 0x8f, 0xdb, 0x25,
-	0x34, 0x7f, 0xbb, 0x58, 0x5c, 0x7c, 0x8d, 0xc7, 0x9d, 0x77, 0x3e, 0x78, 0x57, 0x46, 0x6e, 0xc2,
-	0x37, 0x70, 0xbc, 0xcf, 0x74, 0x16, 0xc2, 0x21, 0x99, 0xfe, 0x11, 0x9d, 0x13, 0x2b, 0xac, 0xd7,
-	0xdc, 0xe9, 0x85, 0x2f, 0xe0, 0x68, 0xcf, 0xfb, 0x29, 0x3f, 0xd0, 0x61, 0x5e, 0x64, 0xa4, 0xe9,
-	0xf3, 0xaa, 0x08, 0xbf, 0xc1, 0xe4, 0xff, 0x8f, 0x85, 0x31, 0xf0, 0x9c, 0x53, 0xb2, 0xbe, 0x86,
-	0xce, 0x6c, 0x02, 0xfd, 0x4c, 0x38, 0xf7, 0xcb, 0x58, 0x59, 0x1b, 0xd9, 0xd4, 0x25, 0x5f, 0x8b,
-	0x35, 0xd6, 0x3e, 0xd2, 0x39, 0x3c, 0x85, 0x47, 0x7b, 0x73, 0x2f, 0xc3, 0xaa, 0x72, 0xbf, 0x0b,
-	0xab, 0xaa, 0xae, 0x7c, 0xfa, 0xaf, 0x5e, 0xfd, 0x0d, 0x00, 0x00, 0xff, 0xff, 0x3b, 0x51, 0x06,
-	0xf3, 0x87, 0x03, 0x00, 0x00,
+	// 1703 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xac, 0x58, 0x4b, 0x73, 0xdc, 0xc4,
+	0x13, 0xdf, 0xf7, 0xa3, 0x77, 0x6d, 0xaf, 0xc7, 0x8f, 0xc8, 0x4a, 0xfe, 0x7f, 0x5c, 0x22, 0x04,
+	0x57, 0x12, 0xd6, 0x94, 0x4d, 0xa5, 0x0a, 0x02, 0x09, 0x26, 0x5e, 0xb2, 0x81, 0x4a, 0x62, 0x66,
+	0x1d, 0xaa, 0xa0, 0x38, 0x44, 0x96, 0xc6, 0x8e, 0x88, 0x57, 0xda, 0xcc, 0x48, 0x1b, 0xcc, 0x9d,
+	0x13, 0x5f, 0x82, 0x0f, 0xc1, 0x99, 0x23, 0x55, 0x70, 0xe2, 0xcc, 0x8d, 0x6f, 0xc0, 0x47, 0xa0,
+	0xe6, 0x21, 0xad, 0x34, 0x2b, 0xd9, 0x09, 0xc5, 0x6d, 0x7a, 0xa6, 0xfb, 0xa7, 0x7e, 0x4e, 0xf7,
+	0x08, 0x56, 0x9c, 0x60, 0x3c, 0x8e, 0x7c, 0xcf, 0xb1, 0x43, 0x2f, 0xf0, 0xfb, 0x13, 0x1a, 0x84,
+	0x01, 0x6a, 0x4e, 0x6c, 0xe7, 0x39, 0x09, 0x99, 0xb9, 0xc8, 0x4f, 0x6d, 0xdf, 0x65, 0xf2, 0xc0,
+	0xfa, 0xb9, 0x07, 0x8d, 0x03, 0x71, 0x86, 0xfa, 0xd0, 0x78, 0x46, 0x6c, 0x97, 0x50, 0xa3, 0xbc,
+	0x59, 0xde, 0xea, 0xec, 0xac, 0xf7, 0x95, 0x50, 0x5f, 0x32, 0xf4, 0x87, 0xe2, 0x14, 0x2b, 0x2e,
+	0xf4, 0x1e, 0xd4, 0x3d, 0x3f, 0xa4, 0x81, 0x51, 0x11, 0xec, 0x57, 0x12, 0xf6, 0x07, 0x7c, 0xd7,
+	0x8d, 0x1c, 0xfe, 0xfd, 0x03, 0x9b, 0x31, 0x6f, 0x4a, 0x86, 0x25, 0x2c, 0x99, 0xd1, 0x63, 0x58,
+	0xa2, 0x41, 0x14, 0x12, 0x7a, 0x2f, 0xf0, 0x8f, 0xbd, 0x13, 0x4c, 0x5e, 0x18, 0x55, 0x21, 0xff,
+	0x66, 0x22, 0x8f, 0x53, 0xe7, 0x11, 0x15, 0x66, 0x60, 0xf2, 0x22, 0x22, 0x2c, 0x1c, 0x96, 0xb0,
+	0x2e, 0x8d, 0x3e, 0x87, 0x25, 0x16, 0x39, 0x0e, 0x61, 0x0c, 0x13, 0x36, 0x09, 0x7c, 0x46, 0x8c,
+	0x9a, 0x00, 0x7c, 0x23, 0x01, 0xbc, 0x4f, 0x7c, 0x42, 0xed, 0xd3, 0x51, 0x96, 0x8d, 0x83, 0x69,
+	0x92, 0x68, 0x00, 0x0b, 0x84, 0xd2, 0x80, 0x26, 0x50, 0x75, 0x01, 0xf5, 0x3f, 0x1d, 0x6a, 0x90,
+	0x66, 0x1a, 0x96, 0x70, 0x56, 0x0a, 0x0d, 0x61, 0xd1, 0x25, 0x53, 0xcf, 0x21, 0x87, 0xd4, 0xf6,
+	0xd9, 0x31, 0xa1, 0x46, 0x43, 0xe0, 0xfc, 0x3f, 0xc1, 0xd9, 0xcf, 0x1c, 0xcf, 0xbc, 0xa4, 0xc9,
+	0xa1, 0x43, 0x40, 0xc2, 0xe0, 0x3d, 0x77, 0x4a, 0x68, 0xe8, 0x31, 0x32, 0x26, 0x7e, 0x68, 0x34,
+	0x05, 0x9a, 0x95, 0xf5, 0x58, 0x86, 0x65, 0x86, 0x98, 0x23, 0x8f, 0xde, 0x85, 0xe6, 0xc4, 0xf3,
+	0x85, 0xf3, 0x5b, 0x02, 0x6a, 0x75, 0x16, 0x6b, 0xb9, 0xaf, 0xbc, 0x1d, 0xb3, 0xa1, 0xdb, 0xd0,
+	0x95, 0x4b, 0xe5, 0x97, 0xb6, 0x10, 0x5b, 0xd3, 0xc4, 0x12, 0x7f, 0x64, 0x98, 0xd1, 0xd7, 0xb0,
+	0x26, 0xcd, 0xc2, 0xe4, 0xc4, 0x63, 0x61, 0x12, 0x52, 0x03, 0x34, 0x3b, 0xf6, 0xf3, 0xb8, 0x94,
+	0x2a, 0xf9, 0x10, 0x88, 0x80, 0x99, 0x77, 0xa0, 0xd4, 0xec, 0x68, 0xa9, 0xb5, 0x5f, 0xc8, 0x3a,
+	0x2c, 0xe1, 0x73, 0x80, 0xd0, 0x23, 0x58, 0xce, 0x46, 0x86, 0xab, 0xdf, 0x3d, 0x37, 0xa8, 0x33,
+	0xd5, 0xe7, 0x45, 0xd1, 0x57, 0xb0, 0xae, 0x6f, 0x2a, 0x95, 0x17, 0xb4, 0xe4, 0xdd, 0xcf, 0x65,
+	0x1b, 0x96, 0x70, 0x01, 0x00, 0xda, 0x87, 0x25, 0x55, 0xe4, 0x09, 0xe6, 0xa2, 0xc0, 0x34, 0x12,
+	0xcc, 0x7b, 0xd9, 0x73, 0x5e, 0x09, 0x9a, 0x08, 0xda, 0x8b, 0x53, 0x38, 0x01, 0x59, 0x12, 0x20,
+	0x97, 0xe6, 0x7c, 0x99, 0x60, 0x68, 0x02, 0xe8, 0x2e, 0x74, 0xe4, 0xce, 0x28, 0xb4, 0x43, 0x62,
+	0xf4, 0x84, 0xfc, 0x65, 0x4d, 0x5e, 0x9c, 0xcd, 0xb2, 0x35, 0x2d, 0x81, 0x06, 0xb0, 0x98, 0x22,
+	0xb9, 0xc7, 0x97, 0x8b, 0x31, 0x66, 0xee, 0xd6, 0x84, 0xd0, 0x01, 0xac, 0x64, 0x76, 0x94, 0x3d,
+	0x48, 0xbb, 0xb6, 0xf6, 0xe7, 0x79, 0x86, 0x25, 0x9c, 0x27, 0x8a, 0xee, 0x42, 0x97, 0x45, 0x47,
+	0xcc, 0xa1, 0xde, 0x91, 0x50, 0x6b, 0x45, 0x40, 0x6d, 0x24, 0x50, 0xa3, 0xd4, 0xa1, 0x52, 0x2a,
+	0x23, 0xc0, 0x2d, 0x8b, 0xfc, 0x0c, 0xc4, 0xaa, 0x66, 0xd9, 0x13, 0x9f, 0xcd, 0x83, 0x68, 0x42,
+	0xe8, 0x33, 0x58, 0x4e, 0xd1, 0xca, 0xae, 0x35, 0x81, 0x64, 0xe6, 0x29, 0x93, 0x58, 0x35, 0x2f,
+	0x86, 0xde, 0x81, 0x3a, 0x99, 0xf2, 0xcb, 0x65, 0x5d, 0x2b, 0xed, 0xc1, 0x34, 0x73, 0x9f, 0x48,
+	0x2e, 0xf4, 0x21, 0x74, 0x98, 0xf3, 0x8c, 0xb8, 0xd1, 0xa9, 0x50, 0xff, 0x92, 0x96, 0x61, 0xa3,
+	0xd9, 0x99, 0xd2, 0x3d, 0xcd, 0x8e, 0xee, 0x43, 0x6f, 0x46, 0x2a, 0xbd, 0x0d, 0xdd, 0x89, 0x1a,
+	0xc3, 0xb0, 0x84, 0xe7, 0x84, 0xd0, 0x2e, 0xb4, 0x98, 0x43, 0x7c, 0xa1, 0xc3, 0x86, 0xa6, 0xf8,
+	0x48, 0x1d, 0x28, 0x05, 0x12, 0x46, 0x74, 0x07, 0x16, 0xd4, 0x5a, 0x7d, 0xda, 0xd4, 0x1a, 0xde,
+	0x28, 0x7d, 0xca, 0xaf, 0xf7, 0x0c, 0x3b, 0xba, 0x09, 0x4d, 0x55, 0x2e, 0x86, 0x23, 0x24, 0x7b,
+	0x7a, 0x65, 0xf1, 0xab, 0x53, 0xb1, 0x98, 0xbf, 0x95, 0xa1, 0x21, 0x5b, 0x27, 0x5a, 0x87, 0x46,
+	0x40, 0xbd, 0x13, 0xcf, 0x17, 0x2d, 0xb6, 0x8d, 0x15, 0x85, 0x36, 0x79, 0xa5, 0xb0, 0xd0, 0xf3,
+	0xc5, 0xa5, 0x23, 0x1a, 0x6a, 0x1b, 0xa7, 0xb7, 0xd0, 0x22, 0x54, 0x3c, 0x57, 0x74, 0xca, 0x36,
+	0xae, 0x78, 0x2e, 0xda, 0x86, 0x5a, 0x78, 0x36, 0x91, 0xad, 0x6e, 0x71, 0xe7, 0x72, 0x7e, 0xab,
+	0xee, 0x1f, 0x9e, 0x4d, 0x08, 0x16, 0x8c, 0x68, 0x15, 0xea, 0xa2, 0x11, 0x18, 0xf5, 0xcd, 0xea,
+	0x56, 0x1b, 0x4b, 0xc2, 0xea, 0x43, 0x8d, 0xf3, 0xa0, 0x0e, 0x34, 0xf1, 0xe0, 0x8b, 0x27, 0x83,
+	0xd1, 0x61, 0xaf, 0x84, 0xba, 0xd0, 0xc2, 0x83, 0xd1, 0xc1, 0xe3, 0x47, 0xa3, 0x41, 0xaf, 0xcc,
+	0x8f, 0x0e, 0xf6, 0x46, 0xa3, 0x07, 0x5f, 0x0e, 0x7a, 0x95, 0x4f, 0x1a, 0x50, 0x3b, 0x0a, 0xdc,
+	0x33, 0xeb, 0x03, 0x58, 0xcd, 0xeb, 0x84, 0xc8, 0x82, 0xae, 0xe8, 0x84, 0x0f, 0x09, 0x63, 0xf6,
+	0x09, 0x51, 0x66, 0x66, 0xf6, 0xac, 0x1d, 0x58, 0xcf, 0x6f, 0xc8, 0xc8, 0x80, 0xe6, 0x38, 0x23,
+	0x18, 0x93, 0xd6, 0x0d, 0x58, 0xc9, 0x99, 0x2a, 0xb8, 0x51, 0x8c, 0x84, 0xd1, 0x44, 0xb0, 0xb7,
+	0xb0, 0x24, 0xac, 0xa7, 0x60, 0x16, 0x8f, 0x10, 0x08, 0x41, 0x8d, 0x31, 0xcf, 0x55, 0x5f, 0x10,
+	0x6b, 0x64, 0x42, 0x6b, 0x62, 0x33, 0xf6, 0x32, 0xa0, 0xae, 0x72, 0x7e, 0x42, 0x73, 0x7e, 0xdf,
+	0x1e, 0x13, 0xe5, 0x7b, 0xb1, 0xb6, 0xb6, 0x61, 0x2d, 0xb7, 0x81, 0xf3, 0x00, 0xcb, 0xfb, 0x22,
+	0x0e, 0xb0, 0xa4, 0xac, 0x9f, 0xca, 0xb0, 0x51, 0xd8, 0xa4, 0xd1, 0xc7, 0xd0, 0x10, 0xe1, 0x60,
+	0x46, 0x79, 0xb3, 0xba, 0xd5, 0xd9, 0xd9, 0xba, 0xb8, 0xb1, 0xcb, 0x13, 0xac, 0xe4, 0xcc, 0x3d,
+	0xa8, 0x8b, 0x0d, 0x3d, 0x93, 0xca, 0xf3, 0x99, 0xb4, 0x0e, 0x8d, 0x31, 0x09, 0xa9, 0xe7, 0x08,
+	0x4b, 0x17, 0xb0, 0xa2, 0xac, 0x1b, 0xd0, 0x49, 0xf5, 0x7e, 0x74, 0x05, 0xda, 0xa1, 0x37, 0x26,
+	0x2c, 0xb4, 0xc7, 0xd2, 0xbd, 0x55, 0x3c, 0xdb, 0xb0, 0x6e, 0x42, 0x37, 0xdd, 0xf1, 0x2f, 0xe0,
+	0xfe, 0xb3, 0x02, 0x1b, 0x85, 0xad, 0x5d, 0xa5, 0x76, 0x39, 0x49, 0x6d, 0x03, 0x9a, 0x53, 0x42,
+	0xd9, 0xac, 0x10, 0x62, 0x92, 0x67, 0xd7, 0xd8, 0xf6, 0xa3, 0x63, 0xdb, 0x09, 0x23, 0x4a, 0xa8,
+	0x0a, 0x49, 0x66, 0x0f, 0xdd, 0x4e, 0x15, 0x46, 0x67, 0xe7, 0xed, 0x8b, 0x47, 0x0b, 0xad, 0x48,
+	0x58, 0x68, 0x3b, 0xcf, 0xc5, 0xd8, 0xd7, 0xc6, 0x92, 0xe0, 0x0a, 0xd9, 0xae, 0x4b, 0x09, 0x63,
+	0x62, 0x8c, 0x6b, 0xe3, 0x98, 0xe4, 0xb9, 0x31, 0x09, 0xa8, 0x9c, 0xc7, 0xda, 0x58, 0xac, 0xd1,
+	0x55, 0x58, 0x98, 0x50, 0x32, 0xf5, 0x82, 0x88, 0x3d, 0x7e, 0xe9, 0x13, 0x2a, 0x26, 0xac, 0x36,
+	0xce, 0x6e, 0xa2, 0x1e, 0x54, 0x27, 0x9e, 0x2f, 0xc6, 0xa8, 0x36, 0xe6, 0x4b, 0xf3, 0x96, 0x2a,
+	0x45, 0x04, 0x35, 0x27, 0xa0, 0x71, 0x02, 0x89, 0x35, 0xcf, 0xcf, 0x71, 0xe0, 0x7a, 0xc7, 0x1e,
+	0xa1, 0x71, 0x7e, 0xc6, 0xb4, 0xf5, 0x0d, 0x98, 0xc5, 0x53, 0xcd, 0x9c, 0x73, 0x57, 0xa1, 0x1e,
+	0xbc, 0xf4, 0x13, 0x18, 0x49, 0x70, 0x0b, 0x27, 0xc4, 0x77, 0x3d, 0xff, 0x44, 0xf8, 0xb4, 0x85,
+	0x63, 0xd2, 0xba, 0xaf, 0x67, 0x7a, 0x1c, 0xb5, 0x82, 0x4c, 0xe7, 0xfb, 0xfc, 0xe2, 0xf3, 0x42,
+	0xf1, 0x85, 0x16, 0x56, 0x94, 0xf5, 0x57, 0x15, 0xd6, 0xf3, 0x47, 0x19, 0x74, 0x27, 0x03, 0xd5,
+	0xd9, 0xb9, 0x76, 0xc1, 0xec, 0xd3, 0xc7, 0xc4, 0x09, 0xa8, 0x9b, 0xfe, 0xe4, 0x8b, 0x88, 0x44,
+	0x84, 0xd7, 0x6e, 0x75, 0xab, 0x8b, 0x15, 0x85, 0x6e, 0x8b, 0x68, 0x86, 0xbc, 0x74, 0x79, 0x55,
+	0xbd, 0x75, 0x11, 0xac, 0xec, 0xf1, 0x52, 0x86, 0xbb, 0x9c, 0x92, 0x53, 0x62, 0x33, 0xe2, 0x8a,
+	0x5c, 0x6a, 0xe1, 0x84, 0x36, 0x7f, 0x2f, 0x43, 0x43, 0xea, 0xf0, 0x1f, 0x27, 0x6f, 0x1c, 0xfb,
+	0x5a, 0x41, 0xec, 0xeb, 0xd9, 0xd8, 0xcf, 0xf2, 0xb5, 0x51, 0x90, 0xaf, 0xcd, 0xfc, 0x7c, 0x6d,
+	0xcd, 0xf2, 0xd5, 0x7c, 0x00, 0x75, 0x39, 0x6d, 0xf5, 0xa0, 0xfa, 0x9c, 0x9c, 0x29, 0x5b, 0xf8,
+	0x92, 0xc3, 0x4f, 0xed, 0xd3, 0x88, 0xc4, 0xc9, 0x22, 0x08, 0x0e, 0x1f, 0x4d, 0x5c, 0x3b, 0x24,
+	0xb2, 0x1f, 0x55, 0x71, 0x4c, 0x5a, 0x7f, 0x94, 0x61, 0x49, 0x1b, 0x2d, 0x39, 0xb7, 0x7a, 0x64,
+	0xa9, 0x4b, 0x3a, 0x26, 0xd1, 0xfb, 0xd0, 0xa4, 0x84, 0x45, 0xa7, 0x21, 0x13, 0x71, 0x4b, 0xcf,
+	0xbc, 0x1a, 0x48, 0x1f, 0x0b, 0x3e, 0x1c, 0xf3, 0x9b, 0xcf, 0xb8, 0xff, 0xf9, 0xb2, 0x30, 0x0d,
+	0x53, 0x9f, 0xad, 0x64, 0x3f, 0x3b, 0xcb, 0x16, 0x99, 0xea, 0x8a, 0xe2, 0xc6, 0x8a, 0x36, 0xa5,
+	0x9c, 0x2f, 0x09, 0xeb, 0xef, 0x32, 0x2c, 0x66, 0x07, 0x5d, 0x0e, 0x4d, 0x65, 0x11, 0xc4, 0x5d,
+	0x8a, 0xce, 0xd5, 0x44, 0xa5, 0x48, 0x99, 0x6a, 0x56, 0x99, 0xdc, 0x8f, 0x72, 0x1c, 0x69, 0xa9,
+	0x08, 0x78, 0x17, 0x2b, 0x0a, 0xed, 0xc6, 0x09, 0xdd, 0xd8, 0xac, 0x66, 0x5e, 0xa5, 0x59, 0x0d,
+	0x33, 0x89, 0x6c, 0x6e, 0xbf, 0x66, 0x7c, 0xad, 0x5f, 0xcb, 0x80, 0xe6, 0x67, 0xf3, 0x42, 0x4f,
+	0x0f, 0x00, 0xec, 0x30, 0xa4, 0xde, 0x91, 0x68, 0x60, 0x95, 0xdc, 0x52, 0x4b, 0x03, 0xf5, 0xf7,
+	0x62, 0x6e, 0x9c, 0x12, 0xcc, 0x76, 0x90, 0xaa, 0xd6, 0x41, 0xcc, 0x5d, 0x68, 0x27, 0x62, 0xaf,
+	0x6c, 0x48, 0x3f, 0x63, 0x47, 0x7c, 0x71, 0x19, 0xd0, 0x94, 0x9a, 0xcb, 0x6e, 0xdb, 0xc6, 0x31,
+	0x69, 0xfd, 0x50, 0x81, 0x95, 0x9c, 0x47, 0x00, 0xfa, 0x28, 0x2b, 0x31, 0xff, 0x9e, 0xcc, 0xb0,
+	0xc7, 0xc1, 0x88, 0x65, 0xcc, 0x87, 0xff, 0x42, 0xf7, 0xe2, 0x22, 0x33, 0x9f, 0x42, 0x43, 0x7e,
+	0x61, 0xee, 0xee, 0xf9, 0x34, 0x27, 0x12, 0xd7, 0xce, 0x55, 0x35, 0x37, 0x14, 0xd6, 0x75, 0xe8,
+	0xe9, 0x0f, 0x18, 0x1e, 0xfd, 0x30, 0x98, 0x78, 0x4e, 0xec, 0x34, 0x45, 0x59, 0x37, 0x01, 0xcd,
+	0xbf, 0x54, 0x0a, 0xb9, 0x6f, 0xc0, 0xf2, 0xdc, 0x6b, 0xa4, 0x90, 0xf9, 0x97, 0x32, 0x74, 0xd3,
+	0x6f, 0x0f, 0xee, 0x29, 0x71, 0xa4, 0x4c, 0x96, 0x84, 0x2c, 0xae, 0xa3, 0x6f, 0x89, 0x13, 0xc6,
+	0x37, 0xae, 0x22, 0xf9, 0x8c, 0xec, 0xda, 0xa1, 0xad, 0xae, 0xff, 0xcb, 0xb9, 0x0f, 0x9a, 0xfe,
+	0xc0, 0x0f, 0xe9, 0x19, 0x16, 0x8c, 0xd9, 0x1c, 0xac, 0xe9, 0x39, 0xb8, 0x0d, 0x75, 0xc1, 0xfc,
+	0xca, 0xf9, 0xf7, 0x63, 0x19, 0x96, 0xb4, 0x77, 0x10, 0x8f, 0x99, 0x1d, 0xaa, 0x09, 0xa9, 0x62,
+	0x8b, 0x69, 0xd4, 0xa1, 0x49, 0xb3, 0x10, 0x6b, 0x8e, 0xcf, 0x22, 0x5f, 0x35, 0x08, 0xbe, 0x14,
+	0xef, 0x86, 0xe3, 0x63, 0x46, 0x42, 0xa1, 0x55, 0x1d, 0x2b, 0x0a, 0x5d, 0x9f, 0x3d, 0x44, 0xea,
+	0xf9, 0x0f, 0x91, 0xe4, 0x19, 0x62, 0xdd, 0x82, 0x9e, 0xfe, 0xa2, 0x9a, 0xcb, 0x20, 0x3e, 0xeb,
+	0x92, 0xef, 0xa4, 0x23, 0xab, 0x58, 0xac, 0xad, 0xab, 0xd0, 0x4d, 0x3f, 0xa4, 0x44, 0xcf, 0xe1,
+	0x74, 0x1c, 0x05, 0x41, 0x58, 0xdf, 0xc3, 0x42, 0xe6, 0xd1, 0x94, 0xcf, 0x76, 0xce, 0xb5, 0x9c,
+	0xea, 0x06, 0xd5, 0xd7, 0xeb, 0x06, 0x47, 0x0d, 0xf1, 0x2b, 0x73, 0xf7, 0x9f, 0x01, 0x00, 0x53,
+	0xf1, 0x4d, 0x5f, 0xfa, 0x14, 0x00, 0x00,
 }
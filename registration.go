@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultMaxPendingDevices is the number of devices that may
+	// await approval at once unless configured otherwise
+	DefaultMaxPendingDevices = 32
+)
+
+var (
+	// MaxPendingDevices is the number of devices that may await
+	// approval at once. Further registrations are turned away
+	// until some are approved or rejected.
+	MaxPendingDevices = DefaultMaxPendingDevices
+
+	errPendingFull = errors.New("handler: too many devices awaiting approval")
+)
+
+// HandleDeviceRegistrationRequest acknowledges a device that
+// announced itself. Unknown devices, and known devices that moved
+// to another address, port or stack or name a previous owner, are
+// held as pending until they are approved from the console, so a
+// device can't be taken over just by knowing its ID. A known device
+// announcing itself unchanged is updated straight away. A device
+// that was last owned by another definer is claimed from it once
+// approved.
+func (handler *Handler) HandleDeviceRegistrationRequest(packet *packets.Packet, writer io.Writer) error {
+	body := packet.GetDeviceRegistrationReq()
+	Info.Println("handler: received DeviceRegistrationRequest: ", body.String())
+	device, validateErr := deviceFromRegistration(body)
+	if validateErr != nil {
+		Error.Println(handler.SendResponseError(validateErr, packet, writer))
+		return validateErr
+	}
+	registration := &PendingDevice{
+		Device:        device,
+		PIN:           body.Pin,
+		PreviousOwner: body.PreviousOwner,
+		Requested:     time.Now(),
+	}
+	pending := needsApproval(handler.deviceManager.GetDeviceByID(device.ID), registration, handler.router.GetName())
+	if pending {
+		if pendingErr := handler.deviceManager.AddPending(registration); pendingErr != nil {
+			Error.Println(handler.SendResponseError(pendingErr, packet, writer))
+			return pendingErr
+		}
+		Info.Println("handler: device " + device.ID + " is awaiting approval")
+	} else {
+		handler.adoptRegistered(registration)
+	}
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_DeviceRegistrationResponse{
+			DeviceRegistrationResponse: &packets.DeviceRegistrationResponse{
+				Id:      device.ID,
+				Owner:   handler.router.GetName(),
+				Pending: pending,
+			},
+		},
+	}, writer)
+}
+
+// needsApproval reports whether the registration must be approved
+// before the device is adopted. Only a known device announcing
+// itself at the same address, port and stack without naming another
+// owner is let through.
+func needsApproval(existing *Device, registration *PendingDevice, owner string) bool {
+	if existing == nil {
+		return true
+	}
+	device := registration.Device
+	if device.Address != existing.Address || device.Port != existing.Port || device.Stack != existing.Stack {
+		return true
+	}
+	return registration.PreviousOwner != "" && registration.PreviousOwner != owner
+}
+
+// ApproveDevice adopts the pending device with the given ID. The
+// PIN is only checked if the device supplied one.
+func (handler *Handler) ApproveDevice(id string, pin string) error {
+	pending, approveErr := handler.deviceManager.Approve(id, pin)
+	if approveErr != nil {
+		return approveErr
+	}
+	Info.Println("handler: approved device " + id)
+	handler.adoptRegistered(pending)
+	return nil
+}
+
+// adoptRegistered adopts a registered device straight away unless
+// another definer owned it, in which case it is claimed from that
+// definer in the background.
+func (handler *Handler) adoptRegistered(registration *PendingDevice) {
+	if registration.PreviousOwner == "" || registration.PreviousOwner == handler.router.GetName() {
+		handler.adopt(registration.Device, nil)
+		return
+	}
+	handler.deviceManager.RemoveDevice(registration.Device.ID)
+	go handler.ClaimDevice(registration)
+}
+
+// announceTransfer tells every other definer that the current
+// one now owns the device
+func (handler *Handler) announceTransfer(id string) error {
+	handler.publishTransfer(id, handler.router.GetName())
+	return handler.BroadcastProto(&packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin: handler.router.GetName(),
+			Id:     NewPacketID(),
+			Type:   packets.Packet_Header_PASSIVE,
+		},
+		Body: &packets.Packet_DeviceTransfer{
+			DeviceTransfer: &packets.DeviceTransferPassive{
+				Device: id,
+			},
+		},
+	})
+}
+
+// publishTransfer publishes that the device now belongs to the owner
+func (handler *Handler) publishTransfer(id string, owner string) {
+	handler.events.Publish(Event{
+		Topic:   TopicDeviceTransfer,
+		Subject: id,
+		Data:    map[string]string{"owner": owner},
+	})
+}
+
+// deviceFromRegistration validates the registration and
+// builds the Device it describes
+func deviceFromRegistration(body *packets.DeviceRegistrationRequest) (*Device, error) {
+	switch {
+	case body.Id == "":
+		return nil, errors.New("handler: invalid device registration; must include device ID")
+	case body.GetType() == nil || body.GetType().Core == "":
+		return nil, errors.New("handler: invalid device registration; must include device type")
+	case body.Address == "":
+		return nil, errors.New("handler: invalid device registration; must include device address")
+	}
+	if _, stackErr := GetStack(body.Stack); stackErr != nil {
+		return nil, stackErr
+	}
+	return &Device{
+		ID:           body.Id,
+		Version:      body.Version,
+		Manufacturer: body.Manufacturer,
+		Type: &DeviceType{
+			Core:     body.GetType().Core,
+			Modifier: body.GetType().Modifier,
+		},
+		Stack:   body.Stack,
+		Address: body.Address,
+		Port:    body.Port,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// registrationPacket returns a registration of a light at the address
+func registrationPacket(handler *Handler, id string, address string, previousOwner string) *packets.Packet {
+	packet := testPacket(handler, id, packets.Packet_Header_REQUEST)
+	packet.Body = &packets.Packet_DeviceRegistrationReq{DeviceRegistrationReq: &packets.DeviceRegistrationRequest{
+		Id:            id,
+		Type:          &packets.DeviceRegistrationRequest_Type{Core: "light"},
+		Stack:         stackWifi,
+		Address:       address,
+		Port:          "8080",
+		PreviousOwner: previousOwner,
+	}}
+	return packet
+}
+
+func TestDeviceRegistersOnBoot(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	device := make(packetWriter, 1)
+	if handleErr := handler.Handle(registrationPacket(handler, "d1", "10.0.0.2", ""), device); handleErr != nil {
+		t.Fatal(handleErr)
+	}
+	response := (<-device).GetDeviceRegistrationResponse()
+	if response == nil || response.Id != "d1" || response.Owner != "A" || !response.Pending {
+		t.Fatalf("unexpected registration response: %v", response)
+	}
+	pending := handler.deviceManager.GetPending("d1")
+	if pending == nil || pending.Device.Address != "10.0.0.2" || pending.Device.Port != "8080" || pending.Device.Stack != stackWifi || pending.Device.Type.Core != "light" {
+		t.Fatalf("registration wasn't recorded: %+v", pending)
+	}
+
+	invalid := registrationPacket(handler, "", "10.0.0.3", "")
+	if handleErr := handler.Handle(invalid, device); handleErr == nil {
+		t.Fatal("expected a registration without an ID to fail")
+	}
+	if response := <-device; response.GetErrorResponse() == nil {
+		t.Fatalf("expected an error response, got %v", response)
+	}
+}
+
+func TestRegistrationOfKnownDeviceNeedsApprovalToMove(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	if handleErr := handler.Handle(registrationPacket(handler, "d1", "10.0.0.2", ""), ioutil.Discard); handleErr != nil {
+		t.Fatal(handleErr)
+	}
+	if handler.deviceManager.GetPending("d1") == nil || handler.deviceManager.GetDeviceByID("d1") != nil {
+		t.Fatal("unknown device should await approval")
+	}
+	if approveErr := handler.ApproveDevice("d1", ""); approveErr != nil {
+		t.Fatal(approveErr)
+	}
+
+	handler.Handle(registrationPacket(handler, "d1", "10.0.0.2", ""), ioutil.Discard)
+	if handler.deviceManager.GetPending("d1") != nil {
+		t.Fatal("known device announcing itself unchanged shouldn't need approval")
+	}
+	handler.Handle(registrationPacket(handler, "d1", "10.0.0.99", ""), ioutil.Discard)
+	if handler.deviceManager.GetPending("d1") == nil || handler.deviceManager.GetDeviceByID("d1").Address != "10.0.0.2" {
+		t.Fatal("moving a known device should await approval")
+	}
+	handler.deviceManager.Reject("d1")
+	handler.Handle(registrationPacket(handler, "d1", "10.0.0.2", "B"), ioutil.Discard)
+	if handler.deviceManager.GetPending("d1") == nil || handler.deviceManager.GetDeviceByID("d1") == nil {
+		t.Fatal("naming another owner of a known device should await approval")
+	}
+}
+
+func TestPendingDevicesAreCapped(t *testing.T) {
+	maxPending := MaxPendingDevices
+	MaxPendingDevices = 3
+	defer func() { MaxPendingDevices = maxPending }()
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	for i := 0; i < 5; i++ {
+		handler.Handle(registrationPacket(handler, "d"+strconv.Itoa(i), "10.0.0.2", ""), ioutil.Discard)
+	}
+	if pending := handler.deviceManager.PendingDevices(); len(pending) != 3 {
+		t.Fatalf("expected 3 pending devices, got %d", len(pending))
+	}
+	if handleErr := handler.Handle(registrationPacket(handler, "d0", "10.0.0.3", ""), ioutil.Discard); handleErr != nil {
+		t.Fatal("a device already pending should be able to register again: " + handleErr.Error())
+	}
+}
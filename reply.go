@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// TopicDeviceResponse is published when a device's reply to a
+	// command arrives, whether from one of the current definer's
+	// devices or relayed by another definer
+	TopicDeviceResponse = "device.response"
+	// DefaultReplyWindow is how long a device's connection is kept
+	// open after a command so the device can reply on it
+	DefaultReplyWindow = 5 * time.Second
+	// replyRouteTTL is how long the sender of a command is
+	// remembered for replies the device sends later on
+	replyRouteTTL = 10 * time.Minute
+)
+
+var (
+	// ReplyWindow is how long a device may take to reply on the
+	// connection a command arrived on
+	ReplyWindow = DefaultReplyWindow
+)
+
+// ReplyRoutes remembers who sent each command, and the connection
+// it arrived on, so the replies of the devices it targeted can be
+// sent back to them. It is safe for concurrent use.
+type ReplyRoutes struct {
+	lock   sync.Mutex
+	routes map[string]replyRoute
+}
+
+type replyRoute struct {
+	origin  string
+	writer  io.Writer
+	expires time.Time
+}
+
+// BuildReplyRoutes returns an empty ReplyRoutes table
+func BuildReplyRoutes() *ReplyRoutes {
+	return &ReplyRoutes{routes: map[string]replyRoute{}}
+}
+
+// Expect remembers that replies to the request go to the origin,
+// which sent it over the writer
+func (replies *ReplyRoutes) Expect(request string, origin string, writer io.Writer) {
+	replies.lock.Lock()
+	defer replies.lock.Unlock()
+	now := time.Now()
+	for id, route := range replies.routes {
+		if now.After(route.expires) {
+			delete(replies.routes, id)
+		}
+	}
+	replies.routes[request] = replyRoute{origin: origin, writer: writer, expires: now.Add(replyRouteTTL)}
+}
+
+// Route returns who sent the request and the writer it arrived on,
+// if they are still remembered
+func (replies *ReplyRoutes) Route(request string) (string, io.Writer, bool) {
+	replies.lock.Lock()
+	defer replies.lock.Unlock()
+	route, ok := replies.routes[request]
+	if !ok || time.Now().After(route.expires) {
+		return "", nil, false
+	}
+	return route.origin, route.writer, true
+}
+
+// sendToDevice sends the data to the device and keeps the
+// connection open for the reply window to relay its replies
+func (handler *Handler) sendToDevice(device *Device, data []byte) error {
+	conn, dialErr := device.Dial()
+	if dialErr != nil {
+		return dialErr
+	}
+	return handler.sendAndAwaitReplies(device, conn, data)
+}
+
+// sendAndAwaitReplies sends the data over an open connection to
+// the device and relays whatever the device replies on it until
+// the reply window passes. The connection is closed afterwards.
+func (handler *Handler) sendAndAwaitReplies(device *Device, conn StackConn, data []byte) error {
+	if sendErr := conn.Send(data); sendErr != nil {
+		conn.Close()
+		return sendErr
+	}
+	go handler.awaitReplies(device, conn)
+	return nil
+}
+
+// awaitReplies relays the replies the device sends on the connection,
+// and records any state it pushes, until the device closes it or the
+// reply window passes
+func (handler *Handler) awaitReplies(device *Device, conn StackConn) {
+	handler.receiveFromDevice(device, conn, ReplyWindow)
+}
+
+// receiveFromDevice handles what the device sends on the connection
+// until the device closes it, answers a ping or the window passes,
+// then closes it. Anything arriving marks the device as seen. It
+// reports whether the device sent anything at all.
+func (handler *Handler) receiveFromDevice(device *Device, conn StackConn, window time.Duration) bool {
+	timer := time.AfterFunc(window, func() {
+		conn.Close()
+	})
+	defer timer.Stop()
+	defer conn.Close()
+	received := false
+	for {
+		data, receiveErr := conn.Receive()
+		if receiveErr != nil {
+			return received
+		}
+		received = true
+		handler.deviceSeen(device)
+		packet := &packets.Packet{}
+		if unmarshErr := proto.Unmarshal(data, packet); unmarshErr != nil {
+			Warning.Println("reply: unreadable reply from device " + device.ID + ": " + unmarshErr.Error())
+			return received
+		}
+		var replyErr error
+		switch packet.GetBody().(type) {
+		case *packets.Packet_PingResponse:
+			return received
+		case *packets.Packet_DeviceResponse:
+			replyErr = handler.relayDeviceResponse(device, packet)
+		case *packets.Packet_DeviceState:
+			replyErr = handler.recordState(device, packet.GetDeviceState())
+		default:
+			Debug.Println("reply: ignoring packet from device " + device.ID + ": " + packet.String())
+		}
+		if replyErr != nil {
+			Warning.Println(replyErr.Error())
+		}
+	}
+}
+
+// HandleDeviceResponse relays the reply of a device owned by the
+// current definer to whoever sent the command. Replies relayed by
+// other definers for commands sent by the current one are published.
+func (handler *Handler) HandleDeviceResponse(packet *packets.Packet, writer io.Writer) error {
+	if device := handler.deviceManager.GetDeviceByID(packet.GetHeader().Origin); device != nil {
+		return handler.relayDeviceResponse(device, packet)
+	}
+	handler.publishDeviceResponse(packet.GetDeviceResponse())
+	return nil
+}
+
+// publishDeviceResponse logs and publishes the device's reply
+func (handler *Handler) publishDeviceResponse(reply *packets.DeviceResponse) {
+	status := "succeeded"
+	if !reply.Success {
+		status = "failed: " + reply.Error
+	}
+	Info.Println("reply: command #" + reply.Request + " on device " + reply.Device + " " + status)
+	handler.events.Publish(Event{
+		Topic:   TopicDeviceResponse,
+		Subject: reply.Device,
+		Data: map[string]string{
+			"request": reply.Request,
+			"success": strconv.FormatBool(reply.Success),
+			"error":   reply.Error,
+		},
+	})
+}
+
+// relayDeviceResponse wraps the device's reply in a RESPONSE packet
+// and sends it to the sender of the command it answers. Replies for
+// definers are routed to them. Anyone else, such as a phone, is
+// written to over the connection the command arrived on. A reply
+// that doesn't name its command is taken to answer the packet with
+// the same ID. Any state in the reply is recorded first and the
+// reply is published.
+func (handler *Handler) relayDeviceResponse(device *Device, packet *packets.Packet) error {
+	reply := packet.GetDeviceResponse()
+	reply.Device = device.ID
+	if reply.Request == "" {
+		reply.Request = packet.GetHeader().Id
+	}
+	if len(reply.State) > 0 {
+		attributes := map[string]string{}
+		for _, attribute := range reply.State {
+			attributes[attribute.Key] = attribute.Value
+		}
+		if stateErr := handler.updateState(device.ID, attributes, time.Now()); stateErr != nil {
+			Warning.Println(stateErr.Error())
+		}
+	}
+	origin, writer, ok := handler.replies.Route(reply.Request)
+	if !ok {
+		return errors.New("reply: device " + device.ID + " replied to unknown command #" + reply.Request)
+	}
+	if origin != handler.router.GetName() {
+		handler.publishDeviceResponse(reply)
+	}
+	wrapped := &packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin:      handler.router.GetName(),
+			Destination: origin,
+			Id:          NewPacketID(),
+			Type:        packets.Packet_Header_RESPONSE,
+		},
+		Body: &packets.Packet_DeviceResponse{
+			DeviceResponse: reply,
+		},
+	}
+	if handler.isDefiner(origin) {
+		return handler.SendProto(wrapped)
+	}
+	if writer == nil {
+		return errors.New("reply: no way back to " + origin + " for command #" + reply.Request)
+	}
+	return handler.WriteProto(wrapped, writer)
+}
+
+// expectClientReplies remembers the connection a request from a
+// client such as a phone arrived on before it is forwarded to
+// another definer, so whatever comes back for it can be written to
+// the client
+func (handler *Handler) expectClientReplies(packet *packets.Packet, writer io.Writer) {
+	header := packet.GetHeader()
+	if header.Type != packets.Packet_Header_REQUEST || writer == nil || handler.isDefiner(header.Origin) {
+		return
+	}
+	handler.replies.Expect(header.Id, header.Origin, writer)
+}
+
+// deliverToClient writes a response passing through the current
+// definer to the client it is for, if the client sent the request
+// it answers over one of the current definer's connections. Device
+// replies are matched by the command they answer. It reports
+// whether the response was for such a client.
+func (handler *Handler) deliverToClient(packet *packets.Packet) (bool, error) {
+	header := packet.GetHeader()
+	if header.Type != packets.Packet_Header_RESPONSE {
+		return false, nil
+	}
+	request := header.Id
+	if reply := packet.GetDeviceResponse(); reply != nil && reply.Request != "" {
+		request = reply.Request
+	}
+	origin, writer, ok := handler.replies.Route(request)
+	if !ok || origin != header.Destination || writer == nil {
+		return false, nil
+	}
+	return true, handler.WriteProto(packet, writer)
+}
+
+// isDefiner reports whether the name is the current definer or
+// another definer it knows a way to
+func (handler *Handler) isDefiner(name string) bool {
+	if name == handler.router.GetName() || handler.routerManager.GetRouter(name) != nil {
+		return true
+	}
+	_, ok := handler.routerManager.Table.NextHop(name)
+	return ok
+}
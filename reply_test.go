@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ottopress/definer/protos"
+)
+
+func TestDeviceReplyReachesPhoneSession(t *testing.T) {
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Type: &DeviceType{Core: "light"}, Address: "a", Stack: stackMemory})
+	subscription := handler.events.Subscribe(TopicDeviceResponse)
+	phone := make(packetWriter, 4)
+
+	command := testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	command.Body = &packets.Packet_Command{Command: &packets.Command{
+		Device: &packets.Command_Device{Devices: []string{"d1"}},
+		Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: "on"}},
+	}}
+	if handleErr := handler.Handle(command, phone); handleErr != nil {
+		t.Fatal(handleErr)
+	}
+	if response := (<-phone).GetCommandResponse(); response == nil || !response.Success {
+		t.Fatalf("unexpected command response: %v", response)
+	}
+	if _, receiveErr := endpoint.Receive(); receiveErr != nil {
+		t.Fatal(receiveErr)
+	}
+	reply, _ := proto.Marshal(&packets.Packet{
+		Header: &packets.Packet_Header{Origin: "d1", Id: command.GetHeader().Id, Type: packets.Packet_Header_RESPONSE},
+		Body:   &packets.Packet_DeviceResponse{DeviceResponse: &packets.DeviceResponse{Success: true}},
+	})
+	endpoint.Send(reply)
+
+	select {
+	case packet := <-phone:
+		if response := packet.GetDeviceResponse(); response == nil || response.Device != "d1" || response.Request != command.GetHeader().Id {
+			t.Fatalf("unexpected reply: %v", packet)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply never reached the phone")
+	}
+	select {
+	case event := <-subscription.Events():
+		if event.Subject != "d1" || event.Data["request"] != command.GetHeader().Id {
+			t.Fatalf("unexpected event: %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply wasn't published")
+	}
+}
+
+func TestDeviceReplyReachesPhoneThroughAnotherDefiner(t *testing.T) {
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handlerA, handlerB := startTestHandler(t, "A"), startTestHandler(t, "B")
+	handlerA.routerManager.AddRouter(handlerB.router)
+	handlerB.routerManager.AddRouter(handlerA.router)
+	defer handlerA.sessionManager.CloseAll()
+	defer handlerB.sessionManager.CloseAll()
+	handlerB.deviceManager.AddDevice(&Device{ID: "d1", Type: &DeviceType{Core: "light"}, Address: "a", Stack: stackMemory})
+
+	conn, phone := dialPhone(t, handlerA)
+	command := testPacket(handlerB, "phone", packets.Packet_Header_REQUEST)
+	command.Body = &packets.Packet_Command{Command: &packets.Command{
+		Device: &packets.Command_Device{Devices: []string{"d1"}},
+		Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: "on"}},
+	}}
+	writePacket(t, conn, command)
+	next := func(what string) *packets.Packet {
+		select {
+		case packet := <-phone:
+			return packet
+		case <-time.After(time.Second):
+			t.Fatalf("%s never reached the phone", what)
+			return nil
+		}
+	}
+
+	if response := next("the command response").GetCommandResponse(); response == nil || !response.Success {
+		t.Fatalf("unexpected command response: %v", response)
+	}
+	if _, receiveErr := endpoint.Receive(); receiveErr != nil {
+		t.Fatal(receiveErr)
+	}
+	reply, _ := proto.Marshal(&packets.Packet{
+		Header: &packets.Packet_Header{Origin: "d1", Id: command.GetHeader().Id, Type: packets.Packet_Header_RESPONSE},
+		Body:   &packets.Packet_DeviceResponse{DeviceResponse: &packets.DeviceResponse{Success: true}},
+	})
+	endpoint.Send(reply)
+	if packet := next("the reply"); packet.GetDeviceResponse() == nil || packet.GetDeviceResponse().Request != command.GetHeader().Id {
+		t.Fatalf("unexpected reply: %v", packet)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/xml"
+	"errors"
 	"os"
+	"sort"
+	"sync"
 
 	wifimanager "github.com/ottopress/WifiManager"
 )
@@ -16,13 +19,18 @@ var (
 )
 
 // RouterManager manages the other definers the
-// current device can connect to
+// current device can connect to. It is safe for concurrent use.
 type RouterManager struct {
-	XMLName xml.Name `xml:"routers"`
-	Routers map[string]*Router
+	XMLName xml.Name      `xml:"routers"`
+	Table   *RoutingTable `xml:"-"`
+	lock    sync.RWMutex
+	routers map[string]*Router
 }
 
-// Router represents a physical routing device
+// Router represents a physical routing device. Its fields may be
+// changed while packets are being handled, so they should be read
+// and written through its methods. The name of a router added to
+// a RouterManager never changes.
 type Router struct {
 	XMLName   xml.Name                   `xml:"router"`
 	Hostname  string                     `xml:"hostname"`
@@ -32,6 +40,11 @@ type Router struct {
 	Password  string                     `xml:"password"`
 	Setup     bool                       `xml:"setup"`
 	Interface *wifimanager.WifiInterface `xml:"-"`
+	Liveness  Liveness                   `xml:"-"`
+	// Discovered is set on routers found by discovery. They are
+	// left out of the config, so they're found afresh on restart.
+	Discovered bool `xml:"-"`
+	lock       sync.RWMutex
 }
 
 // RouterIdentity is used for more granular router
@@ -43,6 +56,52 @@ type RouterIdentity struct {
 	Modifier string   `xml:"modifier"`
 }
 
+// BuildRouterManager returns a RouterManager without any routers
+func BuildRouterManager() *RouterManager {
+	return &RouterManager{
+		routers: map[string]*Router{},
+		Table:   BuildRoutingTable(),
+	}
+}
+
+// GetRouter returns the router with the given name
+func (manager *RouterManager) GetRouter(name string) *Router {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.routers[name]
+}
+
+// AllRouters returns every router, sorted by name
+func (manager *RouterManager) AllRouters() []*Router {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	routers := make([]*Router, 0, len(manager.routers))
+	for _, router := range manager.routers {
+		routers = append(routers, router)
+	}
+	sort.Slice(routers, func(i, j int) bool {
+		return routers[i].Name < routers[j].Name
+	})
+	return routers
+}
+
+// AddRouter adds the router, replacing any router
+// with the same name
+func (manager *RouterManager) AddRouter(router *Router) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.routers[router.Name] = router
+}
+
+// RemoveRouter forgets the router with the given name
+// along with every route that goes through it
+func (manager *RouterManager) RemoveRouter(name string) {
+	manager.lock.Lock()
+	delete(manager.routers, name)
+	manager.lock.Unlock()
+	manager.Table.RemoveNextHop(name)
+}
+
 // BuildRouter returns an unconfigured Router struct
 func BuildRouter() (*Router, error) {
 	hostname, hostnameErr := os.Hostname()
@@ -57,36 +116,96 @@ func BuildRouter() (*Router, error) {
 	}, nil
 }
 
+// GetName returns the name of the router
+func (router *Router) GetName() string {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
+	return router.Name
+}
+
+// GetAddress returns the hostname and port the router
+// can be reached at
+func (router *Router) GetAddress() (string, int) {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
+	return router.Hostname, router.Port
+}
+
+// SetAddress updates the hostname and port the router
+// can be reached at
+func (router *Router) SetAddress(hostname string, port int) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	router.Hostname = hostname
+	router.Port = port
+}
+
+// GetNetwork returns the SSID and password of the network
+// the router connects to
+func (router *Router) GetNetwork() (string, string) {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
+	return router.SSID, router.Password
+}
+
+// Configure updates the network and name of the router
+// along with its setup status
+func (router *Router) Configure(ssid string, password string, name string) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	router.SSID = ssid
+	router.Password = password
+	router.Name = name
+	router.updateSetup()
+}
+
 // IsSetup checks that the router has been properly
 // configured.
 func (router *Router) IsSetup() bool {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
 	return router.Setup
 }
 
 // UpdateSetup checks the required fields and updates
 // the setup field to reflect their status
 func (router *Router) UpdateSetup() {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	router.updateSetup()
+}
+
+// updateSetup does the work of UpdateSetup. The caller
+// must hold the write lock.
+func (router *Router) updateSetup() {
 	if router.SSID != "" || router.Name != "" {
 		router.Setup = true
 	}
 }
 
+func (router *Router) setSetup(setup bool) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	router.Setup = setup
+}
+
 // Initialize the Router and it's connection
 func (router *Router) Initialize() error {
 	routerInitErr := router.InitInterface()
 	if routerInitErr != nil {
-		router.Setup = false
+		router.setSetup(false)
 		return routerInitErr
 	}
+	ssid, _ := router.GetNetwork()
 	Info.Println("Router interface successfully initialized.")
-	Info.Println("Preparing to connect to \"" + router.SSID + "\"...")
+	Info.Println("Preparing to connect to \"" + ssid + "\"...")
 	routerConnErr := router.Connect()
 	if routerConnErr != nil {
 		Debug.Println(routerConnErr)
 		return routerConnErr
 	}
 	Info.Println("Connection successful!")
-	router.Setup = true
+	router.setSetup(true)
 	return nil
 }
 
@@ -96,7 +215,12 @@ func (router *Router) InitInterface() error {
 	if interfacesErr != nil {
 		return interfacesErr
 	}
+	if len(interfaces) == 0 {
+		return errors.New("router: no wifi interfaces found")
+	}
 	iface := interfaces[0]
+	router.lock.Lock()
+	defer router.lock.Unlock()
 	router.Interface = &iface
 	return nil
 }
@@ -106,25 +230,29 @@ func (router *Router) InitInterface() error {
 // 'Initialize' phase.
 func (router *Router) Connect() error {
 	Debug.Println("Connecting...")
-	status, statusErr := router.Interface.Status()
+	router.lock.RLock()
+	iface := router.Interface
+	router.lock.RUnlock()
+	ssid, password := router.GetNetwork()
+	status, statusErr := iface.Status()
 	if statusErr != nil {
 		return statusErr
 	}
 	Debug.Println("Got status:", status)
 	if !status {
-		upErr := router.Interface.Up()
+		upErr := iface.Up()
 		if upErr != nil {
 			return upErr
 		}
 	}
 	Debug.Println("Starting Scan")
-	networks, networksErr := router.Interface.Scan()
+	networks, networksErr := iface.Scan()
 	if networksErr != nil {
 		return networksErr
 	}
 	Debug.Println("Ending Scan")
 	Debug.Println("Got networks:", networks)
-	accessPoints, accessPointsErr := wifimanager.GetAPs(router.SSID, networks)
+	accessPoints, accessPointsErr := wifimanager.GetAPs(ssid, networks)
 	if accessPointsErr != nil {
 		return accessPointsErr
 	}
@@ -133,13 +261,13 @@ func (router *Router) Connect() error {
 		return accessPointErr
 	}
 	Debug.Println("Got AP:", accessPoint)
-	accessPoint.UpdateSecurityKey(router.Password)
-	router.Interface.UpdateNetwork(accessPoint)
-	disconnectErr := router.Interface.Disconnect()
+	accessPoint.UpdateSecurityKey(password)
+	iface.UpdateNetwork(accessPoint)
+	disconnectErr := iface.Disconnect()
 	if disconnectErr != nil {
 		return disconnectErr
 	}
-	connectErr := router.Interface.Connect()
+	connectErr := iface.Connect()
 	if connectErr != nil {
 		return connectErr
 	}
@@ -160,20 +288,41 @@ func (container *RouterManager) UnmarshalXML(decoder *xml.Decoder, start xml.Sta
 	for _, router := range tempContainer.Routers {
 		tempRouters[router.Name] = router
 	}
-	*container = RouterManager{tempContainer.XMLName, tempRouters}
+	container.XMLName = tempContainer.XMLName
+	container.routers = tempRouters
+	container.Table = BuildRoutingTable()
 	return nil
 }
 
 // MarshalXML is overridden to ensure that the Routers map
-// is saved properly
+// is saved properly. Discovered routers aren't saved.
 func (container *RouterManager) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
-	tempRouterList := []*Router{}
-	for _, router := range container.Routers {
-		tempRouterList = append(tempRouterList, router)
+	configured := []*Router{}
+	for _, router := range container.AllRouters() {
+		if !router.Discovered {
+			configured = append(configured, router)
+		}
 	}
 	tempContainer := struct {
 		XMLName xml.Name  `xml:"routers"`
 		Routers []*Router `xml:"router"`
-	}{container.XMLName, tempRouterList}
+	}{container.XMLName, configured}
 	return encoder.EncodeElement(tempContainer, start)
 }
+
+// MarshalXML is overridden so the router's fields are
+// read under its lock
+func (router *Router) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	router.lock.RLock()
+	tempRouter := struct {
+		XMLName  xml.Name `xml:"router"`
+		Hostname string   `xml:"hostname"`
+		Name     string   `xml:"name,attr"`
+		Port     int      `xml:"port"`
+		SSID     string   `xml:"ssid"`
+		Password string   `xml:"password"`
+		Setup    bool     `xml:"setup"`
+	}{router.XMLName, router.Hostname, router.Name, router.Port, router.SSID, router.Password, router.Setup}
+	router.lock.RUnlock()
+	return encoder.EncodeElement(tempRouter, start)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultAdvertiseInterval is how often routes are advertised
+	// to neighbors unless configured otherwise
+	DefaultAdvertiseInterval = 30 * time.Second
+	// RouteInfinity is the metric of an unreachable destination.
+	// Advertisements can't grow a route past it, which bounds
+	// counting to infinity when a router disappears.
+	RouteInfinity = 16
+	// routeTimeoutIntervals is the number of advertisement intervals
+	// a route may go without being refreshed before it expires
+	routeTimeoutIntervals = 3
+)
+
+var (
+	// AdvertiseInterval is how often the current definer
+	// advertises its routing table to its neighbors
+	AdvertiseInterval = DefaultAdvertiseInterval
+)
+
+// RoutingTable holds the best known next hop for every router
+// reachable from the current definer. It is safe for concurrent use.
+type RoutingTable struct {
+	lock   sync.RWMutex
+	routes map[string]*Route
+}
+
+// Route is a single entry in the RoutingTable
+type Route struct {
+	Destination string
+	NextHop     string
+	Metric      int
+	Updated     time.Time
+}
+
+// BuildRoutingTable returns an empty RoutingTable
+func BuildRoutingTable() *RoutingTable {
+	return &RoutingTable{routes: map[string]*Route{}}
+}
+
+// NextHop returns the neighbor packets for the destination
+// should be forwarded to, if a route to it is known.
+func (table *RoutingTable) NextHop(destination string) (string, bool) {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	route, ok := table.routes[destination]
+	if !ok || route.Metric >= RouteInfinity {
+		return "", false
+	}
+	return route.NextHop, true
+}
+
+// Update merges an advertisement received from a neighbor into
+// the table. Routes through the neighbor always take its latest
+// metric; other routes are only replaced by strictly better ones.
+// It reports whether the table changed.
+func (table *RoutingTable) Update(local string, neighbor string, advertised []*packets.RouteAdvertisementPassive_Route) bool {
+	now := time.Now()
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	changed := table.offer(neighbor, neighbor, 1, now)
+	for _, entry := range advertised {
+		if entry.Destination == "" || entry.Destination == local || entry.Destination == neighbor {
+			continue
+		}
+		metric := int(entry.Metric) + 1
+		if metric > RouteInfinity {
+			metric = RouteInfinity
+		}
+		if table.offer(entry.Destination, neighbor, metric, now) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// offer considers a route to the destination through the next hop.
+// The caller must hold the write lock.
+func (table *RoutingTable) offer(destination string, nextHop string, metric int, now time.Time) bool {
+	route, ok := table.routes[destination]
+	switch {
+	case !ok:
+		if metric >= RouteInfinity {
+			return false
+		}
+		table.routes[destination] = &Route{Destination: destination, NextHop: nextHop, Metric: metric, Updated: now}
+		return true
+	case route.NextHop == nextHop:
+		changed := route.Metric != metric
+		route.Metric = metric
+		route.Updated = now
+		return changed
+	case metric < route.Metric:
+		route.NextHop = nextHop
+		route.Metric = metric
+		route.Updated = now
+		return true
+	}
+	return false
+}
+
+// RemoveNextHop drops every route that goes through the neighbor
+func (table *RoutingTable) RemoveNextHop(neighbor string) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for destination, route := range table.routes {
+		if route.NextHop == neighbor {
+			delete(table.routes, destination)
+		}
+	}
+}
+
+// Expire drops every route that hasn't been refreshed within
+// the timeout
+func (table *RoutingTable) Expire(timeout time.Duration) {
+	cutoff := time.Now().Add(-timeout)
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for destination, route := range table.routes {
+		if route.Updated.Before(cutoff) {
+			delete(table.routes, destination)
+		}
+	}
+}
+
+// Advertisement lists the routes to send to the given neighbor.
+// Routes learned through the neighbor are poisoned so it never
+// tries to route back through the current definer.
+func (table *RoutingTable) Advertisement(neighbor string) []*packets.RouteAdvertisementPassive_Route {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	advertised := []*packets.RouteAdvertisementPassive_Route{}
+	for destination, route := range table.routes {
+		if destination == neighbor {
+			continue
+		}
+		metric := route.Metric
+		if route.NextHop == neighbor {
+			metric = RouteInfinity
+		}
+		advertised = append(advertised, &packets.RouteAdvertisementPassive_Route{
+			Destination: destination,
+			Metric:      uint32(metric),
+		})
+	}
+	return advertised
+}
+
+// Routes returns a copy of every route in the table, sorted by
+// destination
+func (table *RoutingTable) Routes() []Route {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	routes := []Route{}
+	for _, route := range table.routes {
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Destination < routes[j].Destination
+	})
+	return routes
+}
+
+// AdvertiseRoutes periodically sends the routing table to every
+// known router and expires routes that have gone stale. It never
+// returns.
+func (handler *Handler) AdvertiseRoutes() {
+	ticker := time.NewTicker(AdvertiseInterval)
+	defer ticker.Stop()
+	for {
+		handler.routerManager.Table.Expire(routeTimeoutIntervals * AdvertiseInterval)
+		for _, neighbor := range handler.routerManager.AllRouters() {
+			advertisement := &packets.Packet{
+				Header: &packets.Packet_Header{
+					Origin:      handler.router.GetName(),
+					Destination: neighbor.Name,
+					Id:          NewPacketID(),
+					Type:        packets.Packet_Header_PASSIVE,
+				},
+				Body: &packets.Packet_RouteAdvertisement{
+					RouteAdvertisement: &packets.RouteAdvertisementPassive{
+						Routes: handler.routerManager.Table.Advertisement(neighbor.Name),
+					},
+				},
+			}
+			hostname, port := neighbor.GetAddress()
+			writeErr := handler.WriteProtoToDest(hostname, port, advertisement)
+			if writeErr != nil {
+				Debug.Println("routing: couldn't advertise routes to " + neighbor.Name + ": " + writeErr.Error())
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// advertised returns the routes as they are advertised
+func advertised(metrics map[string]uint32) []*packets.RouteAdvertisementPassive_Route {
+	routes := []*packets.RouteAdvertisementPassive_Route{}
+	for destination, metric := range metrics {
+		routes = append(routes, &packets.RouteAdvertisementPassive_Route{Destination: destination, Metric: metric})
+	}
+	return routes
+}
+
+// advertisedMetric returns the metric advertised for the destination
+func advertisedMetric(routes []*packets.RouteAdvertisementPassive_Route, destination string) (uint32, bool) {
+	for _, route := range routes {
+		if route.Destination == destination {
+			return route.Metric, true
+		}
+	}
+	return 0, false
+}
+
+func TestRoutingTableLearnsShorterPaths(t *testing.T) {
+	table := BuildRoutingTable()
+	if !table.Update("A", "B", advertised(map[string]uint32{"D": 3})) {
+		t.Fatal("expected the table to change")
+	}
+	if nextHop, ok := table.NextHop("D"); !ok || nextHop != "B" {
+		t.Fatalf("expected D through B, got %s", nextHop)
+	}
+	table.Update("A", "C", advertised(map[string]uint32{"D": 1, "A": 1}))
+	if nextHop, _ := table.NextHop("D"); nextHop != "C" {
+		t.Fatalf("expected the shorter path through C, got %s", nextHop)
+	}
+	if table.Update("A", "B", advertised(map[string]uint32{"D": 3})) {
+		t.Fatal("a longer path shouldn't replace a shorter one")
+	}
+	if _, ok := table.NextHop("A"); ok {
+		t.Fatal("the table shouldn't route to the current definer")
+	}
+	for _, route := range table.Routes() {
+		if route.Destination == "D" && route.Metric != 2 {
+			t.Fatalf("expected D two hops away, got %d", route.Metric)
+		}
+	}
+}
+
+func TestRoutingTablePoisonsReverseRoutes(t *testing.T) {
+	table := BuildRoutingTable()
+	table.Update("A", "B", advertised(map[string]uint32{"D": 1}))
+	table.Update("A", "C", advertised(nil))
+	if metric, ok := advertisedMetric(table.Advertisement("B"), "D"); !ok || metric != RouteInfinity {
+		t.Fatalf("route through B should be advertised back to it as unreachable, got %d", metric)
+	}
+	if metric, _ := advertisedMetric(table.Advertisement("C"), "D"); metric != 2 {
+		t.Fatalf("expected D advertised to C two hops away, got %d", metric)
+	}
+	if _, ok := advertisedMetric(table.Advertisement("B"), "B"); ok {
+		t.Fatal("a neighbor shouldn't be advertised to itself")
+	}
+}
+
+func TestRoutingTableWithdrawsRoutes(t *testing.T) {
+	table := BuildRoutingTable()
+	table.Update("A", "B", advertised(map[string]uint32{"D": 1}))
+	if !table.Update("A", "B", advertised(map[string]uint32{"D": RouteInfinity})) {
+		t.Fatal("expected the withdrawal to change the table")
+	}
+	if _, ok := table.NextHop("D"); ok {
+		t.Fatal("a withdrawn route shouldn't be used")
+	}
+	if metric, _ := advertisedMetric(table.Advertisement("C"), "D"); metric != RouteInfinity {
+		t.Fatalf("expected the withdrawal to be passed on, got %d", metric)
+	}
+	table.Update("A", "C", advertised(map[string]uint32{"D": RouteInfinity - 1}))
+	if _, ok := table.NextHop("D"); ok {
+		t.Fatal("a route can't grow past infinity")
+	}
+	table.Update("A", "C", advertised(map[string]uint32{"D": 4}))
+	if nextHop, ok := table.NextHop("D"); !ok || nextHop != "C" {
+		t.Fatalf("expected D through C again, got %s", nextHop)
+	}
+	table.RemoveNextHop("C")
+	if _, ok := table.NextHop("D"); ok {
+		t.Fatal("routes through a removed neighbor should be dropped")
+	}
+	table.Update("A", "B", advertised(map[string]uint32{"D": 1}))
+	table.Expire(-time.Second)
+	if routes := table.Routes(); len(routes) != 0 {
+		t.Fatalf("expected stale routes to expire, got %v", routes)
+	}
+}
+
+func TestForwardingFallsBackToBroadcast(t *testing.T) {
+	portB, receivedB := packetListener(t)
+	portC, receivedC := packetListener(t)
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.routerManager.AddRouter(&Router{Name: "B", Hostname: "127.0.0.1", Port: portB})
+	handler.routerManager.AddRouter(&Router{Name: "C", Hostname: "127.0.0.1", Port: portC})
+	defer handler.sessionManager.CloseAll()
+	handler.routerManager.Table.Update("A", "B", advertised(map[string]uint32{"D": 1}))
+	send := func(destination string) {
+		packet := &packets.Packet{
+			Header: &packets.Packet_Header{Origin: "X", Destination: destination, Id: destination, Type: packets.Packet_Header_PASSIVE},
+		}
+		if handleErr := handler.Handle(packet, ioutil.Discard); handleErr != nil {
+			t.Fatal(handleErr)
+		}
+	}
+
+	send("D")
+	<-receivedB
+	select {
+	case packet := <-receivedC:
+		t.Fatalf("packet with a known route was broadcast: %v", packet)
+	case <-time.After(20 * time.Millisecond):
+	}
+	send("E")
+	<-receivedB
+	<-receivedC
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	triggerState    = "state"
+	triggerLiveness = "liveness"
+	triggerTime     = "time"
+	triggerPacket   = "packet"
+
+	conditionState = "state"
+	conditionTime  = "time"
+
+	// clockFormat is how times of day are written in rules
+	clockFormat = "15:04"
+	// ruleTick is how often time of day triggers are checked
+	ruleTick = 15 * time.Second
+	// ruleActionTimeout is how long each command sent by a rule
+	// has to be carried out
+	ruleActionTimeout = DefaultRequestTimeout
+)
+
+var (
+	// RulesPath is the file automation rules are kept in
+	RulesPath = "./rules.xml"
+
+	// ruleOperators compare a device's state with a condition's
+	// value. Values that are both numbers are compared as numbers.
+	ruleOperators = map[string]func(int) bool{
+		"eq": func(order int) bool { return order == 0 },
+		"ne": func(order int) bool { return order != 0 },
+		"lt": func(order int) bool { return order < 0 },
+		"le": func(order int) bool { return order <= 0 },
+		"gt": func(order int) bool { return order > 0 },
+		"ge": func(order int) bool { return order >= 0 },
+	}
+)
+
+// Rule is an automation carried out by the definer on its own. When
+// any of its triggers fires and all of its conditions hold, each of
+// its actions is carried out in turn.
+type Rule struct {
+	XMLName    xml.Name         `xml:"rule"`
+	Name       string           `xml:"name,attr"`
+	Disabled   bool             `xml:"disabled,attr,omitempty"`
+	Triggers   []*RuleTrigger   `xml:"trigger"`
+	Conditions []*RuleCondition `xml:"condition"`
+	Actions    []*RuleAction    `xml:"action"`
+}
+
+// RuleTrigger fires a rule when a device reports a state (optionally
+// a particular value of an attribute), goes online or offline, at a
+// time of day, or when a kind of packet is received. Leaving out the
+// device matches every device.
+type RuleTrigger struct {
+	XMLName   xml.Name `xml:"trigger"`
+	Kind      string   `xml:"kind,attr"`
+	Device    string   `xml:"device,attr,omitempty"`
+	Attribute string   `xml:"attribute,attr,omitempty"`
+	Value     string   `xml:"value,attr,omitempty"`
+	At        string   `xml:"at,attr,omitempty"`
+	Packet    string   `xml:"packet,attr,omitempty"`
+}
+
+// RuleCondition checks an attribute of a device's last reported
+// state against a value, or that the time of day falls within a
+// window. Windows may wrap around midnight.
+type RuleCondition struct {
+	XMLName   xml.Name `xml:"condition"`
+	Kind      string   `xml:"kind,attr"`
+	Device    string   `xml:"device,attr,omitempty"`
+	Attribute string   `xml:"attribute,attr,omitempty"`
+	Operator  string   `xml:"op,attr,omitempty"`
+	Value     string   `xml:"value,attr,omitempty"`
+	After     string   `xml:"after,attr,omitempty"`
+	Before    string   `xml:"before,attr,omitempty"`
+}
+
+// RuleAction sends a command to the devices it targets, or runs a
+// scene, either on the current definer or on the named one
+type RuleAction struct {
+	XMLName xml.Name        `xml:"action"`
+	Definer string          `xml:"definer,attr,omitempty"`
+	Scene   string          `xml:"scene,attr,omitempty"`
+	Execute *CommandExecute `xml:"execute"`
+	DeviceTarget
+}
+
+// RuleSet holds the automation rules keyed by name and keeps them
+// in a file. It is safe for concurrent use.
+type RuleSet struct {
+	lock  sync.RWMutex
+	path  string
+	rules map[string]*Rule
+}
+
+type ruleFile struct {
+	XMLName xml.Name `xml:"rules"`
+	Rules   []*Rule  `xml:"rule"`
+}
+
+// BuildRuleSet returns an empty RuleSet kept in the file at the
+// path. An empty path keeps the rules in memory only.
+func BuildRuleSet(path string) *RuleSet {
+	return &RuleSet{path: path, rules: map[string]*Rule{}}
+}
+
+// Load reads the rules from the file, replacing those with the
+// same names. Invalid rules are dropped with a warning. A missing
+// file isn't an error.
+func (rules *RuleSet) Load() error {
+	if rules.path == "" {
+		return nil
+	}
+	fileData, readErr := ioutil.ReadFile(rules.path)
+	if os.IsNotExist(readErr) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+	file := &ruleFile{}
+	if unmarshErr := xml.Unmarshal(fileData, file); unmarshErr != nil {
+		return unmarshErr
+	}
+	rules.lock.Lock()
+	defer rules.lock.Unlock()
+	for _, rule := range file.Rules {
+		if validateErr := rule.Validate(); validateErr != nil {
+			Warning.Println("rules: dropping rule " + rule.Name + ": " + validateErr.Error())
+			continue
+		}
+		rules.rules[rule.Name] = rule
+	}
+	return nil
+}
+
+// save writes the rules to the file. The caller must hold the lock.
+func (rules *RuleSet) save() error {
+	if rules.path == "" {
+		return nil
+	}
+	file := &ruleFile{Rules: rules.sorted()}
+	fileData, marshErr := xml.MarshalIndent(file, "", "    ")
+	if marshErr != nil {
+		return marshErr
+	}
+	return ioutil.WriteFile(rules.path, fileData, 0644)
+}
+
+// Add validates the rule and adds it, replacing any rule with the
+// same name
+func (rules *RuleSet) Add(rule *Rule) error {
+	if validateErr := rule.Validate(); validateErr != nil {
+		return validateErr
+	}
+	rules.lock.Lock()
+	defer rules.lock.Unlock()
+	rules.rules[rule.Name] = rule
+	return rules.save()
+}
+
+// Remove deletes the rule with the given name
+func (rules *RuleSet) Remove(name string) error {
+	rules.lock.Lock()
+	defer rules.lock.Unlock()
+	if _, ok := rules.rules[name]; !ok {
+		return errors.New("rules: no rule " + name)
+	}
+	delete(rules.rules, name)
+	return rules.save()
+}
+
+// SetEnabled turns the rule with the given name on or off
+func (rules *RuleSet) SetEnabled(name string, enabled bool) error {
+	rules.lock.Lock()
+	defer rules.lock.Unlock()
+	rule, ok := rules.rules[name]
+	if !ok {
+		return errors.New("rules: no rule " + name)
+	}
+	// Rules are replaced rather than changed so that those
+	// already handed out can be read without the lock
+	updated := *rule
+	updated.Disabled = !enabled
+	rules.rules[name] = &updated
+	return rules.save()
+}
+
+// All returns every rule, sorted by name
+func (rules *RuleSet) All() []*Rule {
+	rules.lock.RLock()
+	defer rules.lock.RUnlock()
+	return rules.sorted()
+}
+
+// sorted returns every rule sorted by name. The caller must hold the lock.
+func (rules *RuleSet) sorted() []*Rule {
+	sorted := make([]*Rule, 0, len(rules.rules))
+	for _, rule := range rules.rules {
+		sorted = append(sorted, rule)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// Validate checks that the rule is complete and can be carried out
+func (rule *Rule) Validate() error {
+	if rule.Name == "" {
+		return errors.New("rules: rule must have a name")
+	}
+	if len(rule.Triggers) == 0 || len(rule.Actions) == 0 {
+		return errors.New("rules: rule " + rule.Name + " needs at least one trigger and one action")
+	}
+	for _, trigger := range rule.Triggers {
+		switch trigger.Kind {
+		case triggerState, triggerLiveness:
+		case triggerTime:
+			if _, parseErr := time.Parse(clockFormat, trigger.At); parseErr != nil {
+				return errors.New("rules: rule " + rule.Name + " has a time trigger without a valid time, expected HH:MM")
+			}
+		case triggerPacket:
+			if trigger.Packet == "" {
+				return errors.New("rules: rule " + rule.Name + " has a packet trigger without a packet")
+			}
+		default:
+			return errors.New("rules: rule " + rule.Name + " has an unknown trigger \"" + trigger.Kind + "\"")
+		}
+	}
+	for _, condition := range rule.Conditions {
+		switch condition.Kind {
+		case conditionState:
+			if condition.Device == "" || condition.Attribute == "" {
+				return errors.New("rules: rule " + rule.Name + " has a state condition without a device and attribute")
+			}
+			if _, ok := ruleOperators[condition.operator()]; !ok {
+				return errors.New("rules: rule " + rule.Name + " has an unknown operator \"" + condition.Operator + "\"")
+			}
+		case conditionTime:
+			for _, clock := range []string{condition.After, condition.Before} {
+				if _, parseErr := time.Parse(clockFormat, clock); clock != "" && parseErr != nil {
+					return errors.New("rules: rule " + rule.Name + " has a time condition with an invalid time, expected HH:MM")
+				}
+			}
+		default:
+			return errors.New("rules: rule " + rule.Name + " has an unknown condition \"" + condition.Kind + "\"")
+		}
+	}
+	for _, action := range rule.Actions {
+		if action.Scene != "" {
+			if !action.DeviceTarget.empty() || action.Execute != nil {
+				return errors.New("rules: rule " + rule.Name + " has an action with both a scene and a command")
+			}
+			continue
+		}
+		if action.DeviceTarget.empty() || action.Execute == nil || action.Execute.Core == "" {
+			return errors.New("rules: rule " + rule.Name + " has an action without a scene or a target and command")
+		}
+	}
+	return nil
+}
+
+// matches reports whether the event fires the trigger
+func (trigger *RuleTrigger) matches(event Event) bool {
+	if trigger.Device != "" && trigger.Device != event.Subject && trigger.Kind != triggerPacket {
+		return false
+	}
+	switch trigger.Kind {
+	case triggerState:
+		if event.Topic != TopicDeviceState {
+			return false
+		}
+		if trigger.Attribute == "" {
+			return true
+		}
+		value, ok := event.Data[trigger.Attribute]
+		return ok && (trigger.Value == "" || trigger.Value == value)
+	case triggerLiveness:
+		return event.Topic == TopicDeviceLiveness && (trigger.Value == "" || trigger.Value == event.Data["state"])
+	case triggerPacket:
+		return event.Topic == TopicPacket && strings.EqualFold(trigger.Packet, event.Subject) &&
+			(trigger.Device == "" || trigger.Device == event.Data["origin"])
+	}
+	return false
+}
+
+func (condition *RuleCondition) operator() string {
+	if condition.Operator == "" {
+		return "eq"
+	}
+	return condition.Operator
+}
+
+// holds reports whether the condition holds at the given time
+func (condition *RuleCondition) holds(manager *DeviceManager, now time.Time) bool {
+	switch condition.Kind {
+	case conditionState:
+		value, ok := manager.GetState(condition.Device)[condition.Attribute]
+		return ok && ruleOperators[condition.operator()](compareValues(value.Value, condition.Value))
+	case conditionTime:
+		minute := dayMinute(now)
+		after, before := clockMinute(condition.After, 0), clockMinute(condition.Before, 24*60)
+		if after <= before {
+			return minute >= after && minute < before
+		}
+		return minute >= after || minute < before
+	}
+	return false
+}
+
+// compareValues orders the two values, numerically if both are numbers
+func compareValues(a string, b string) int {
+	numberA, errA := strconv.ParseFloat(a, 64)
+	numberB, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case numberA < numberB:
+			return -1
+		case numberA > numberB:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// clockMinute returns the minute of the day of an HH:MM time, or
+// the fallback if none is given
+func clockMinute(clock string, fallback int) int {
+	parsed, parseErr := time.Parse(clockFormat, clock)
+	if parseErr != nil {
+		return fallback
+	}
+	return dayMinute(parsed)
+}
+
+// dayMinute returns the minute of the day of the time
+func dayMinute(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// RunRules carries out the automation rules as their triggers fire.
+// It never returns.
+func (handler *Handler) RunRules() {
+	subscription := handler.events.Subscribe(TopicDeviceState, TopicDeviceLiveness, TopicPacket)
+	ticker := time.NewTicker(ruleTick)
+	defer ticker.Stop()
+	lastMinute := dayMinute(time.Now())
+	for {
+		select {
+		case event := <-subscription.Events():
+			handler.fireRules(func(trigger *RuleTrigger) bool {
+				return trigger.matches(event)
+			})
+		case now := <-ticker.C:
+			minute := dayMinute(now)
+			if minute == lastMinute {
+				continue
+			}
+			lastMinute = minute
+			handler.fireRules(func(trigger *RuleTrigger) bool {
+				return trigger.Kind == triggerTime && clockMinute(trigger.At, -1) == minute
+			})
+		}
+	}
+}
+
+// fireRules carries out every enabled rule with a trigger that
+// fired and whose conditions all hold
+func (handler *Handler) fireRules(fired func(*RuleTrigger) bool) {
+	now := time.Now()
+	for _, rule := range handler.rules.All() {
+		if rule.Disabled || !rule.firedBy(fired) || !rule.holds(handler.deviceManager, now) {
+			continue
+		}
+		Info.Println("rules: running rule " + rule.Name)
+		go handler.RunRuleActions(rule)
+	}
+}
+
+func (rule *Rule) firedBy(fired func(*RuleTrigger) bool) bool {
+	for _, trigger := range rule.Triggers {
+		if fired(trigger) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) holds(manager *DeviceManager, now time.Time) bool {
+	for _, condition := range rule.Conditions {
+		if !condition.holds(manager, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// RunRuleActions carries out each of the rule's actions in turn,
+// logging any that fail. Scenes on the current definer are run
+// directly and scenes on other definers are started without waiting
+// for them, so their delays don't count against the action timeout.
+func (handler *Handler) RunRuleActions(rule *Rule) {
+	for _, action := range rule.Actions {
+		if action.Scene != "" && (action.Definer == "" || action.Definer == handler.router.GetName()) {
+			handler.runRuleScene(rule, action.Scene)
+			continue
+		}
+		if action.Scene != "" {
+			handler.runRemoteRuleScene(rule, action)
+			continue
+		}
+		response, requestErr := handler.SendRequest(action.packet(), ruleActionTimeout)
+		if requestErr != nil {
+			Error.Println("rules: rule " + rule.Name + " couldn't run " + action.describe() + ": " + requestErr.Error())
+			continue
+		}
+		if result := response.GetCommandResponse(); result != nil && !result.Success {
+			Warning.Println("rules: rule " + rule.Name + " ran " + action.describe() + " but not every device accepted it: " + result.String())
+		}
+	}
+}
+
+// runRemoteRuleScene asks another definer to run the scene without
+// waiting for it to finish, since only that definer knows how long
+// the scene's steps take. Its SceneResponse is logged on arrival.
+func (handler *Handler) runRemoteRuleScene(rule *Rule, action *RuleAction) {
+	packet := action.packet()
+	packet.Header.Origin = handler.router.GetName()
+	packet.Header.Id = NewPacketID()
+	packet.Header.Type = packets.Packet_Header_REQUEST
+	if sendErr := handler.SendProto(packet); sendErr != nil {
+		Error.Println("rules: rule " + rule.Name + " couldn't run " + action.describe() + " on " + action.Definer + ": " + sendErr.Error())
+		return
+	}
+	Info.Println("rules: rule " + rule.Name + " asked " + action.Definer + " to run " + action.describe())
+}
+
+func (handler *Handler) runRuleScene(rule *Rule, scene string) {
+	result, sceneErr := handler.RunScene(scene)
+	if sceneErr != nil {
+		Error.Println("rules: rule " + rule.Name + " couldn't run scene " + scene + ": " + sceneErr.Error())
+		return
+	}
+	if !result.Success {
+		Warning.Println("rules: rule " + rule.Name + " ran scene " + scene + " but not every device accepted it: " + result.String())
+	}
+}
+
+// packet builds the request sent by the action
+func (action *RuleAction) packet() *packets.Packet {
+	header := &packets.Packet_Header{
+		Destination: action.Definer,
+	}
+	if action.Scene != "" {
+		return &packets.Packet{
+			Header: header,
+			Body: &packets.Packet_SceneReq{
+				SceneReq: &packets.SceneRequest{
+					Scene: action.Scene,
+				},
+			},
+		}
+	}
+	return &packets.Packet{
+		Header: header,
+		Body: &packets.Packet_Command{
+			Command: buildExecuteCommand(&action.DeviceTarget, action.Execute),
+		},
+	}
+}
+
+// describe names what the action carries out for logging
+func (action *RuleAction) describe() string {
+	if action.Scene != "" {
+		return "scene " + action.Scene
+	}
+	return action.Execute.Core
+}
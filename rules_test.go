@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRuleSetLoadSkipsInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.xml")
+	writeErr := ioutil.WriteFile(path, []byte(`<rules>
+    <rule name="broken">
+        <trigger kind="sometimes"></trigger>
+        <action><execute core="on"></execute><type><core>light</core></type></action>
+    </rule>
+    <rule name="lights">
+        <trigger kind="liveness" device="d1"></trigger>
+        <action><execute core="on"></execute><type><core>light</core></type></action>
+    </rule>
+</rules>`), 0644)
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	rules := BuildRuleSet(path)
+	if loadErr := rules.Load(); loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	if all := rules.All(); len(all) != 1 || all[0].Name != "lights" {
+		t.Fatalf("expected only the valid rule to load, got %v", all)
+	}
+}
+
+func TestRemoteSceneRunsWithoutWaiting(t *testing.T) {
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handlerA, handlerB := startTestHandler(t, "A"), startTestHandler(t, "B")
+	for _, pair := range [][2]*Handler{{handlerA, handlerB}, {handlerB, handlerA}} {
+		hostname, port := pair[1].router.GetAddress()
+		pair[0].routerManager.AddRouter(&Router{Name: pair[1].router.GetName(), Hostname: hostname, Port: port})
+	}
+	handlerB.deviceManager.AddDevice(&Device{ID: "d1", Type: &DeviceType{Core: "light"}, Address: "a", Stack: stackMemory})
+	handlerB.scenes.scenes["wake"] = &Scene{Name: "wake", Steps: []*SceneStep{{
+		Delay:        300,
+		Execute:      &CommandExecute{Core: "on"},
+		DeviceTarget: DeviceTarget{Devices: []string{"d1"}},
+	}}}
+
+	started := time.Now()
+	handlerA.RunRuleActions(&Rule{Name: "morning", Actions: []*RuleAction{{Definer: "B", Scene: "wake"}}})
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Fatalf("rule waited %v for the remote scene", elapsed)
+	}
+	received := make(chan error, 1)
+	go func() {
+		_, receiveErr := endpoint.Receive()
+		received <- receiveErr
+	}()
+	select {
+	case receiveErr := <-received:
+		if receiveErr != nil {
+			t.Fatal(receiveErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote scene never reached the device")
+	}
+}
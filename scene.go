@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+var (
+	errSceneStopped = errors.New("scene: not sent; an earlier step failed")
+)
+
+// Scene is a named bundle of commands kept in the config. Its steps
+// are sent in order, each after its delay from the end of the one
+// before, or all at once in parallel, each after its delay from the
+// start of the scene. Every step is checked against the capability
+// schemas before anything is sent and each step reaches all of its
+// devices or none of them. In order, a failed step stops the rest.
+type Scene struct {
+	XMLName  xml.Name     `xml:"scene"`
+	Name     string       `xml:"name,attr"`
+	Parallel bool         `xml:"parallel,attr,omitempty"`
+	Steps    []*SceneStep `xml:"step"`
+}
+
+// SceneStep sends a command to the devices it targets after a
+// delay in milliseconds
+type SceneStep struct {
+	XMLName xml.Name        `xml:"step"`
+	Delay   int             `xml:"delay,attr,omitempty"`
+	Execute *CommandExecute `xml:"execute"`
+	DeviceTarget
+}
+
+// SceneSet holds the scenes of the config keyed by name. It is
+// safe for concurrent use.
+type SceneSet struct {
+	XMLName xml.Name `xml:"scenes"`
+	lock    sync.RWMutex
+	scenes  map[string]*Scene
+}
+
+// BuildSceneSet returns a SceneSet without any scenes
+func BuildSceneSet() *SceneSet {
+	return &SceneSet{scenes: map[string]*Scene{}}
+}
+
+// Get returns the scene with the name, or nil if there is none
+func (scenes *SceneSet) Get(name string) *Scene {
+	scenes.lock.RLock()
+	defer scenes.lock.RUnlock()
+	return scenes.scenes[name]
+}
+
+// All returns every scene sorted by name
+func (scenes *SceneSet) All() []*Scene {
+	scenes.lock.RLock()
+	defer scenes.lock.RUnlock()
+	all := make([]*Scene, 0, len(scenes.scenes))
+	for _, scene := range scenes.scenes {
+		all = append(all, scene)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Validate checks that the scene has a name and that every step
+// has a target, a command and a delay that isn't negative
+func (scene *Scene) Validate() error {
+	if scene.Name == "" {
+		return errors.New("scene: scene must have a name")
+	}
+	if len(scene.Steps) == 0 {
+		return errors.New("scene: scene " + scene.Name + " has no steps")
+	}
+	for i, step := range scene.Steps {
+		if step.DeviceTarget.empty() || step.Execute == nil || step.Execute.Core == "" {
+			return errors.New("scene: step " + strconv.Itoa(i+1) + " of scene " + scene.Name + " needs a target and command")
+		}
+		if step.Delay < 0 {
+			return errors.New("scene: step " + strconv.Itoa(i+1) + " of scene " + scene.Name + " has a negative delay")
+		}
+	}
+	return nil
+}
+
+// UnmarshalXML is overridden to key the scenes by name,
+// rejecting any that are invalid or share a name
+func (scenes *SceneSet) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	tempScenes := struct {
+		XMLName xml.Name `xml:"scenes"`
+		Scenes  []*Scene `xml:"scene"`
+	}{}
+	if decodeErr := decoder.DecodeElement(&tempScenes, &start); decodeErr != nil {
+		return decodeErr
+	}
+	scenes.lock.Lock()
+	defer scenes.lock.Unlock()
+	scenes.XMLName = tempScenes.XMLName
+	scenes.scenes = map[string]*Scene{}
+	for _, scene := range tempScenes.Scenes {
+		if validateErr := scene.Validate(); validateErr != nil {
+			return validateErr
+		}
+		if _, ok := scenes.scenes[scene.Name]; ok {
+			return errors.New("scene: duplicate scene \"" + scene.Name + "\"")
+		}
+		scenes.scenes[scene.Name] = scene
+	}
+	return nil
+}
+
+// MarshalXML is overridden to ensure that the scenes map
+// is saved properly
+func (scenes *SceneSet) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	tempScenes := struct {
+		XMLName xml.Name `xml:"scenes"`
+		Scenes  []*Scene `xml:"scene"`
+	}{scenes.XMLName, scenes.All()}
+	return encoder.EncodeElement(tempScenes, start)
+}
+
+// HandleSceneRequest runs the requested scene and reports the
+// outcome for every device it targeted
+func (handler *Handler) HandleSceneRequest(packet *packets.Packet, writer io.Writer) error {
+	response, sceneErr := handler.RunScene(packet.GetSceneReq().Scene)
+	if sceneErr != nil {
+		Error.Println(handler.SendResponseError(sceneErr, packet, writer))
+		return sceneErr
+	}
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_SceneResponse{
+			SceneResponse: response,
+		},
+	}, writer)
+}
+
+// HandleSceneResponse logs the outcome of a scene another definer
+// was asked to run without waiting for it
+func (handler *Handler) HandleSceneResponse(packet *packets.Packet, writer io.Writer) error {
+	response := packet.GetSceneResponse()
+	if !response.Success {
+		Warning.Println("scene: scene " + response.Scene + " ran on " + packet.GetHeader().Origin + " but not every device accepted it: " + response.String())
+		return nil
+	}
+	Info.Println("scene: scene " + response.Scene + " ran on " + packet.GetHeader().Origin)
+	return nil
+}
+
+// RunScene carries out the scene with the name, returning once
+// every step has been sent or stopped. Replies from the devices
+// are addressed to the current definer.
+func (handler *Handler) RunScene(name string) (*packets.SceneResponse, error) {
+	scene := handler.scenes.Get(name)
+	if scene == nil {
+		return nil, errors.New("scene: no scene named \"" + name + "\"")
+	}
+	steps := make([]*packets.Packet, len(scene.Steps))
+	targets := make([][]*Device, len(scene.Steps))
+	for i, step := range scene.Steps {
+		steps[i] = handler.scenePacket(step)
+		devices, validateErr := handler.validateCommand(steps[i].GetCommand())
+		if validateErr != nil {
+			return nil, errors.New("scene: step " + strconv.Itoa(i+1) + " of scene " + name + ": " + validateErr.Error())
+		}
+		targets[i] = devices
+	}
+	Info.Println("scene: running scene " + name)
+	responses := make([]*packets.CommandResponse, len(steps))
+	run := func(i int) {
+		response, commandErr := handler.RunCommand(steps[i], nil)
+		if commandErr != nil {
+			response = failedResponse(targets[i], commandErr)
+		}
+		responses[i] = response
+	}
+	if scene.Parallel {
+		var wait sync.WaitGroup
+		for i := range steps {
+			wait.Add(1)
+			go func(i int) {
+				defer wait.Done()
+				time.Sleep(scene.Steps[i].delay())
+				run(i)
+			}(i)
+		}
+		wait.Wait()
+		return sceneResponse(name, responses), nil
+	}
+	stopped := false
+	for i := range steps {
+		if stopped {
+			responses[i] = failedResponse(targets[i], errSceneStopped)
+			continue
+		}
+		time.Sleep(scene.Steps[i].delay())
+		run(i)
+		stopped = !responses[i].Success
+	}
+	return sceneResponse(name, responses), nil
+}
+
+// scenePacket builds the command sent by the step, sent to all of
+// its devices or none of them
+func (handler *Handler) scenePacket(step *SceneStep) *packets.Packet {
+	command := buildExecuteCommand(&step.DeviceTarget, step.Execute)
+	command.AllOrNothing = true
+	return &packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin: handler.router.GetName(),
+			Id:     NewPacketID(),
+			Type:   packets.Packet_Header_REQUEST,
+		},
+		Body: &packets.Packet_Command{
+			Command: command,
+		},
+	}
+}
+
+func (step *SceneStep) delay() time.Duration {
+	return time.Duration(step.Delay) * time.Millisecond
+}
+
+// failedResponse reports the error for every device
+func failedResponse(devices []*Device, err error) *packets.CommandResponse {
+	errs := make([]error, len(devices))
+	for i := range errs {
+		errs[i] = err
+	}
+	return commandResponse(devices, errs)
+}
+
+// sceneResponse merges the outcomes of the steps into one result
+// per device, in the order the devices were first targeted. A
+// device's result keeps the first error it had.
+func sceneResponse(name string, responses []*packets.CommandResponse) *packets.SceneResponse {
+	response := &packets.SceneResponse{Scene: name, Success: true}
+	results := map[string]*packets.CommandResponse_Result{}
+	for _, stepResponse := range responses {
+		for _, stepResult := range stepResponse.Results {
+			result, ok := results[stepResult.Device]
+			if !ok {
+				result = &packets.CommandResponse_Result{Device: stepResult.Device, Success: true}
+				results[stepResult.Device] = result
+				response.Results = append(response.Results, result)
+			}
+			if !stepResult.Success && result.Success {
+				result.Success = false
+				result.Error = stepResult.Error
+				response.Success = false
+			}
+		}
+	}
+	return response
+}
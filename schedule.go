@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// scheduleMaxWait is the longest the scheduler sleeps before
+	// checking its schedules again, so that changes to the clock
+	// are noticed
+	scheduleMaxWait = time.Minute
+	// sunSearchDays is how many days ahead the next sunrise or
+	// sunset is looked for, to cover polar days and nights
+	sunSearchDays = 370
+)
+
+var (
+	// SchedulePath is the file schedules are kept in
+	SchedulePath = "./schedules.xml"
+
+	errScheduleKind = errors.New("schedule: exactly one of a time, a cron expression or sunrise/sunset must be given")
+	errNotLocated   = errors.New("schedule: sunrise and sunset need the latitude and longitude of the definer in the settings")
+)
+
+// Clock tells the time and waits for it to pass. The scheduler
+// takes one so that it can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the system
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to pass
+func (SystemClock) After(duration time.Duration) <-chan time.Time {
+	return time.After(duration)
+}
+
+// Schedule is a command carried out later: once at a time, whenever
+// a cron expression matches, or every day at sunrise or sunset moved
+// by an offset in minutes. The command is kept marshaled and base64
+// encoded.
+type Schedule struct {
+	XMLName xml.Name   `xml:"schedule"`
+	ID      string     `xml:"id,attr"`
+	At      *time.Time `xml:"at,omitempty"`
+	Cron    string     `xml:"cron,omitempty"`
+	Sun     string     `xml:"sun,omitempty"`
+	Offset  int        `xml:"offset,omitempty"`
+	Command string     `xml:"command"`
+	Next    time.Time  `xml:"next"`
+}
+
+// Scheduler runs the schedules as they come due and keeps them in a
+// file. It is safe for concurrent use.
+type Scheduler struct {
+	lock      sync.Mutex
+	clock     Clock
+	path      string
+	schedules map[string]*Schedule
+	run       func(*Schedule)
+	wake      chan struct{}
+}
+
+type scheduleFile struct {
+	XMLName   xml.Name    `xml:"schedules"`
+	Schedules []*Schedule `xml:"schedule"`
+}
+
+// BuildScheduler returns a Scheduler without any schedules that
+// keeps them in the file at the path and hands them to run as they
+// come due. An empty path keeps the schedules in memory only.
+func BuildScheduler(path string, clock Clock, run func(*Schedule)) *Scheduler {
+	return &Scheduler{
+		clock:     clock,
+		path:      path,
+		schedules: map[string]*Schedule{},
+		run:       run,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// BuildSchedule returns a Schedule for the command without a time
+func BuildSchedule(command *packets.Command) (*Schedule, error) {
+	if command == nil {
+		return nil, errors.New("schedule: no command given")
+	}
+	data, protoErr := proto.Marshal(command)
+	if protoErr != nil {
+		return nil, protoErr
+	}
+	return &Schedule{ID: NewPacketID(), Command: base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// GetCommand decodes the command the schedule carries out
+func (schedule *Schedule) GetCommand() (*packets.Command, error) {
+	data, decodeErr := base64.StdEncoding.DecodeString(schedule.Command)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	command := &packets.Command{}
+	if unmarshErr := proto.Unmarshal(data, command); unmarshErr != nil {
+		return nil, unmarshErr
+	}
+	return command, nil
+}
+
+// Describe summarizes when the schedule runs
+func (schedule *Schedule) Describe() string {
+	switch {
+	case schedule.At != nil:
+		return "once at " + schedule.At.Format(time.Stamp)
+	case schedule.Cron != "":
+		return "cron \"" + schedule.Cron + "\""
+	}
+	offset := time.Duration(schedule.Offset) * time.Minute
+	if offset == 0 {
+		return "every " + schedule.Sun
+	}
+	if offset > 0 {
+		return "every " + schedule.Sun + " +" + offset.String()
+	}
+	return "every " + schedule.Sun + " " + offset.String()
+}
+
+// validate checks that the schedule says when it runs in exactly
+// one way, and that way makes sense. Sunrise and sunset are refused
+// until the definer's coordinates are configured.
+func (schedule *Schedule) validate() error {
+	kinds := 0
+	for _, given := range []bool{schedule.At != nil, schedule.Cron != "", schedule.Sun != ""} {
+		if given {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return errScheduleKind
+	}
+	if schedule.Cron != "" {
+		if _, cronErr := ParseCron(schedule.Cron); cronErr != nil {
+			return cronErr
+		}
+	}
+	if schedule.Sun != "" && schedule.Sun != sunrise && schedule.Sun != sunset {
+		return errors.New("schedule: expected sunrise or sunset, got \"" + schedule.Sun + "\"")
+	}
+	if schedule.Sun != "" && !located() {
+		return errNotLocated
+	}
+	_, commandErr := schedule.GetCommand()
+	return commandErr
+}
+
+// next returns when the schedule runs after the given time, or
+// false if it never runs again
+func (schedule *Schedule) next(after time.Time) (time.Time, bool) {
+	switch {
+	case schedule.At != nil:
+		return *schedule.At, schedule.At.After(after)
+	case schedule.Cron != "":
+		spec, cronErr := ParseCron(schedule.Cron)
+		if cronErr != nil {
+			return time.Time{}, false
+		}
+		return spec.Next(after)
+	}
+	offset := time.Duration(schedule.Offset) * time.Minute
+	for day := 0; day < sunSearchDays; day++ {
+		event, ok := SunEvent(after.AddDate(0, 0, day), schedule.Sun, Latitude, Longitude)
+		if ok && event.Add(offset).After(after) {
+			return event.Add(offset), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Add validates the schedule and works out when it first runs
+func (scheduler *Scheduler) Add(schedule *Schedule) error {
+	if validateErr := schedule.validate(); validateErr != nil {
+		return validateErr
+	}
+	next, ok := schedule.next(scheduler.clock.Now())
+	if !ok {
+		return errors.New("schedule: schedule " + schedule.ID + " would never run")
+	}
+	schedule.Next = next
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduler.schedules[schedule.ID] = schedule
+	scheduler.wakeUp()
+	return scheduler.save()
+}
+
+// Cancel removes the schedule with the given ID
+func (scheduler *Scheduler) Cancel(id string) error {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if _, ok := scheduler.schedules[id]; !ok {
+		return errors.New("schedule: no schedule " + id)
+	}
+	delete(scheduler.schedules, id)
+	scheduler.wakeUp()
+	return scheduler.save()
+}
+
+// All returns a copy of every schedule, soonest first
+func (scheduler *Scheduler) All() []Schedule {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	schedules := make([]Schedule, 0, len(scheduler.schedules))
+	for _, schedule := range scheduler.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Next.Before(schedules[j].Next)
+	})
+	return schedules
+}
+
+// Load reads the schedules from the file. Recurring schedules that
+// came due while the definer was down are skipped, but schedules that
+// were only to run once still run, late.
+func (scheduler *Scheduler) Load() error {
+	if scheduler.path == "" {
+		return nil
+	}
+	fileData, readErr := ioutil.ReadFile(scheduler.path)
+	if os.IsNotExist(readErr) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+	file := &scheduleFile{}
+	if unmarshErr := xml.Unmarshal(fileData, file); unmarshErr != nil {
+		return unmarshErr
+	}
+	now := scheduler.clock.Now()
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	for _, schedule := range file.Schedules {
+		if validateErr := schedule.validate(); validateErr != nil {
+			Warning.Println("schedule: dropping schedule " + schedule.ID + ": " + validateErr.Error())
+			continue
+		}
+		if schedule.At != nil {
+			schedule.Next = *schedule.At
+		} else if next, ok := schedule.next(now); ok {
+			schedule.Next = next
+		} else {
+			continue
+		}
+		scheduler.schedules[schedule.ID] = schedule
+	}
+	scheduler.wakeUp()
+	return nil
+}
+
+// save writes the schedules to the file. The caller must hold the lock.
+func (scheduler *Scheduler) save() error {
+	if scheduler.path == "" {
+		return nil
+	}
+	file := &scheduleFile{}
+	for _, schedule := range scheduler.schedules {
+		file.Schedules = append(file.Schedules, schedule)
+	}
+	sort.Slice(file.Schedules, func(i, j int) bool {
+		return file.Schedules[i].ID < file.Schedules[j].ID
+	})
+	fileData, marshErr := xml.MarshalIndent(file, "", "    ")
+	if marshErr != nil {
+		return marshErr
+	}
+	return ioutil.WriteFile(scheduler.path, fileData, 0644)
+}
+
+// wakeUp makes Run look at the schedules again
+func (scheduler *Scheduler) wakeUp() {
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run hands each schedule to the scheduler's run function as it
+// comes due. It never returns.
+func (scheduler *Scheduler) Run() {
+	for {
+		select {
+		case <-scheduler.clock.After(scheduler.runDue()):
+		case <-scheduler.wake:
+		}
+	}
+}
+
+// runDue runs every schedule that has come due, works out when each
+// of them runs next, and returns how long to wait for the next one
+func (scheduler *Scheduler) runDue() time.Duration {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	now := scheduler.clock.Now()
+	wait := scheduleMaxWait
+	changed := false
+	for id, schedule := range scheduler.schedules {
+		if schedule.Next.After(now) {
+			if until := schedule.Next.Sub(now); until < wait {
+				wait = until
+			}
+			continue
+		}
+		due := *schedule
+		go scheduler.run(&due)
+		changed = true
+		next, ok := schedule.next(now)
+		if !ok {
+			delete(scheduler.schedules, id)
+			continue
+		}
+		schedule.Next = next
+		if until := next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if changed {
+		if saveErr := scheduler.save(); saveErr != nil {
+			Error.Println("schedule: couldn't persist schedules: " + saveErr.Error())
+		}
+	}
+	return wait
+}
+
+// runSchedule sends the scheduled command to the current definer
+func (handler *Handler) runSchedule(schedule *Schedule) {
+	command, commandErr := schedule.GetCommand()
+	if commandErr != nil {
+		Error.Println("schedule: couldn't read command of schedule " + schedule.ID + ": " + commandErr.Error())
+		return
+	}
+	Info.Println("schedule: running schedule " + schedule.ID)
+	response, requestErr := handler.SendRequest(&packets.Packet{
+		Header: &packets.Packet_Header{},
+		Body: &packets.Packet_Command{
+			Command: command,
+		},
+	}, DefaultRequestTimeout)
+	if requestErr != nil {
+		Error.Println("schedule: schedule " + schedule.ID + " failed: " + requestErr.Error())
+		return
+	}
+	if result := response.GetCommandResponse(); result != nil && !result.Success {
+		Warning.Println("schedule: schedule " + schedule.ID + " ran but not every device accepted it: " + result.String())
+	}
+}
+
+// HandleScheduleRequest schedules the command in the request,
+// checking it against the capability schemas first
+func (handler *Handler) HandleScheduleRequest(packet *packets.Packet, writer io.Writer) error {
+	body := packet.GetScheduleReq()
+	schedule, scheduleErr := handler.scheduleFromRequest(body)
+	if scheduleErr == nil {
+		scheduleErr = handler.addSchedule(schedule, body.GetCommand())
+	}
+	if scheduleErr != nil {
+		Error.Println(handler.SendResponseError(scheduleErr, packet, writer))
+		return scheduleErr
+	}
+	Info.Println("schedule: added schedule " + schedule.ID + " to run " + schedule.Describe())
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_ScheduleResponse{
+			ScheduleResponse: &packets.ScheduleResponse{
+				Id:   schedule.ID,
+				Next: schedule.Next.UnixNano(),
+			},
+		},
+	}, writer)
+}
+
+// addSchedule checks the command against the schema of the type of
+// device it targets and schedules it. The devices themselves aren't
+// looked up, since they may change before the command runs.
+func (handler *Handler) addSchedule(schedule *Schedule, command *packets.Command) error {
+	if validateErr := handler.schemas.Validate(commandType(command), command); validateErr != nil {
+		return validateErr
+	}
+	return handler.schedules.Add(schedule)
+}
+
+// scheduleFromRequest builds the schedule described by the request
+func (handler *Handler) scheduleFromRequest(body *packets.ScheduleRequest) (*Schedule, error) {
+	schedule, buildErr := BuildSchedule(body.GetCommand())
+	if buildErr != nil {
+		return nil, buildErr
+	}
+	if body.At != 0 {
+		at := time.Unix(0, body.At)
+		schedule.At = &at
+	}
+	schedule.Cron = body.Cron
+	schedule.Sun = body.Sun
+	schedule.Offset = int(body.Offset)
+	return schedule, nil
+}
+
+// commandType returns the type of device the command targets
+func commandType(command *packets.Command) *DeviceType {
+	device := command.GetDevice()
+	if device == nil {
+		return &DeviceType{}
+	}
+	return &DeviceType{Core: device.Core, Modifier: device.Modifier}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// fakeClock is a Clock that only moves when the test advances it
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	until time.Time
+	fire  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) After(duration time.Duration) <-chan time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	fire := make(chan time.Time, 1)
+	if duration <= 0 {
+		fire <- clock.now
+		return fire
+	}
+	clock.waiters = append(clock.waiters, fakeWaiter{until: clock.now.Add(duration), fire: fire})
+	return fire
+}
+
+// Advance moves the clock on, firing every wait that has passed
+func (clock *fakeClock) Advance(duration time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(duration)
+	waiting := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if waiter.until.After(clock.now) {
+			waiting = append(waiting, waiter)
+			continue
+		}
+		waiter.fire <- clock.now
+	}
+	clock.waiters = waiting
+}
+
+// Waiting returns the number of waits that haven't fired yet
+func (clock *fakeClock) Waiting() int {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return len(clock.waiters)
+}
+
+// testSchedule returns a schedule of a command for lights
+func testSchedule(t *testing.T) *Schedule {
+	schedule, buildErr := BuildSchedule(&packets.Command{Device: &packets.Command_Device{Core: "light"}})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return schedule
+}
+
+func TestSchedulerRunsDueSchedules(t *testing.T) {
+	start := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	ran := make(chan string, 8)
+	scheduler := BuildScheduler("", clock, func(schedule *Schedule) {
+		ran <- schedule.ID
+	})
+	once := testSchedule(t)
+	at := start.Add(40 * time.Second)
+	once.At = &at
+	hourly := testSchedule(t)
+	hourly.Cron = "0 * * * *"
+	for _, schedule := range []*Schedule{once, hourly} {
+		if addErr := scheduler.Add(schedule); addErr != nil {
+			t.Fatal(addErr)
+		}
+	}
+
+	if wait := scheduler.runDue(); wait != 40*time.Second {
+		t.Fatalf("expected to wait for the one-off schedule, got %v", wait)
+	}
+	clock.Advance(40 * time.Second)
+	if wait := scheduler.runDue(); wait != scheduleMaxWait {
+		t.Fatalf("expected to wait no longer than %v, got %v", scheduleMaxWait, wait)
+	}
+	if id := <-ran; id != once.ID {
+		t.Fatalf("expected the one-off schedule to run, got %s", id)
+	}
+	if all := scheduler.All(); len(all) != 1 || all[0].ID != hourly.ID {
+		t.Fatalf("one-off schedule should be gone after running: %v", all)
+	}
+
+	// Adding the schedules left a wake up pending
+	<-scheduler.wake
+	go scheduler.Run()
+	waitFor(t, "the scheduler to sleep", func() bool { return clock.Waiting() == 1 })
+	clock.Advance(29*time.Minute + 20*time.Second)
+	if id := <-ran; id != hourly.ID {
+		t.Fatalf("expected the hourly schedule to run, got %s", id)
+	}
+	if next := scheduler.All()[0].Next; !next.Equal(time.Date(2026, 10, 17, 11, 0, 0, 0, time.UTC)) {
+		t.Fatalf("hourly schedule should run next at 11:00, got %v", next)
+	}
+	select {
+	case id := <-ran:
+		t.Fatalf("schedule %s ran again", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSunSchedulesNeedCoordinates(t *testing.T) {
+	latitude, longitude := Latitude, Longitude
+	defer func() { Latitude, Longitude = latitude, longitude }()
+	clock := newFakeClock(time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC))
+	scheduler := BuildScheduler("", clock, func(*Schedule) {})
+	schedule := testSchedule(t)
+	schedule.Sun = sunset
+
+	Latitude, Longitude = 0, 0
+	if addErr := scheduler.Add(schedule); addErr != errNotLocated {
+		t.Fatalf("expected %v, got %v", errNotLocated, addErr)
+	}
+	Latitude, Longitude = 51.5074, -0.1278
+	if addErr := scheduler.Add(schedule); addErr != nil {
+		t.Fatal(addErr)
+	}
+	expected, _ := SunEvent(clock.Now(), sunset, Latitude, Longitude)
+	if next := scheduler.All()[0].Next; !next.Equal(expected) {
+		t.Fatalf("expected sunset at %v, got %v", expected, next)
+	}
+}
+
+func TestScheduledCommandsAreValidated(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.schedules = BuildScheduler("", SystemClock{}, handler.runSchedule)
+	handler.schemas.Add(&Schema{Type: &DeviceType{Core: "light"}, Commands: []*SchemaCommand{{Name: "on"}}})
+	console := &ConsoleServer{handler: handler, router: handler.router}
+	command := func(core string) *packets.Command {
+		return &packets.Command{
+			Device: &packets.Command_Device{Core: "light"},
+			Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: core}},
+		}
+	}
+
+	schedule, _ := BuildSchedule(command("dance"))
+	schedule.Cron = "0 7 * * *"
+	if addErr := handler.addSchedule(schedule, command("dance")); addErr == nil {
+		t.Fatal("expected a command the schema doesn't allow to be refused")
+	}
+	console.scheduleAdd([]commandArgument{
+		{argument: "core", value: "dance"},
+		{argument: "type", value: "light"},
+		{argument: "cron", value: "0 7 * * *"},
+	})
+	if schedules := handler.schedules.All(); len(schedules) != 0 {
+		t.Fatalf("invalid commands were scheduled: %v", schedules)
+	}
+	console.scheduleAdd([]commandArgument{
+		{argument: "core", value: "on"},
+		{argument: "type", value: "light"},
+		{argument: "cron", value: "0 7 * * *"},
+	})
+	if schedules := handler.schedules.All(); len(schedules) != 1 {
+		t.Fatalf("expected the valid command to be scheduled, got %v", schedules)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	paramString = "string"
+	paramInt    = "int"
+	paramFloat  = "float"
+	paramBool   = "bool"
+	paramEnum   = "enum"
+)
+
+var (
+	// SchemaPath is the directory capability schemas are
+	// loaded from. Every .xml file in it is read as a schema.
+	SchemaPath = "./schemas"
+)
+
+// Schema declares the commands a type of device accepts. A schema
+// without a modifier applies to every device of its core type that
+// has no schema of its own.
+type Schema struct {
+	XMLName  xml.Name         `xml:"schema"`
+	Type     *DeviceType      `xml:"type"`
+	Commands []*SchemaCommand `xml:"command"`
+}
+
+// SchemaCommand is a single command accepted by a device and
+// the parameters it takes, in order
+type SchemaCommand struct {
+	XMLName    xml.Name           `xml:"command"`
+	Name       string             `xml:"name,attr"`
+	Parameters []*SchemaParameter `xml:"parameter"`
+}
+
+// SchemaParameter describes a single command parameter. Ranges
+// apply to numeric parameters and values to enums.
+type SchemaParameter struct {
+	XMLName  xml.Name `xml:"parameter"`
+	Name     string   `xml:"name,attr"`
+	Type     string   `xml:"type,attr"`
+	Optional bool     `xml:"optional,attr"`
+	Min      *float64 `xml:"min,attr"`
+	Max      *float64 `xml:"max,attr"`
+	Values   []string `xml:"value"`
+}
+
+// SchemaRegistry holds the capability schemas keyed by device
+// type. It is safe for concurrent use.
+type SchemaRegistry struct {
+	lock    sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// BuildSchemaRegistry returns a SchemaRegistry without any schemas
+func BuildSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: map[string]*Schema{}}
+}
+
+func schemaKey(core string, modifier string) string {
+	return core + "/" + modifier
+}
+
+// Load reads every schema in the directory, replacing the schemas
+// already registered for the same types. A missing directory
+// isn't an error.
+func (registry *SchemaRegistry) Load(dir string) error {
+	files, globErr := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if globErr != nil {
+		return globErr
+	}
+	for _, file := range files {
+		schemaData, readErr := ioutil.ReadFile(file)
+		if os.IsNotExist(readErr) {
+			continue
+		}
+		if readErr != nil {
+			return readErr
+		}
+		schema := &Schema{}
+		if unmarshErr := xml.Unmarshal(schemaData, schema); unmarshErr != nil {
+			return errors.New("schema: couldn't parse " + file + ": " + unmarshErr.Error())
+		}
+		if addErr := registry.Add(schema); addErr != nil {
+			return errors.New("schema: invalid schema " + file + ": " + addErr.Error())
+		}
+	}
+	return nil
+}
+
+// Add registers the schema for its device type
+func (registry *SchemaRegistry) Add(schema *Schema) error {
+	if schema.Type == nil || schema.Type.Core == "" {
+		return errors.New("schema: schema must declare a device type")
+	}
+	for _, command := range schema.Commands {
+		for _, parameter := range command.Parameters {
+			switch parameter.Type {
+			case paramString, paramInt, paramFloat, paramBool:
+			case paramEnum:
+				if len(parameter.Values) == 0 {
+					return errors.New("schema: enum parameter " + parameter.Name + " of command " + command.Name + " has no values")
+				}
+			default:
+				return errors.New("schema: parameter " + parameter.Name + " of command " + command.Name + " has unknown type \"" + parameter.Type + "\"")
+			}
+		}
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.schemas[schemaKey(schema.Type.Core, schema.Type.Modifier)] = schema
+	return nil
+}
+
+// Get returns the schema that applies to the device type, if any.
+// Devices without a type have no schema.
+func (registry *SchemaRegistry) Get(deviceType *DeviceType) *Schema {
+	if deviceType == nil {
+		return nil
+	}
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	if schema, ok := registry.schemas[schemaKey(deviceType.Core, deviceType.Modifier)]; ok {
+		return schema
+	}
+	return registry.schemas[schemaKey(deviceType.Core, "")]
+}
+
+// Validate checks the command against the schema for the device
+// type. Devices without a schema accept any command.
+func (registry *SchemaRegistry) Validate(deviceType *DeviceType, command *packets.Command) error {
+	schema := registry.Get(deviceType)
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(command)
+}
+
+// Validate checks the command against the schema, describing the
+// first problem found
+func (schema *Schema) Validate(command *packets.Command) error {
+	execute := command.GetExecute()
+	if execute == nil {
+		return errors.New("schema: command has no body")
+	}
+	typeName := schema.Type.Core
+	if schema.Type.Modifier != "" {
+		typeName += "/" + schema.Type.Modifier
+	}
+	var declared *SchemaCommand
+	names := []string{}
+	for _, candidate := range schema.Commands {
+		names = append(names, candidate.Name)
+		if candidate.Name == execute.Core {
+			declared = candidate
+		}
+	}
+	if declared == nil {
+		return errors.New("schema: " + typeName + " doesn't accept command \"" + execute.Core + "\"; expected one of " + strings.Join(names, ", "))
+	}
+	if len(execute.Parameters) > len(declared.Parameters) {
+		return errors.New("schema: command " + declared.Name + " for " + typeName + " takes at most " + strconv.Itoa(len(declared.Parameters)) + " parameters, got " + strconv.Itoa(len(execute.Parameters)))
+	}
+	for i, parameter := range declared.Parameters {
+		if i >= len(execute.Parameters) {
+			if !parameter.Optional {
+				return errors.New("schema: command " + declared.Name + " for " + typeName + " is missing parameter " + parameter.Name)
+			}
+			continue
+		}
+		if paramErr := parameter.validate(execute.Parameters[i]); paramErr != nil {
+			return errors.New("schema: command " + declared.Name + " for " + typeName + ": parameter " + parameter.Name + " " + paramErr.Error())
+		}
+	}
+	return nil
+}
+
+// validate checks a single value against the parameter
+func (parameter *SchemaParameter) validate(value string) error {
+	switch parameter.Type {
+	case paramInt:
+		number, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil {
+			return errors.New("must be an integer, got \"" + value + "\"")
+		}
+		return parameter.validateRange(float64(number), value)
+	case paramFloat:
+		number, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return errors.New("must be a number, got \"" + value + "\"")
+		}
+		return parameter.validateRange(number, value)
+	case paramBool:
+		if _, parseErr := strconv.ParseBool(value); parseErr != nil {
+			return errors.New("must be true or false, got \"" + value + "\"")
+		}
+	case paramEnum:
+		for _, allowed := range parameter.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return errors.New("must be one of " + strings.Join(parameter.Values, ", ") + ", got \"" + value + "\"")
+	}
+	return nil
+}
+
+func (parameter *SchemaParameter) validateRange(number float64, value string) error {
+	if parameter.Min != nil && number < *parameter.Min {
+		return errors.New("must be at least " + strconv.FormatFloat(*parameter.Min, 'g', -1, 64) + ", got " + value)
+	}
+	if parameter.Max != nil && number > *parameter.Max {
+		return errors.New("must be at most " + strconv.FormatFloat(*parameter.Max, 'g', -1, 64) + ", got " + value)
+	}
+	return nil
+}
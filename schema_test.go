@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ottopress/definer/protos"
+)
+
+func TestValidateCommandForTypelessDevice(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	if addErr := handler.schemas.Add(&Schema{
+		Type:     &DeviceType{Core: "light"},
+		Commands: []*SchemaCommand{{Name: "on"}},
+	}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	handler.deviceManager.AddDevice(&Device{ID: "typeless", Stack: "none"})
+	handler.deviceManager.AddDevice(&Device{ID: "lamp", Type: &DeviceType{Core: "light"}, Stack: "none"})
+	command := func(devices ...string) *packets.Command {
+		return &packets.Command{
+			Device: &packets.Command_Device{Devices: devices},
+			Body:   &packets.Command_Execute{Execute: &packets.Execute{Core: "dance"}},
+		}
+	}
+	if devices, validateErr := handler.validateCommand(command("typeless")); validateErr != nil || len(devices) != 1 {
+		t.Fatalf("a device without a type should accept any command: %v", validateErr)
+	}
+	if _, validateErr := handler.validateCommand(command("typeless", "lamp")); validateErr == nil {
+		t.Fatal("the lamp's schema should reject the command")
+	}
+}
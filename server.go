@@ -14,7 +14,7 @@ type Server interface {
 
 // InitServers setups up each of the servers and sets up
 // their basic handlers.
-func InitServers(router *Router, handler *Handler, deviceManager *DeviceManager) {
-	ConsoleServ = &ConsoleServer{handler: handler, router: router, deviceManager: deviceManager}
+func InitServers(router *Router, handler *Handler, deviceManager *DeviceManager, routerManager *RouterManager) {
+	ConsoleServ = &ConsoleServer{handler: handler, router: router, deviceManager: deviceManager, routerManager: routerManager}
 	WifiServ = &WifiServer{handler: handler, router: router}
 }
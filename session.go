@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// DefaultSessionWorkers is the number of packets handled at
+	// once on a single session unless configured otherwise
+	DefaultSessionWorkers = 16
+)
+
+var (
+	// DialTimeout is the maximum amount of time spent
+	// establishing a new outbound session.
+	DialTimeout = 5 * time.Second
+	// SessionWorkers is the number of packets from a single session
+	// handled at once. Reading from the session waits while they are
+	// all busy, so a peer sending faster than its packets can be
+	// handled is slowed down rather than served without limit.
+	SessionWorkers = DefaultSessionWorkers
+)
+
+// Session represents a persistent connection with a peer over
+// which any number of packets can flow in both directions.
+type Session struct {
+	conn      net.Conn
+	handler   *Handler
+	writeLock sync.Mutex
+	workers   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// SessionManager keeps track of the outbound sessions the
+// current definer has opened so they can be reused.
+type SessionManager struct {
+	handler  *Handler
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+// BuildSession wraps the provided connection in a Session
+func BuildSession(conn net.Conn, handler *Handler) *Session {
+	workers := SessionWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	return &Session{
+		conn:    conn,
+		handler: handler,
+		workers: make(chan struct{}, workers),
+		done:    make(chan struct{}),
+	}
+}
+
+// Serve reads packets off the session until the connection is
+// closed. Packets are handled by up to SessionWorkers goroutines at
+// once so that responses can be written back asynchronously.
+func (session *Session) Serve() {
+	defer session.Close()
+	for {
+		protoData, protoReadErr := ReadFrame(session.conn)
+		if protoReadErr != nil {
+			if protoReadErr != io.EOF {
+				Error.Println("session: couldn't read proto:", protoReadErr.Error())
+			}
+			return
+		}
+		protoPacket, protoParseErr := session.parseProto(protoData)
+		if protoParseErr != nil {
+			Error.Println("session: couldn't parse proto:", protoParseErr.Error())
+			continue
+		}
+		// Responses are handled straight away, since workers waiting
+		// on a response may be what is keeping the others busy
+		if header := protoPacket.GetHeader(); header != nil && header.Type == packets.Packet_Header_RESPONSE {
+			session.handle(protoPacket)
+			continue
+		}
+		session.workers <- struct{}{}
+		go func(packet *packets.Packet) {
+			defer func() { <-session.workers }()
+			session.handle(packet)
+		}(protoPacket)
+	}
+}
+
+func (session *Session) handle(packet *packets.Packet) {
+	handlerErr := session.handler.Handle(packet, session)
+	if handlerErr != nil {
+		Error.Println("session: couldn't handle proto:", handlerErr)
+	}
+}
+
+// Write sends the raw data to the peer. Writes are serialized
+// so packets written by concurrent handlers never interleave.
+func (session *Session) Write(data []byte) (int, error) {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	return session.conn.Write(data)
+}
+
+// Close shuts down the session. It is safe to call more than once.
+func (session *Session) Close() error {
+	var closeErr error
+	session.closeOnce.Do(func() {
+		closeErr = session.conn.Close()
+		close(session.done)
+	})
+	return closeErr
+}
+
+// Done returns a channel that is closed once the session ends
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
+// RemoteAddr returns the address of the peer
+func (session *Session) RemoteAddr() string {
+	return session.conn.RemoteAddr().String()
+}
+
+func (session *Session) parseProto(protoData []byte) (*packets.Packet, error) {
+	packet := &packets.Packet{}
+	unmarshErr := proto.Unmarshal(protoData, packet)
+	return packet, unmarshErr
+}
+
+// BuildSessionManager returns an empty SessionManager whose
+// sessions hand incoming packets to the given handler
+func BuildSessionManager(handler *Handler) *SessionManager {
+	return &SessionManager{
+		handler:  handler,
+		sessions: map[string]*Session{},
+	}
+}
+
+// GetSession returns the open session for the given address,
+// dialing a new one if none exists yet.
+func (manager *SessionManager) GetSession(address string) (*Session, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if session, ok := manager.sessions[address]; ok {
+		select {
+		case <-session.Done():
+			delete(manager.sessions, address)
+		default:
+			return session, nil
+		}
+	}
+	conn, connErr := net.DialTimeout("tcp", address, DialTimeout)
+	if connErr != nil {
+		return nil, connErr
+	}
+	session := BuildSession(conn, manager.handler)
+	manager.sessions[address] = session
+	go func() {
+		session.Serve()
+		manager.removeSession(address, session)
+	}()
+	return session, nil
+}
+
+// CloseSession closes and forgets the session for the given address
+func (manager *SessionManager) CloseSession(address string) {
+	manager.lock.Lock()
+	session, ok := manager.sessions[address]
+	delete(manager.sessions, address)
+	manager.lock.Unlock()
+	if ok {
+		session.Close()
+	}
+}
+
+// CloseAll closes every session the manager has open
+func (manager *SessionManager) CloseAll() {
+	manager.lock.Lock()
+	sessions := manager.sessions
+	manager.sessions = map[string]*Session{}
+	manager.lock.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+func (manager *SessionManager) removeSession(address string, session *Session) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.sessions[address] == session {
+		delete(manager.sessions, address)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ottopress/definer/protos"
+)
+
+// serveSessions serves sessions for the handler on a local port
+// until the test ends, returning the port
+func serveSessions(t *testing.T, handler *Handler) int {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go BuildSession(conn, handler).Serve()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// pingPacket returns a ping from one router to another
+func pingPacket(origin string, destination string) *packets.Packet {
+	return &packets.Packet{
+		Header: &packets.Packet_Header{Origin: origin, Destination: destination, Id: NewPacketID(), Type: packets.Packet_Header_REQUEST},
+		Body:   &packets.Packet_PingReq{PingReq: &packets.PingRequest{}},
+	}
+}
+
+func TestSessionsAreReusedAndRedialed(t *testing.T) {
+	handlerA := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handlerB := BuildHandler(&Router{Name: "B", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	routerB := &Router{Name: "B", Hostname: "127.0.0.1", Port: serveSessions(t, handlerB)}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(routerB.Port))
+	defer handlerA.sessionManager.CloseAll()
+
+	first, sessionErr := handlerA.sessionManager.GetSession(address)
+	if sessionErr != nil {
+		t.Fatal(sessionErr)
+	}
+	if again, _ := handlerA.sessionManager.GetSession(address); again != first {
+		t.Fatal("expected the open session to be reused")
+	}
+	first.Close()
+	second, sessionErr := handlerA.sessionManager.GetSession(address)
+	if sessionErr != nil {
+		t.Fatal(sessionErr)
+	}
+	if second == first {
+		t.Fatal("expected a closed session to be redialed")
+	}
+	if _, requestErr := handlerA.SendRequestToRouter(routerB, pingPacket("A", "B"), DefaultRequestTimeout); requestErr != nil {
+		t.Fatal(requestErr)
+	}
+}
+
+func TestSessionMultiplexesRequests(t *testing.T) {
+	workers := SessionWorkers
+	SessionWorkers = 2
+	defer func() { SessionWorkers = workers }()
+	handlerA := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handlerB := BuildHandler(&Router{Name: "B", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	routerB := &Router{Name: "B", Hostname: "127.0.0.1", Port: serveSessions(t, handlerB)}
+	defer handlerA.sessionManager.CloseAll()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			request := pingPacket("A", "B")
+			response, requestErr := handlerA.SendRequestToRouter(routerB, request, DefaultRequestTimeout)
+			if requestErr != nil {
+				t.Error(requestErr)
+				return
+			}
+			if response.GetPingResponse() == nil || response.GetHeader().Id != request.GetHeader().Id {
+				t.Errorf("unexpected response to #%s: %v", request.GetHeader().Id, response)
+			}
+		}()
+	}
+	wait.Wait()
+	handlerA.sessionManager.lock.Lock()
+	defer handlerA.sessionManager.lock.Unlock()
+	if sessions := len(handlerA.sessionManager.sessions); sessions != 1 {
+		t.Fatalf("expected every request to share one session, got %d", sessions)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Settings contains the tunable parameters of the definer
+type Settings struct {
+	XMLName           xml.Name `xml:"settings"`
+	MaxFrameSize      int      `xml:"maxframesize"`
+	PacketCacheTTL    int      `xml:"packetcachettl"`
+	PacketCacheSize   int      `xml:"packetcachesize"`
+	MaxHops           int      `xml:"maxhops"`
+	AdvertiseInterval int      `xml:"advertiseinterval"`
+	DiscoveryInterval int      `xml:"discoveryinterval"`
+	HeartbeatInterval int      `xml:"heartbeatinterval"`
+	HeartbeatMisses   int      `xml:"heartbeatmisses"`
+	MaxPending        int      `xml:"maxpending"`
+	OutboxExpiry      int      `xml:"outboxexpiry"`
+	OutboxDepth       int      `xml:"outboxdepth"`
+	OutboxPath        string   `xml:"outboxpath"`
+	DispatchRate      int      `xml:"dispatchrate"`
+	DispatchBurst     int      `xml:"dispatchburst"`
+	DispatchDepth     int      `xml:"dispatchdepth"`
+	SchemaPath        string   `xml:"schemapath"`
+	RulesPath         string   `xml:"rulespath"`
+	SchedulePath      string   `xml:"schedulepath"`
+	Latitude          float64  `xml:"latitude"`
+	Longitude         float64  `xml:"longitude"`
+}
+
+// BuildSettings returns a Settings struct populated
+// with the default values
+func BuildSettings() *Settings {
+	return &Settings{
+		MaxFrameSize:      DefaultMaxFrameSize,
+		PacketCacheTTL:    int(DefaultPacketCacheTTL / time.Second),
+		PacketCacheSize:   DefaultPacketCacheSize,
+		MaxHops:           DefaultMaxHops,
+		AdvertiseInterval: int(DefaultAdvertiseInterval / time.Second),
+		DiscoveryInterval: int(DefaultDiscoveryInterval / time.Second),
+		HeartbeatInterval: int(DefaultHeartbeatInterval / time.Second),
+		HeartbeatMisses:   DefaultHeartbeatMisses,
+		MaxPending:        DefaultMaxPendingDevices,
+		OutboxExpiry:      int(DefaultOutboxExpiry / time.Second),
+		OutboxDepth:       DefaultOutboxDepth,
+		DispatchRate:      DefaultDispatchRate,
+		DispatchBurst:     DefaultDispatchBurst,
+		DispatchDepth:     DefaultDispatchDepth,
+		SchemaPath:        SchemaPath,
+		RulesPath:         RulesPath,
+		SchedulePath:      SchedulePath,
+	}
+}
+
+// Apply pushes the settings out to the parts of the
+// definer that they configure. Durations are in seconds. A
+// dispatch rate of zero or less doesn't limit commands at all.
+func (settings *Settings) Apply() {
+	if settings.MaxFrameSize > 0 {
+		MaxFrameSize = settings.MaxFrameSize
+	}
+	if settings.PacketCacheTTL > 0 {
+		PacketCacheTTL = time.Duration(settings.PacketCacheTTL) * time.Second
+	}
+	if settings.PacketCacheSize > 0 {
+		PacketCacheSize = settings.PacketCacheSize
+	}
+	if settings.MaxHops > 0 {
+		MaxHops = settings.MaxHops
+	}
+	if settings.AdvertiseInterval > 0 {
+		AdvertiseInterval = time.Duration(settings.AdvertiseInterval) * time.Second
+	}
+	if settings.DiscoveryInterval > 0 {
+		DiscoveryInterval = time.Duration(settings.DiscoveryInterval) * time.Second
+	}
+	if settings.HeartbeatInterval > 0 {
+		HeartbeatInterval = time.Duration(settings.HeartbeatInterval) * time.Second
+	}
+	if settings.HeartbeatMisses > 0 {
+		HeartbeatMisses = settings.HeartbeatMisses
+	}
+	if settings.MaxPending > 0 {
+		MaxPendingDevices = settings.MaxPending
+	}
+	if settings.OutboxExpiry > 0 {
+		OutboxExpiry = time.Duration(settings.OutboxExpiry) * time.Second
+	}
+	if settings.OutboxDepth > 0 {
+		OutboxDepth = settings.OutboxDepth
+	}
+	OutboxPath = settings.OutboxPath
+	DispatchRate = settings.DispatchRate
+	if settings.DispatchBurst > 0 {
+		DispatchBurst = settings.DispatchBurst
+	}
+	if settings.DispatchDepth > 0 {
+		DispatchDepth = settings.DispatchDepth
+	}
+	if settings.SchemaPath != "" {
+		SchemaPath = settings.SchemaPath
+	}
+	if settings.RulesPath != "" {
+		RulesPath = settings.RulesPath
+	}
+	if settings.SchedulePath != "" {
+		SchedulePath = settings.SchedulePath
+	}
+	Latitude = settings.Latitude
+	Longitude = settings.Longitude
+}
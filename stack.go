@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	stackWifi      = "wifi"
+	stackBluetooth = "blue"
+)
+
+var (
+	stacksLock sync.RWMutex
+	// stacks holds the registered stacks keyed by the
+	// name devices refer to them by in the config
+	stacks = map[string]Stack{}
+)
+
+// Stack is a transport the definer can reach devices over.
+// Implementations register themselves with RegisterStack under
+// the name used in a device's stack field.
+type Stack interface {
+	Dial(device *Device) (StackConn, error)
+}
+
+// StackConn is an open connection to a single device. Each
+// Send and Receive carries exactly one packet.
+type StackConn interface {
+	Send(data []byte) error
+	Receive() ([]byte, error)
+	Close() error
+}
+
+func init() {
+	RegisterStack(stackWifi, &WifiStack{})
+}
+
+// RegisterStack makes the stack available to devices under the
+// given name, replacing any stack already registered under it.
+func RegisterStack(name string, stack Stack) {
+	stacksLock.Lock()
+	defer stacksLock.Unlock()
+	stacks[name] = stack
+}
+
+// UnregisterStack removes the stack registered under the name
+func UnregisterStack(name string) {
+	stacksLock.Lock()
+	defer stacksLock.Unlock()
+	delete(stacks, name)
+}
+
+// GetStack returns the stack registered under the name
+func GetStack(name string) (Stack, error) {
+	stacksLock.RLock()
+	defer stacksLock.RUnlock()
+	stack, ok := stacks[name]
+	if !ok {
+		return nil, errors.New("stack: unidentified stack " + name)
+	}
+	return stack, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeviceDialsItsStack(t *testing.T) {
+	device := &Device{ID: "d1", Address: "a", Stack: stackMemory}
+	if _, dialErr := device.Dial(); dialErr == nil {
+		t.Fatal("expected dialing an unregistered stack to fail")
+	}
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	if sendErr := device.SendData([]byte("on")); sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	if data, receiveErr := endpoint.Receive(); receiveErr != nil || string(data) != "on" {
+		t.Fatalf("device received %q, %v", data, receiveErr)
+	}
+	stack.Detach("a")
+	if sendErr := device.SendData([]byte("off")); sendErr == nil {
+		t.Fatal("expected sending to a detached device to fail")
+	}
+}
+
+func TestSendOrQueueDataOverStack(t *testing.T) {
+	stack := attachMemoryStack(t)
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	device := &Device{ID: "d1", Address: "a", Stack: stackMemory}
+	handler.deviceManager.AddDevice(device)
+	if sendErr := handler.SendOrQueueData(device, []byte("on")); sendErr != errQueued {
+		t.Fatalf("expected data for a detached device to be queued, got %v", sendErr)
+	}
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handler.outbox.Wake(DeviceDestination(device.ID))
+	handler.outbox.flush()
+	if data, receiveErr := endpoint.Receive(); receiveErr != nil || string(data) != "on" {
+		t.Fatalf("device received %q, %v", data, receiveErr)
+	}
+	if status := handler.outbox.Status(); len(status) != 0 {
+		t.Fatalf("outbox should be empty: %v", status)
+	}
+}
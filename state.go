@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// TopicDeviceState is published when an attribute of a
+	// device's state changes
+	TopicDeviceState = "device.state"
+)
+
+// StateValue is the value a device last reported for one of its
+// attributes, such as whether it is on, a level or a sensor
+// reading, along with when it was read
+type StateValue struct {
+	Value   string
+	Updated time.Time
+}
+
+// DeviceState holds the last reported value of each of a
+// device's attributes
+type DeviceState map[string]StateValue
+
+// UpdateState records the attributes the device reported at the
+// given time and returns the ones whose value changed. Values read
+// before the ones already held are ignored so that updates arriving
+// out of order don't undo newer ones.
+func (manager *DeviceManager) UpdateState(id string, attributes map[string]string, reported time.Time) (map[string]string, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if _, ok := manager.devices[id]; !ok {
+		return nil, errors.New("state: no device " + id)
+	}
+	state, ok := manager.states[id]
+	if !ok {
+		state = DeviceState{}
+		manager.states[id] = state
+	}
+	changed := map[string]string{}
+	for key, value := range attributes {
+		current, known := state[key]
+		if known && reported.Before(current.Updated) {
+			continue
+		}
+		state[key] = StateValue{Value: value, Updated: reported}
+		if !known || current.Value != value {
+			changed[key] = value
+		}
+	}
+	return changed, nil
+}
+
+// GetState returns a copy of the state the device last reported
+func (manager *DeviceManager) GetState(id string) DeviceState {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	state := DeviceState{}
+	for key, value := range manager.states[id] {
+		state[key] = value
+	}
+	return state
+}
+
+// Keys returns the names of the attributes in the state, sorted
+func (state DeviceState) Keys() []string {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HandleDeviceStatePassive records the state pushed by a device
+// owned by the current definer
+func (handler *Handler) HandleDeviceStatePassive(packet *packets.Packet, writer io.Writer) error {
+	device := handler.deviceManager.GetDeviceByID(packet.GetHeader().Origin)
+	if device == nil {
+		return errors.New("state: ignoring state from unknown device " + packet.GetHeader().Origin)
+	}
+	return handler.recordState(device, packet.GetDeviceState())
+}
+
+// HandleDeviceStateRequest answers with the last state reported by
+// the requested devices, or by every device if none are named. The
+// devices themselves aren't contacted.
+func (handler *Handler) HandleDeviceStateRequest(packet *packets.Packet, writer io.Writer) error {
+	ids := packet.GetDeviceStateReq().Devices
+	if len(ids) == 0 {
+		for _, device := range handler.deviceManager.AllDevices() {
+			ids = append(ids, device.ID)
+		}
+	}
+	response := &packets.DeviceStateResponse{}
+	for _, id := range ids {
+		if handler.deviceManager.GetDeviceByID(id) == nil {
+			return handler.SendResponseError(errors.New("state: unknown device "+id), packet, writer)
+		}
+		record := &packets.DeviceStateResponse_Device{Id: id}
+		state := handler.deviceManager.GetState(id)
+		for _, key := range state.Keys() {
+			record.Attributes = append(record.Attributes, &packets.DeviceStateResponse_Attribute{
+				Key:     key,
+				Value:   state[key].Value,
+				Updated: state[key].Updated.UnixNano(),
+			})
+		}
+		response.Devices = append(response.Devices, record)
+	}
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_DeviceStateResponse{
+			DeviceStateResponse: response,
+		},
+	}, writer)
+}
+
+// recordState records the attributes listed in a state update
+func (handler *Handler) recordState(device *Device, update *packets.DeviceStatePassive) error {
+	attributes := map[string]string{}
+	for _, attribute := range update.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	return handler.updateState(device.ID, attributes, reportedAt(update.Timestamp))
+}
+
+// updateState records the attributes reported by the device and
+// publishes the ones that changed
+func (handler *Handler) updateState(id string, attributes map[string]string, reported time.Time) error {
+	changed, updateErr := handler.deviceManager.UpdateState(id, attributes, reported)
+	if updateErr != nil {
+		return updateErr
+	}
+	handler.publishState(id, changed, reported)
+	return nil
+}
+
+// publishState publishes the attributes of the device that changed
+func (handler *Handler) publishState(id string, changed map[string]string, reported time.Time) {
+	if len(changed) == 0 {
+		return
+	}
+	handler.events.Publish(Event{
+		Topic:   TopicDeviceState,
+		Subject: id,
+		Data:    changed,
+		Time:    reported,
+	})
+}
+
+// reportedAt converts a timestamp sent by a device. Devices that
+// don't keep time send zero, and no device may report the future.
+func reportedAt(timestamp int64) time.Time {
+	now := time.Now()
+	if timestamp == 0 {
+		return now
+	}
+	reported := time.Unix(0, timestamp)
+	if reported.After(now) {
+		return now
+	}
+	return reported
+}
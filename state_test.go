@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+func TestUpdateStateMergesAttributes(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Stack: "none"})
+	subscription := handler.events.Subscribe(TopicDeviceState)
+	first := time.Now().Add(-time.Minute)
+	published := func() map[string]string {
+		select {
+		case event := <-subscription.Events():
+			if event.Subject != "d1" {
+				t.Fatalf("unexpected event: %v", event)
+			}
+			return event.Data
+		default:
+			return nil
+		}
+	}
+
+	if updateErr := handler.updateState("d1", map[string]string{"power": "on", "level": "40"}, first); updateErr != nil {
+		t.Fatal(updateErr)
+	}
+	if changed := published(); len(changed) != 2 {
+		t.Fatalf("expected both attributes to be published, got %v", changed)
+	}
+	handler.updateState("d1", map[string]string{"power": "on", "level": "40"}, first.Add(time.Second))
+	if changed := published(); changed != nil {
+		t.Fatalf("an unchanged report was published: %v", changed)
+	}
+	handler.updateState("d1", map[string]string{"level": "80"}, first.Add(2*time.Second))
+	if changed := published(); len(changed) != 1 || changed["level"] != "80" {
+		t.Fatalf("expected only the level to be published, got %v", changed)
+	}
+	handler.updateState("d1", map[string]string{"level": "10"}, first)
+	if changed := published(); changed != nil {
+		t.Fatalf("an older report was published: %v", changed)
+	}
+
+	state := handler.deviceManager.GetState("d1")
+	if state["power"].Value != "on" || !state["power"].Updated.Equal(first.Add(time.Second)) {
+		t.Fatalf("expected the power to be kept with its latest report, got %v", state["power"])
+	}
+	if state["level"].Value != "80" || !state["level"].Updated.Equal(first.Add(2*time.Second)) {
+		t.Fatalf("expected the newest level, got %v", state["level"])
+	}
+	if updateErr := handler.updateState("d2", map[string]string{"power": "on"}, first); updateErr == nil {
+		t.Fatal("expected the state of an unknown device to be refused")
+	}
+}
+
+func TestStateRequestAnsweredFromRecords(t *testing.T) {
+	stack := attachMemoryStack(t)
+	endpoint := stack.Attach("a")
+	defer stack.Detach("a")
+	handler := BuildHandler(&Router{Name: "A", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Address: "a", Stack: stackMemory})
+	reported := time.Now().Add(-time.Minute)
+	handler.deviceManager.UpdateState("d1", map[string]string{"power": "on"}, reported)
+	writer := make(packetWriter, 2)
+
+	request := testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	request.Body = &packets.Packet_DeviceStateReq{DeviceStateReq: &packets.DeviceStateRequest{Devices: []string{"d1"}}}
+	if handleErr := handler.Handle(request, writer); handleErr != nil {
+		t.Fatal(handleErr)
+	}
+	response := (<-writer).GetDeviceStateResponse()
+	if response == nil || len(response.Devices) != 1 || len(response.Devices[0].Attributes) != 1 {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if attribute := response.Devices[0].Attributes[0]; attribute.Key != "power" || attribute.Value != "on" || attribute.Updated != reported.UnixNano() {
+		t.Fatalf("unexpected attribute: %v", attribute)
+	}
+	if len(endpoint.toDevice) != 0 {
+		t.Fatal("the device was contacted to answer the request")
+	}
+
+	request = testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	request.Body = &packets.Packet_DeviceStateReq{DeviceStateReq: &packets.DeviceStateRequest{Devices: []string{"d2"}}}
+	handler.Handle(request, writer)
+	if response := <-writer; response.GetErrorResponse() == nil {
+		t.Fatalf("expected an unknown device to be refused, got %v", response)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ottopress/definer/protos"
+)
+
+var (
+	// subscribableTopics are the topics clients may subscribe to
+	subscribableTopics = map[string]bool{
+		TopicDeviceState:    true,
+		TopicDeviceLiveness: true,
+		TopicDeviceResponse: true,
+		TopicDeviceTransfer: true,
+		TopicRouterLiveness: true,
+		TopicRouterConfig:   true,
+	}
+
+	errSubscribeSession = errors.New("subscribe: subscriptions need a persistent connection")
+)
+
+// EventSubscribers tracks the clients that have subscribed to events
+// over their sessions. It is safe for concurrent use.
+type EventSubscribers struct {
+	lock    sync.Mutex
+	clients map[*Session]*eventClient
+}
+
+// eventClient is a session subscribed to events along with who
+// to address the events pushed over it to
+type eventClient struct {
+	origin       string
+	topics       map[string]bool
+	subscription *Subscription
+}
+
+// BuildEventSubscribers returns an EventSubscribers without any clients
+func BuildEventSubscribers() *EventSubscribers {
+	return &EventSubscribers{clients: map[*Session]*eventClient{}}
+}
+
+// HandleSubscribeRequest starts pushing the events on the requested
+// topics to the client over the session the request arrived on
+func (handler *Handler) HandleSubscribeRequest(packet *packets.Packet, writer io.Writer) error {
+	session, ok := writer.(*Session)
+	if !ok {
+		return handler.SendResponseError(errSubscribeSession, packet, writer)
+	}
+	topics := packet.GetSubscribeReq().Topics
+	if len(topics) == 0 {
+		return handler.SendResponseError(errors.New("subscribe: no topics given"), packet, writer)
+	}
+	for _, topic := range topics {
+		if !subscribableTopics[topic] {
+			return handler.SendResponseError(errors.New("subscribe: unknown topic "+topic), packet, writer)
+		}
+	}
+	subscribed := handler.subscribe(session, packet.GetHeader().Origin, topics)
+	Info.Println("subscribe: " + session.RemoteAddr() + " subscribed to " + packet.GetSubscribeReq().String())
+	return handler.sendSubscribeResponse(subscribed, packet, writer)
+}
+
+// HandleUnsubscribeRequest stops pushing the events on the given
+// topics, or on every topic if none are given, to the client
+func (handler *Handler) HandleUnsubscribeRequest(packet *packets.Packet, writer io.Writer) error {
+	session, ok := writer.(*Session)
+	if !ok {
+		return handler.SendResponseError(errSubscribeSession, packet, writer)
+	}
+	subscribed := handler.unsubscribe(session, packet.GetUnsubscribeReq().Topics)
+	return handler.sendSubscribeResponse(subscribed, packet, writer)
+}
+
+// HandleEventPassive logs an event pushed by a definer the current
+// one has subscribed to. Events from other definers aren't published
+// again, so subscriptions between definers can't loop.
+func (handler *Handler) HandleEventPassive(packet *packets.Packet, writer io.Writer) error {
+	Info.Println("subscribe: event from " + packet.GetHeader().Origin + ": " + packet.GetEvent().String())
+	return nil
+}
+
+// subscribe adds the topics to the client's subscription, starting
+// one if the client has none yet, and returns every topic the
+// client is subscribed to
+func (handler *Handler) subscribe(session *Session, origin string, topics []string) []string {
+	subscribers := handler.subscribers
+	subscribers.lock.Lock()
+	defer subscribers.lock.Unlock()
+	client, ok := subscribers.clients[session]
+	if !ok {
+		client = &eventClient{
+			topics:       map[string]bool{},
+			subscription: handler.events.Subscribe(topics...),
+		}
+		subscribers.clients[session] = client
+		go handler.pushEvents(session, client.subscription)
+	}
+	client.origin = origin
+	for _, topic := range topics {
+		client.topics[topic] = true
+	}
+	subscribed := client.subscribed()
+	handler.events.SetTopics(client.subscription, subscribed...)
+	return subscribed
+}
+
+// unsubscribe removes the topics from the client's subscription,
+// ending it if no topics are left, and returns every topic the
+// client is still subscribed to
+func (handler *Handler) unsubscribe(session *Session, topics []string) []string {
+	subscribers := handler.subscribers
+	subscribers.lock.Lock()
+	defer subscribers.lock.Unlock()
+	client, ok := subscribers.clients[session]
+	if !ok {
+		return []string{}
+	}
+	if len(topics) == 0 {
+		client.topics = map[string]bool{}
+	}
+	for _, topic := range topics {
+		delete(client.topics, topic)
+	}
+	subscribed := client.subscribed()
+	if len(subscribed) == 0 {
+		delete(subscribers.clients, session)
+		handler.events.Unsubscribe(client.subscription)
+		return subscribed
+	}
+	handler.events.SetTopics(client.subscription, subscribed...)
+	return subscribed
+}
+
+// pushEvents writes every event delivered on the subscription to the
+// session until the subscription ends or the session is closed
+func (handler *Handler) pushEvents(session *Session, subscription *Subscription) {
+	for {
+		select {
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if writeErr := handler.WriteProto(handler.buildEventPassive(session, event), session); writeErr != nil {
+				Warning.Println("subscribe: couldn't push event to " + session.RemoteAddr() + ": " + writeErr.Error())
+				session.Close()
+			}
+		case <-session.Done():
+			handler.unsubscribe(session, nil)
+			return
+		}
+	}
+}
+
+// buildEventPassive packages the event up to be pushed to the client
+func (handler *Handler) buildEventPassive(session *Session, event Event) *packets.Packet {
+	handler.subscribers.lock.Lock()
+	origin := ""
+	if client, ok := handler.subscribers.clients[session]; ok {
+		origin = client.origin
+	}
+	handler.subscribers.lock.Unlock()
+	body := &packets.EventPassive{
+		Topic:     event.Topic,
+		Subject:   event.Subject,
+		Timestamp: event.Time.UnixNano(),
+	}
+	for _, key := range sortedKeys(event.Data) {
+		body.Data = append(body.Data, &packets.EventPassive_Entry{Key: key, Value: event.Data[key]})
+	}
+	return &packets.Packet{
+		Header: &packets.Packet_Header{
+			Origin:      handler.router.GetName(),
+			Destination: origin,
+			Id:          NewPacketID(),
+			Type:        packets.Packet_Header_PASSIVE,
+		},
+		Body: &packets.Packet_Event{
+			Event: body,
+		},
+	}
+}
+
+func (handler *Handler) sendSubscribeResponse(topics []string, packet *packets.Packet, writer io.Writer) error {
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_SubscribeResponse{
+			SubscribeResponse: &packets.SubscribeResponse{
+				Topics: topics,
+			},
+		},
+	}, writer)
+}
+
+// subscribed returns the topics the client is subscribed to, sorted
+func (client *eventClient) subscribed() []string {
+	topics := make([]string, 0, len(client.topics))
+	for topic := range client.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
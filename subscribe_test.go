@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+func TestSubscriptionPushesEvents(t *testing.T) {
+	handler := startTestHandler(t, "A")
+	conn, phone := dialPhone(t, handler)
+	next := func(what string) *packets.Packet {
+		select {
+		case packet := <-phone:
+			return packet
+		case <-time.After(time.Second):
+			t.Fatalf("%s never reached the phone", what)
+			return nil
+		}
+	}
+	request := testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	request.Body = &packets.Packet_SubscribeReq{SubscribeReq: &packets.SubscribeRequest{Topics: []string{TopicDeviceState}}}
+	writePacket(t, conn, request)
+	if response := next("the subscribe response").GetSubscribeResponse(); response == nil || len(response.Topics) != 1 {
+		t.Fatalf("unexpected subscribe response: %v", response)
+	}
+
+	handler.events.Publish(Event{Topic: TopicDeviceLiveness, Subject: "d1"})
+	handler.events.Publish(Event{Topic: TopicDeviceState, Subject: "d1", Data: map[string]string{"power": "on"}})
+	packet := next("the event")
+	if event := packet.GetEvent(); event == nil || event.Topic != TopicDeviceState || event.Subject != "d1" || len(event.Data) != 1 {
+		t.Fatalf("unexpected event: %v", packet)
+	}
+	if header := packet.GetHeader(); header.Type != packets.Packet_Header_PASSIVE || header.Destination != "phone" {
+		t.Fatalf("unexpected event header: %v", header)
+	}
+
+	request = testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	request.Body = &packets.Packet_UnsubscribeReq{UnsubscribeReq: &packets.UnsubscribeRequest{}}
+	writePacket(t, conn, request)
+	if response := next("the unsubscribe response").GetSubscribeResponse(); response == nil || len(response.Topics) != 0 {
+		t.Fatalf("unexpected unsubscribe response: %v", response)
+	}
+	handler.events.Publish(Event{Topic: TopicDeviceState, Subject: "d1", Data: map[string]string{"power": "off"}})
+	select {
+	case packet := <-phone:
+		t.Fatalf("an event was pushed after unsubscribing: %v", packet)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionEndsWithSession(t *testing.T) {
+	handler := startTestHandler(t, "A")
+	conn, phone := dialPhone(t, handler)
+	request := testPacket(handler, "phone", packets.Packet_Header_REQUEST)
+	request.Body = &packets.Packet_SubscribeReq{SubscribeReq: &packets.SubscribeRequest{Topics: []string{TopicDeviceState}}}
+	writePacket(t, conn, request)
+	<-phone
+	handler.subscribers.lock.Lock()
+	clients := len(handler.subscribers.clients)
+	handler.subscribers.lock.Unlock()
+	if clients != 1 {
+		t.Fatalf("expected one subscribed client, got %d", clients)
+	}
+
+	conn.Close()
+	waitFor(t, "the subscription to end", func() bool {
+		handler.subscribers.lock.Lock()
+		defer handler.subscribers.lock.Unlock()
+		handler.events.lock.RLock()
+		defer handler.events.lock.RUnlock()
+		return len(handler.subscribers.clients) == 0 && len(handler.events.subscriptions) == 0
+	})
+}
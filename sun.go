@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	sunrise = "sunrise"
+	sunset  = "sunset"
+
+	// julianUnixEpoch is the Julian date of the Unix epoch
+	julianUnixEpoch = 2440587.5
+	// julian2000 is the Julian date of the J2000 epoch
+	julian2000 = 2451545.0
+	// sunAltitude is the altitude of the sun's center at sunrise
+	// and sunset, allowing for refraction and the sun's radius
+	sunAltitude = -0.833
+	// earthTilt is the obliquity of the ecliptic
+	earthTilt = 23.4397
+)
+
+var (
+	// Latitude is the latitude of the definer in degrees,
+	// north positive, used to work out sunrise and sunset
+	Latitude = 0.0
+	// Longitude is the longitude of the definer in degrees,
+	// east positive, used to work out sunrise and sunset
+	Longitude = 0.0
+)
+
+// located reports whether the definer's coordinates have been
+// configured. 0,0 is open ocean, so it is taken to mean they haven't.
+func located() bool {
+	return Latitude != 0 || Longitude != 0
+}
+
+// SunEvent works out when the sun rises or sets at the coordinates
+// on the calendar day of the given time, returning it in the time's
+// location. It fails on days the sun doesn't rise or set at all,
+// as happens near the poles. It is accurate to about a minute.
+func SunEvent(day time.Time, event string, latitude float64, longitude float64) (time.Time, bool) {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	days := math.Round(float64(noon.Unix())/86400 + julianUnixEpoch - julian2000)
+	meanNoon := days - longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*sinDegrees(anomaly) + 0.02*sinDegrees(2*anomaly) + 0.0003*sinDegrees(3*anomaly)
+	eclipticLongitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := julian2000 + meanNoon + 0.0053*sinDegrees(anomaly) - 0.0069*sinDegrees(2*eclipticLongitude)
+	sinDeclination := sinDegrees(eclipticLongitude) * sinDegrees(earthTilt)
+	cosDeclination := math.Cos(math.Asin(sinDeclination))
+	cosHourAngle := (sinDegrees(sunAltitude) - sinDegrees(latitude)*sinDeclination) / (cosDegrees(latitude) * cosDeclination)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	julian := transit + hourAngle/360
+	if event == sunrise {
+		julian = transit - hourAngle/360
+	}
+	seconds := (julian - julianUnixEpoch) * 86400
+	return time.Unix(int64(math.Round(seconds)), 0).In(day.Location()), true
+}
+
+func sinDegrees(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cosDegrees(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		day       string
+		event     string
+		latitude  float64
+		longitude float64
+		expected  string
+	}{
+		{"london sunrise at midsummer", "2026-06-21", sunrise, 51.5074, -0.1278, "03:43"},
+		{"london sunset at midsummer", "2026-06-21", sunset, 51.5074, -0.1278, "20:21"},
+		{"sydney sunrise at midsummer", "2026-12-21", sunrise, -33.8688, 151.2093, "18:41"},
+		{"tromso has no sunset at midsummer", "2026-06-21", sunset, 69.6492, 18.9553, ""},
+		{"tromso has no sunrise at midwinter", "2026-12-21", sunrise, 69.6492, 18.9553, ""},
+	}
+	for _, test := range tests {
+		day, _ := time.Parse("2006-01-02", test.day)
+		event, ok := SunEvent(day, test.event, test.latitude, test.longitude)
+		if test.expected == "" {
+			if ok {
+				t.Errorf("%s: expected no %s, got %v", test.name, test.event, event)
+			}
+			continue
+		}
+		expected, _ := time.Parse("2006-01-02 15:04", test.day+" "+test.expected)
+		if test.longitude > 90 {
+			// East of here the event falls on the UTC day before
+			expected = expected.AddDate(0, 0, -1)
+		}
+		if difference := event.Sub(expected); !ok || difference < -2*time.Minute || difference > 2*time.Minute {
+			t.Errorf("%s: expected about %v, got %v (%v)", test.name, expected, event, ok)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ottopress/definer/protos"
+)
+
+const (
+	// transferHoldTimeout is how long the owner of a device holds
+	// it for a prepared transfer that hasn't been committed
+	transferHoldTimeout = 3 * DefaultRequestTimeout
+	// transferUnknownDevice is the error message an owner answers
+	// with when it doesn't have the device being claimed
+	transferUnknownDevice = "transfer: unknown device"
+)
+
+var (
+	// transferAttempts is how many times each step of a transfer
+	// is tried before the transfer is abandoned
+	transferAttempts = 8
+
+	errTransferUnowned = errors.New("transfer: device isn't owned by the definer")
+)
+
+// DeviceTransfers tracks the device transfers the current definer
+// is part of. As the new owner it keeps the devices it is claiming
+// so they are never lost while the old owner is being asked for
+// them. As the old owner it holds devices prepared for transfer and
+// remembers the ones it released so a lost response can be replayed.
+// It is safe for concurrent use.
+type DeviceTransfers struct {
+	lock      sync.Mutex
+	claiming  map[string]*PendingDevice
+	releasing map[string]*heldDevice
+	released  map[string]*heldDevice
+}
+
+// heldDevice is a device being released to another definer
+type heldDevice struct {
+	claimant string
+	expires  time.Time
+	response *packets.DeviceTransferResponse
+}
+
+// BuildDeviceTransfers returns a DeviceTransfers with no transfers
+func BuildDeviceTransfers() *DeviceTransfers {
+	return &DeviceTransfers{
+		claiming:  map[string]*PendingDevice{},
+		releasing: map[string]*heldDevice{},
+		released:  map[string]*heldDevice{},
+	}
+}
+
+// Claiming returns the devices being claimed from another
+// definer, oldest first
+func (transfers *DeviceTransfers) Claiming() []*PendingDevice {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	claiming := make([]*PendingDevice, 0, len(transfers.claiming))
+	for _, pending := range transfers.claiming {
+		claiming = append(claiming, pending)
+	}
+	sort.Slice(claiming, func(i, j int) bool {
+		return claiming[i].Requested.Before(claiming[j].Requested)
+	})
+	return claiming
+}
+
+// Releasing reports whether the device is being held for a transfer
+func (transfers *DeviceTransfers) Releasing(id string) bool {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	held, ok := transfers.releasing[id]
+	if ok && time.Now().After(held.expires) {
+		delete(transfers.releasing, id)
+		return false
+	}
+	return ok
+}
+
+// claim records that the device is being claimed. It reports
+// false if a claim for the device is already under way.
+func (transfers *DeviceTransfers) claim(pending *PendingDevice) bool {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	if _, ok := transfers.claiming[pending.Device.ID]; ok {
+		return false
+	}
+	transfers.claiming[pending.Device.ID] = pending
+	return true
+}
+
+func (transfers *DeviceTransfers) claimed(id string) {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	delete(transfers.claiming, id)
+}
+
+// hold marks the device as prepared for transfer to the claimant
+func (transfers *DeviceTransfers) hold(id string, claimant string) {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	transfers.releasing[id] = &heldDevice{claimant: claimant, expires: time.Now().Add(transferHoldTimeout)}
+}
+
+// release records the response given when the device was
+// released so that it can be replayed to the claimant
+func (transfers *DeviceTransfers) release(id string, claimant string, response *packets.DeviceTransferResponse) {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	delete(transfers.releasing, id)
+	now := time.Now()
+	for releasedID, held := range transfers.released {
+		if now.After(held.expires) {
+			delete(transfers.released, releasedID)
+		}
+	}
+	transfers.released[id] = &heldDevice{claimant: claimant, expires: now.Add(OutboxExpiry), response: response}
+}
+
+// replay returns the response given when the device was released
+// to the claimant, if it was
+func (transfers *DeviceTransfers) replay(id string, claimant string) *packets.DeviceTransferResponse {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	held, ok := transfers.released[id]
+	if !ok || held.claimant != claimant || time.Now().After(held.expires) {
+		return nil
+	}
+	return held.response
+}
+
+// forget drops any record of the device having been released,
+// once the current definer owns it again
+func (transfers *DeviceTransfers) forget(id string) {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	delete(transfers.released, id)
+	delete(transfers.releasing, id)
+}
+
+// ClaimDevice takes ownership of a device that may be owned by
+// another definer. The old owner is asked to prepare the transfer
+// and then to release the device along with its queued packets and
+// state; the device is only adopted once the old owner has let go
+// of it. If the transfer can't be completed the device goes back to
+// the pending list rather than being lost.
+func (handler *Handler) ClaimDevice(pending *PendingDevice) {
+	if !handler.transfers.claim(pending) {
+		Warning.Println("transfer: already claiming device " + pending.Device.ID)
+		return
+	}
+	defer handler.transfers.claimed(pending.Device.ID)
+	id, owner := pending.Device.ID, pending.PreviousOwner
+	Info.Println("transfer: claiming device " + id + " from " + owner)
+	prepared, prepareErr := handler.transferStep(owner, id, false)
+	if prepareErr == errTransferUnowned {
+		Info.Println("transfer: " + owner + " doesn't own device " + id + ", adopting it")
+		handler.adopt(pending.Device, nil)
+		return
+	}
+	if prepareErr != nil {
+		Error.Println("transfer: couldn't prepare transfer of device " + id + ": " + prepareErr.Error())
+		handler.requeuePending(pending)
+		return
+	}
+	released, commitErr := handler.transferStep(owner, id, true)
+	if commitErr == errTransferUnowned {
+		// The owner let go of the device between the two steps,
+		// so there is nothing left to hand over but its record
+		released = prepared
+	} else if commitErr != nil {
+		Error.Println("transfer: couldn't commit transfer of device " + id + ": " + commitErr.Error())
+		handler.requeuePending(pending)
+		return
+	}
+	mergeTransferRecord(pending.Device, released.GetDevice())
+	handler.adopt(pending.Device, released)
+	Info.Println("transfer: device " + id + " transferred from " + owner)
+	if announceErr := handler.announceTransfer(id); announceErr != nil {
+		Warning.Println("transfer: couldn't announce transfer of device " + id + ": " + announceErr.Error())
+	}
+}
+
+// requeuePending puts a device that couldn't be claimed back on the
+// pending list
+func (handler *Handler) requeuePending(pending *PendingDevice) {
+	if pendingErr := handler.deviceManager.AddPending(pending); pendingErr != nil {
+		Error.Println("transfer: device " + pending.Device.ID + " dropped: " + pendingErr.Error())
+	}
+}
+
+// transferStep sends a single transfer request to the owner,
+// retrying with backoff. errTransferUnowned is returned if the
+// owner doesn't have the device.
+func (handler *Handler) transferStep(owner string, id string, commit bool) (*packets.DeviceTransferResponse, error) {
+	var stepErr error
+	for attempt := 1; attempt <= transferAttempts; attempt++ {
+		response, requestErr := handler.SendRequest(&packets.Packet{
+			Header: &packets.Packet_Header{Destination: owner},
+			Body: &packets.Packet_DeviceTransferReq{
+				DeviceTransferReq: &packets.DeviceTransferRequest{
+					Device: id,
+					Commit: commit,
+				},
+			},
+		}, DefaultRequestTimeout)
+		if requestErr == nil {
+			return response.GetDeviceTransferResponse(), nil
+		}
+		if response.GetErrorResponse() != nil && response.GetErrorResponse().ErrorMessage == transferUnknownDevice {
+			return nil, errTransferUnowned
+		}
+		stepErr = requestErr
+		Debug.Println("transfer: attempt to transfer device " + id + " failed: " + requestErr.Error())
+		time.Sleep(outboxBackoff(attempt))
+	}
+	return nil, stepErr
+}
+
+// adopt adds the device, restores the state handed over with it
+// and queues the packets handed over with it for delivery
+func (handler *Handler) adopt(device *Device, released *packets.DeviceTransferResponse) {
+	if addErr := handler.deviceManager.AddDevice(device); addErr != nil {
+		handler.deviceManager.UpdateDevice(device)
+	}
+	handler.transfers.forget(device.ID)
+	if released != nil {
+		handler.restoreState(device.ID, released.State)
+		for _, data := range released.Queued {
+			handler.outbox.Enqueue(DeviceDestination(device.ID), data)
+		}
+	}
+	if device.Liveness.Seen() {
+		handler.publishLiveness(TopicDeviceLiveness, device.ID, StateOnline)
+	}
+}
+
+// HandleDeviceTransferRequest prepares or commits the transfer of a
+// device owned by the current definer to the requesting definer.
+func (handler *Handler) HandleDeviceTransferRequest(packet *packets.Packet, writer io.Writer) error {
+	body := packet.GetDeviceTransferReq()
+	claimant := packet.GetHeader().Origin
+	if body.Commit {
+		if replayed := handler.transfers.replay(body.Device, claimant); replayed != nil {
+			return handler.sendTransferResponse(replayed, packet, writer)
+		}
+	}
+	device := handler.deviceManager.GetDeviceByID(body.Device)
+	if device == nil {
+		return handler.SendResponseError(errors.New(transferUnknownDevice), packet, writer)
+	}
+	if !body.Commit {
+		Info.Println("transfer: holding device " + device.ID + " for " + claimant)
+		handler.transfers.hold(device.ID, claimant)
+		return handler.sendTransferResponse(&packets.DeviceTransferResponse{
+			Device: transferRecord(device),
+		}, packet, writer)
+	}
+	handler.transfers.hold(device.ID, claimant)
+	state := handler.deviceManager.GetState(device.ID)
+	handler.deviceManager.RemoveDevice(device.ID)
+	handler.dispatch.Forget(device.ID)
+	response := &packets.DeviceTransferResponse{
+		Device:   transferRecord(device),
+		Queued:   handler.outbox.Take(DeviceDestination(device.ID)),
+		State:    transferState(state),
+		Released: true,
+	}
+	handler.transfers.release(device.ID, claimant, response)
+	Info.Println("transfer: released device " + device.ID + " to " + claimant)
+	return handler.sendTransferResponse(response, packet, writer)
+}
+
+func (handler *Handler) sendTransferResponse(response *packets.DeviceTransferResponse, packet *packets.Packet, writer io.Writer) error {
+	return handler.WriteProto(&packets.Packet{
+		Header: handler.BuildResponseHeader(packet),
+		Body: &packets.Packet_DeviceTransferResponse{
+			DeviceTransferResponse: response,
+		},
+	}, writer)
+}
+
+// transferRecord describes the device for a DeviceTransferResponse
+func transferRecord(device *Device) *packets.DeviceTransferResponse_Record {
+	core, modifier := device.typeKeys()
+	return &packets.DeviceTransferResponse_Record{
+		Id:           device.ID,
+		Version:      device.Version,
+		Manufacturer: device.Manufacturer,
+		Core:         core,
+		Modifier:     modifier,
+		Stack:        device.Stack,
+		Address:      device.Address,
+		Port:         device.Port,
+	}
+}
+
+// transferState lists the device's state for a DeviceTransferResponse
+func transferState(state DeviceState) []*packets.DeviceTransferResponse_State {
+	attributes := []*packets.DeviceTransferResponse_State{}
+	for _, key := range state.Keys() {
+		attributes = append(attributes, &packets.DeviceTransferResponse_State{
+			Key:     key,
+			Value:   state[key].Value,
+			Updated: state[key].Updated.UnixNano(),
+		})
+	}
+	return attributes
+}
+
+// restoreState records the state handed over with the device,
+// keeping the time each attribute was reported
+func (handler *Handler) restoreState(id string, state []*packets.DeviceTransferResponse_State) {
+	changed := map[string]string{}
+	for _, attribute := range state {
+		attributes := map[string]string{attribute.Key: attribute.Value}
+		updated, stateErr := handler.deviceManager.UpdateState(id, attributes, reportedAt(attribute.Updated))
+		if stateErr != nil {
+			Error.Println("transfer: couldn't restore state of device " + id + ": " + stateErr.Error())
+			return
+		}
+		for key, value := range updated {
+			changed[key] = value
+		}
+	}
+	handler.publishState(id, changed, time.Now())
+}
+
+// mergeTransferRecord fills in the details the device didn't
+// announce itself from the old owner's record
+func mergeTransferRecord(device *Device, record *packets.DeviceTransferResponse_Record) {
+	if record == nil {
+		return
+	}
+	if device.Version == "" {
+		device.Version = record.Version
+	}
+	if device.Manufacturer == "" {
+		device.Manufacturer = record.Manufacturer
+	}
+	if device.Type == nil {
+		device.Type = &DeviceType{Core: record.Core, Modifier: record.Modifier}
+	}
+}
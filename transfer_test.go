@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ottopress/definer/protos"
+)
+
+// refuseCommits serves a definer that prepares the transfer of any
+// device but refuses to commit it, returning its port
+func refuseCommits(t *testing.T, name string) int {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				for {
+					data, readErr := ReadFrame(conn)
+					if readErr != nil {
+						return
+					}
+					request := &packets.Packet{}
+					if proto.Unmarshal(data, request) != nil || request.GetDeviceTransferReq() == nil {
+						continue
+					}
+					response := &packets.Packet{Header: &packets.Packet_Header{
+						Origin:      name,
+						Destination: request.GetHeader().Origin,
+						Id:          request.GetHeader().Id,
+						Type:        packets.Packet_Header_RESPONSE,
+					}}
+					if request.GetDeviceTransferReq().Commit {
+						response.Body = &packets.Packet_ErrorResponse{ErrorResponse: &packets.GeneralErrorResponse{ErrorMessage: "transfer: refused"}}
+					} else {
+						response.Body = &packets.Packet_DeviceTransferResponse{DeviceTransferResponse: &packets.DeviceTransferResponse{
+							Device: &packets.DeviceTransferResponse_Record{Id: request.GetDeviceTransferReq().Device},
+						}}
+					}
+					protoData, _ := proto.Marshal(response)
+					frame, _ := EncodeFrame(protoData)
+					conn.Write(frame)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// transferPacket returns a request from the claimant to prepare or
+// commit the transfer of the device
+func transferPacket(handler *Handler, claimant string, id string, commit bool) *packets.Packet {
+	packet := testPacket(handler, claimant, packets.Packet_Header_REQUEST)
+	packet.Body = &packets.Packet_DeviceTransferReq{DeviceTransferReq: &packets.DeviceTransferRequest{Device: id, Commit: commit}}
+	return packet
+}
+
+func TestClaimDeviceFromOwner(t *testing.T) {
+	handlerA, handlerB := startTestHandler(t, "A"), startTestHandler(t, "B")
+	handlerA.routerManager.AddRouter(handlerB.router)
+	handlerB.routerManager.AddRouter(handlerA.router)
+	defer handlerA.sessionManager.CloseAll()
+	defer handlerB.sessionManager.CloseAll()
+	reported := time.Now().Add(-time.Hour)
+	handlerB.deviceManager.AddDevice(&Device{ID: "d1", Manufacturer: "acme", Type: &DeviceType{Core: "light"}, Stack: "none"})
+	handlerB.deviceManager.UpdateState("d1", map[string]string{"power": "on"}, reported)
+	handlerB.outbox.Enqueue(DeviceDestination("d1"), []byte("queued"))
+
+	handlerA.ClaimDevice(&PendingDevice{Device: &Device{ID: "d1", Stack: "none"}, PreviousOwner: "B"})
+	device := handlerA.deviceManager.GetDeviceByID("d1")
+	if device == nil || device.Manufacturer != "acme" || device.Type.Core != "light" {
+		t.Fatalf("expected the device to be adopted with the owner's record, got %+v", device)
+	}
+	if state := handlerA.deviceManager.GetState("d1"); state["power"].Value != "on" || !state["power"].Updated.Equal(reported) {
+		t.Fatalf("expected the state to be handed over, got %v", state)
+	}
+	if queued := handlerA.outbox.Take(DeviceDestination("d1")); len(queued) != 1 || string(queued[0]) != "queued" {
+		t.Fatalf("expected the queued packets to be handed over, got %q", queued)
+	}
+	if handlerB.deviceManager.GetDeviceByID("d1") != nil || handlerB.transfers.replay("d1", "A") == nil {
+		t.Fatal("expected the old owner to have released the device")
+	}
+	if len(handlerA.transfers.Claiming()) != 0 {
+		t.Fatal("expected the claim to be finished")
+	}
+}
+
+func TestClaimDeviceRequeuedWhenCommitFails(t *testing.T) {
+	attempts := transferAttempts
+	transferAttempts = 1
+	defer func() { transferAttempts = attempts }()
+	handlerA := startTestHandler(t, "A")
+	handlerA.routerManager.AddRouter(&Router{Name: "B", Hostname: "127.0.0.1", Port: refuseCommits(t, "B")})
+	defer handlerA.sessionManager.CloseAll()
+
+	handlerA.ClaimDevice(&PendingDevice{Device: &Device{ID: "d1", Stack: "none"}, PreviousOwner: "B"})
+	if handlerA.deviceManager.GetDeviceByID("d1") != nil {
+		t.Fatal("a device that wasn't released was adopted")
+	}
+	if pending := handlerA.deviceManager.GetPending("d1"); pending == nil || pending.PreviousOwner != "B" {
+		t.Fatalf("expected the device back on the pending list, got %+v", pending)
+	}
+}
+
+func TestTransferCommitIsReplayed(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "B", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Stack: "none"})
+	handler.outbox.Enqueue(DeviceDestination("d1"), []byte("queued"))
+	writer := make(packetWriter, 4)
+
+	for _, commit := range []bool{false, true, true} {
+		if transferErr := handler.HandleDeviceTransferRequest(transferPacket(handler, "A", "d1", commit), writer); transferErr != nil {
+			t.Fatal(transferErr)
+		}
+	}
+	prepared, committed, replayed := <-writer, <-writer, <-writer
+	if response := prepared.GetDeviceTransferResponse(); response == nil || response.Released || response.GetDevice().Id != "d1" {
+		t.Fatalf("unexpected response to the prepare: %v", prepared)
+	}
+	if response := replayed.GetDeviceTransferResponse(); response == nil || !proto.Equal(committed.GetDeviceTransferResponse(), response) || len(response.Queued) != 1 {
+		t.Fatalf("expected the commit to be replayed, got %v then %v", committed, replayed)
+	}
+
+	handler.HandleDeviceTransferRequest(transferPacket(handler, "C", "d1", true), writer)
+	if other := <-writer; other.GetErrorResponse() == nil || other.GetErrorResponse().ErrorMessage != transferUnknownDevice {
+		t.Fatalf("the commit was replayed to another definer: %v", other)
+	}
+}
+
+func TestTransferAnnouncedOnlyByClaimant(t *testing.T) {
+	handler := BuildHandler(&Router{Name: "B", Setup: true}, BuildDeviceManager(), BuildRouterManager())
+	handler.deviceManager.AddDevice(&Device{ID: "d1", Stack: "none"})
+	announce := func(owner string) error {
+		packet := testPacket(handler, owner, packets.Packet_Header_PASSIVE)
+		packet.Body = &packets.Packet_DeviceTransfer{DeviceTransfer: &packets.DeviceTransferPassive{Device: "d1"}}
+		return handler.HandleDeviceTransferPassive(packet, nil)
+	}
+
+	if announce("C") == nil || handler.deviceManager.GetDeviceByID("d1") == nil {
+		t.Fatal("a definer the device wasn't released to took it")
+	}
+	handler.transfers.release("d1", "A", &packets.DeviceTransferResponse{Released: true})
+	announce("A")
+	if handler.deviceManager.GetDeviceByID("d1") != nil {
+		t.Fatal("expected the device to go to the definer it was released to")
+	}
+}
@@ -1,13 +1,8 @@
 package main
 
 import (
-	"encoding/binary"
-	"io"
 	"net"
 	"strconv"
-
-	"github.com/golang/protobuf/proto"
-	"github.com/ottopress/definer/protos"
 )
 
 // WifiServer represents a wifi-based communication system
@@ -16,8 +11,9 @@ type WifiServer struct {
 	router  *Router
 }
 
-// Listen beings listening for incoming protobuf packets and hands them
-// off for parsing.
+// Listen beings listening for incoming connections and opens a
+// persistent session for each one. Packets are streamed over the
+// session in both directions until the peer disconnects.
 func (wifiServ *WifiServer) Listen() {
 	ln, lnErr := net.Listen("tcp", ":" + strconv.Itoa(DefaultPort))
 	if lnErr != nil {
@@ -31,44 +27,6 @@ func (wifiServ *WifiServer) Listen() {
 			Error.Println("wifiserv: connection err: " + connErr.Error())
 			return
 		}
-		go func(conn net.Conn){
-			defer conn.Close()
-			wifiServ.handleProto(conn)
-		}(conn)
-	}
-}
-
-func (wifiServ *WifiServer) handleProto(conn net.Conn) {
-	protoData, protoReadErr := wifiServ.readProto(conn)
-	if protoReadErr != nil {
-		Error.Println("wifiserv: couldn't handle proto:", protoReadErr.Error())
-		return
-	}
-	protoPacket, protoParseErr := wifiServ.parseProto(protoData)
-	if protoParseErr != nil {
-		Error.Println("wifiserv: couldn't parse proto:", protoParseErr.Error())
-		return
+		go BuildSession(conn, wifiServ.handler).Serve()
 	}
-	handlerErr := wifiServ.handler.Handle(&protoPacket, conn)
-	if handlerErr != nil {
-		Error.Println("wifiserv: couldn't handle proto:", handlerErr)
-		return
-	}
-}
-
-func (wifiServ *WifiServer) readProto(reader io.Reader) ([]byte, error) {
-	packetLen := make([]byte, 2)
-	_, lenErr := reader.Read(packetLen)
-	if lenErr != nil {
-		return packetLen, lenErr
-	}
-	packetData := make([]byte, binary.BigEndian.Uint16(packetLen))
-	_, dataErr := reader.Read(packetData)
-	return packetData, dataErr
-}
-
-func (wifiServ *WifiServer) parseProto(protoData []byte) (packets.Packet, error) {
-	var packet packets.Packet
-	unmarshErr := proto.Unmarshal(protoData, &packet)
-	return packet,unmarshErr
 }
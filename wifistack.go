@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+)
+
+// WifiStack reaches devices over TCP at their address and port
+type WifiStack struct{}
+
+// wifiConn frames packets over a TCP connection to a device
+type wifiConn struct {
+	conn net.Conn
+}
+
+// Dial opens a TCP connection to the device
+func (stack *WifiStack) Dial(device *Device) (StackConn, error) {
+	conn, connErr := net.DialTimeout("tcp", net.JoinHostPort(device.Address, device.Port), DialTimeout)
+	if connErr != nil {
+		return nil, connErr
+	}
+	return &wifiConn{conn: conn}, nil
+}
+
+// Send writes the data to the device as a single frame
+func (wifi *wifiConn) Send(data []byte) error {
+	return WriteFrame(wifi.conn, data)
+}
+
+// Receive blocks until the device sends a frame
+func (wifi *wifiConn) Receive() ([]byte, error) {
+	return ReadFrame(wifi.conn)
+}
+
+// Close closes the connection to the device
+func (wifi *wifiConn) Close() error {
+	return wifi.conn.Close()
+}